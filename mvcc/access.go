@@ -0,0 +1,79 @@
+package mvcc
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// accessTracker хранит время последнего чтения каждого ключа (WithAccessTracking).
+//
+// Версии неизменяемы, поэтому отметки живут в отдельной структуре, а не
+// в versionedValue. Время логическое — монотонный счётчик чтений: для
+// ранжирования холодных ключей нужен только порядок, а не часы.
+type accessTracker[K comparable] struct {
+	clock atomic.Uint64
+	last  sync.Map // K → *atomic.Uint64
+}
+
+// touch отмечает чтение key. Повторные чтения — один атомарный Store
+// без аллокаций; аллоцирует только первое чтение ключа.
+func (a *accessTracker[K]) touch(key K) {
+	ts := a.clock.Add(1)
+	if p, ok := a.last.Load(key); ok {
+		p.(*atomic.Uint64).Store(ts)
+		return
+	}
+	p := new(atomic.Uint64)
+	p.Store(ts)
+	if prev, loaded := a.last.LoadOrStore(key, p); loaded {
+		prev.(*atomic.Uint64).Store(ts)
+	}
+}
+
+// lastAccess возвращает отметку последнего чтения key или 0, если его не читали.
+func (a *accessTracker[K]) lastAccess(key K) uint64 {
+	if p, ok := a.last.Load(key); ok {
+		return p.(*atomic.Uint64).Load()
+	}
+	return 0
+}
+
+// ColdestKeys возвращает до n живых ключей текущей версии, которые дольше
+// всего не читались через Get (никогда не читавшиеся — первыми). Основа
+// для LRU-вытеснения без списка в горячих данных версий. Без
+// WithAccessTracking возвращает nil.
+//
+// Отметки удалённых ключей попутно отбрасываются.
+func (m *MVCCMap[K, V]) ColdestKeys(n int) []K {
+	if m.access == nil || n <= 0 {
+		return nil
+	}
+	current := m.acquireCurrent()
+	defer current.refCount.Add(-1)
+
+	type keyAccess struct {
+		key K
+		ts  uint64
+	}
+	keys := make([]keyAccess, 0, current.size)
+	for k, vv := range current.data.Iterate {
+		if !vv.deleted {
+			keys = append(keys, keyAccess{k, m.access.lastAccess(k)})
+		}
+	}
+	m.access.last.Range(func(k, _ any) bool {
+		if vv, ok := current.data.Get(k.(K)); !ok || vv.deleted {
+			m.access.last.Delete(k)
+		}
+		return true
+	})
+
+	slices.SortFunc(keys, func(a, b keyAccess) int { return cmp.Compare(a.ts, b.ts) })
+	out := make([]K, 0, min(n, len(keys)))
+	for _, ka := range keys[:min(n, len(keys))] {
+		out = append(out, ka.key)
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package mvcc
+
+// AsyncCommitHook получает изменения каждого коммита вне горячего пути:
+// из отдельной горутины, строго в порядке версий.
+type AsyncCommitHook[K comparable, V any] func(versionID uint64, changes map[K]V, deletes []K)
+
+// OverflowPolicy определяет поведение ограниченной очереди при переполнении.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock — коммит ждёт места в очереди (backpressure). Хук
+	// получает каждый коммит; медленный хук замедляет писателей.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDrop — событие отбрасывается и учитывается в
+	// Stats.AsyncHookDropped; коммиты никогда не ждут хука.
+	OverflowDrop
+)
+
+// defaultAsyncQueueSize — ёмкость очереди WithAsyncCommitHook по умолчанию.
+const defaultAsyncQueueSize = 1024
+
+// asyncEvent — элемент очереди асинхронного хука.
+type asyncEvent[K comparable, V any] struct {
+	versionID uint64
+	changes   map[K]V
+	deletes   []K
+}
+
+// asyncQueue — очередь WithAsyncCommitHook с единственным потребителем.
+//
+// Постановка в очередь выполняется под m.mu (см. committed), поэтому
+// порядок в очереди совпадает с порядком версий, а один потребитель
+// сохраняет его при вызове хука. closed тоже защищён m.mu: Close закрывает
+// канал под мьютексом, и запоздавший коммит не пишет в закрытый канал.
+type asyncQueue[K comparable, V any] struct {
+	hook   AsyncCommitHook[K, V]
+	ch     chan asyncEvent[K, V]
+	done   chan struct{}
+	closed bool
+}
+
+// enqueueAsync ставит изменения версии в очередь асинхронного хука.
+// Вызывается под m.mu.
+func (m *MVCCMap[K, V]) enqueueAsync(versionID uint64, changes map[K]V, deletes []K) {
+	q := m.async
+	if q == nil || q.closed {
+		return
+	}
+	ev := asyncEvent[K, V]{versionID: versionID, changes: changes, deletes: deletes}
+	if m.cfg.asyncOverflow == OverflowDrop {
+		select {
+		case q.ch <- ev:
+		default:
+			m.stats.asyncDropped.Add(1)
+		}
+		return
+	}
+	q.ch <- ev
+}
+
+// run — потребитель очереди: вызывает хук по порядку, пока
+// очередь не закрыта и не вычерпана.
+func (q *asyncQueue[K, V]) run() {
+	defer close(q.done)
+	for ev := range q.ch {
+		q.hook(ev.versionID, ev.changes, ev.deletes)
+	}
+}
+
+// closeAsync закрывает очередь и ждёт, пока хук обработает оставшееся.
+func (m *MVCCMap[K, V]) closeAsync() {
+	q := m.async
+	if q == nil {
+		return
+	}
+	m.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	m.mu.Unlock()
+	<-q.done
+}
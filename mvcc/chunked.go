@@ -0,0 +1,101 @@
+package mvcc
+
+const (
+	// defaultCommitChunkSize — размер порции staging'а в ChunkedCommit.
+	defaultCommitChunkSize = 4096
+
+	// maxStagingAttempts — сколько раз ChunkedCommit пересобирает staging,
+	// если текущая версия сменилась, прежде чем откатиться к обычному commit.
+	maxStagingAttempts = 3
+)
+
+// commitChunked — коммит большого write set'а с ограниченным удержанием m.mu.
+//
+// Обычный commit клонирует карту и применяет все записи под мьютексом,
+// блокируя остальных писателей на O(|map| + |writes|). Здесь новая версия
+// собирается вне мьютекса в staging-карте: клон базовой версии и write
+// buffer порциями по ChunkSize ключей. Между порциями проверяем, не сменилась
+// ли текущая версия; если сменилась — staging устарел и собирается заново.
+//
+// Под m.mu остаются только проверка конфликтов, хук, штамповка времени
+// (всё O(|writes|)) и замена указателя — при условии, что база всё ещё
+// текущая. Атомарность для читателей сохраняется: staging-карта не видна
+// никому до Store, поэтому читатели видят либо старую версию, либо всю новую.
+//
+// Если за maxStagingAttempts попыток база каждый раз устаревала (плотный
+// поток других коммитов), откатываемся к обычному commit, чтобы не голодать.
+func (m *MVCCMap[K, V]) commitChunked(tx *Tx[K, V]) error {
+	chunk := tx.opts.ChunkSize
+	if chunk <= 0 {
+		chunk = defaultCommitChunkSize
+	}
+
+	for range maxStagingAttempts {
+		// База закреплена: её данные читаются вне мьютекса и не должны
+		// уйти в WithDataMapPool, даже если её сменит чужой коммит.
+		base := m.acquireCurrent()
+
+		// Ранний отказ без сборки staging: конфликт с базой не исчезнет.
+		// Проверка без отчёта — о конфликте сообщит validate под m.mu
+		// в commitLocked, один раз, а не на каждой попытке сборки.
+		if !m.prevalidate(tx, base) {
+			base.refCount.Add(-1)
+			return m.commitLocked(tx)
+		}
+		m.resolveMerges(tx, base) // база проверяется под m.mu ниже
+
+		staged, size, ok := m.stage(tx, base, chunk)
+		if !ok {
+			base.refCount.Add(-1)
+			continue // база устарела во время сборки
+		}
+
+		unlock := m.lockCommit()
+		base.refCount.Add(-1) // под m.mu база не сменится
+		if m.current.Load() != base {
+			unlock()
+			continue
+		}
+
+		// Авторитетная проверка с трассой и отчётом о конфликтах; база та
+		// же, что у prevalidate, поэтому конфликта здесь быть не должно.
+		if err := m.validate(tx, base); err != nil {
+			unlock()
+			return err
+		}
+		newVID := m.nextVersionID.Load() + 1
+		if err := m.runCommitHook(tx, newVID); err != nil {
+			unlock()
+			return err
+		}
+		m.stampCommitTS(staged, tx)
+		m.purgeTombstones(staged)
+		m.installVersion(newVID, staged, size)
+		m.committed(tx, newVID) // под m.mu: уведомления WatchKeys идут в порядке версий
+		unlock()
+		return nil
+	}
+
+	m.logger.Debug("chunked commit fell back to locked commit",
+		"txID", tx.id,
+		"attempts", maxStagingAttempts,
+	)
+	return m.commitLocked(tx)
+}
+
+// stage собирает staging-карту вне мьютекса. Возвращает ok == false,
+// если base перестала быть текущей версией во время сборки.
+func (m *MVCCMap[K, V]) stage(tx *Tx[K, V], base *version[K, V], chunk int) (Store[K, V], int, bool) {
+	staged := m.cloneData(base)
+	size := base.size
+
+	n := 0
+	for k, vv := range tx.writes {
+		size = m.applyWrite(staged, size, k, vv)
+		n++
+		if n%chunk == 0 && m.current.Load() != base {
+			return nil, 0, false
+		}
+	}
+	return staged, size, true
+}
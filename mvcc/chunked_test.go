@@ -0,0 +1,180 @@
+package mvcc_test
+
+import (
+	"context"
+	"mvcc-map/mvcc"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestChunkedCommit_OtherWritersProgress проверяет, что пока идёт
+// ChunkedCommit огромного write set'а, другие писатели продолжают
+// коммитить, а сам большой коммит применяется целиком.
+func TestChunkedCommit_OtherWritersProgress(t *testing.T) {
+	ctx := context.Background()
+	m := mvcc.NewMVCCMap[int, int](ctx, mvcc.WithGCInterval(10*time.Millisecond))
+	defer m.Close()
+
+	const bigKeys = 300_000
+
+	big := m.BeginTxWith(ctx, mvcc.TxOptions{ChunkedCommit: true, ChunkSize: 1024})
+	for i := range bigKeys {
+		_ = big.Put(i, i)
+	}
+
+	var (
+		bigDone     atomic.Bool
+		duringBig   atomic.Int64
+		wg          sync.WaitGroup
+		bigErr      error
+		smallErrors atomic.Int64
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		bigErr = big.Commit()
+		bigDone.Store(true)
+	}()
+
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; !bigDone.Load(); i++ {
+				tx := m.BeginTx(ctx)
+				_ = tx.Put(-1-w, i) // ключи не пересекаются с большим коммитом
+				if err := tx.Commit(); err != nil {
+					smallErrors.Add(1)
+					continue
+				}
+				if !bigDone.Load() {
+					duringBig.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if bigErr != nil {
+		t.Fatalf("chunked commit failed: %v", bigErr)
+	}
+	if n := smallErrors.Load(); n > 0 {
+		t.Errorf("%d small commits failed", n)
+	}
+	if duringBig.Load() == 0 {
+		t.Error("no other writer committed while the chunked commit was in progress")
+	}
+
+	check := m.BeginTx(ctx)
+	defer check.Rollback()
+	for _, k := range []int{0, bigKeys / 2, bigKeys - 1} {
+		if v, ok := check.Get(k); !ok || v != k {
+			t.Errorf("Get(%d) = %d, %v; want %d, true", k, v, ok, k)
+		}
+	}
+}
+
+// TestChunkedCommit_BoundsLockHold проверяет, что ChunkedCommit собирает
+// версию вне мьютекса коммита: дорогое кодирование значений не попадает
+// во время удержания m.mu, тогда как обычный коммит того же write set'а
+// держит мьютекс не меньше суммарной стоимости кодирования.
+func TestChunkedCommit_BoundsLockHold(t *testing.T) {
+	ctx := context.Background()
+	const (
+		keys      = 512
+		encodeFor = 20 * time.Microsecond
+		floor     = keys * encodeFor
+	)
+	commitWith := func(opts mvcc.TxOptions) mvcc.Stats {
+		m := mvcc.NewMVCCMap[int, int](ctx,
+			mvcc.WithManualGC(),
+			mvcc.WithValueCodec(
+				func(v int) int { time.Sleep(encodeFor); return v },
+				func(v int) int { return v },
+			),
+		)
+		defer m.Close()
+
+		tx := m.BeginTxWith(ctx, opts)
+		for i := range keys {
+			_ = tx.Put(i, i)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		check := m.BeginTx(ctx)
+		defer check.Rollback()
+		if v, ok := check.Get(keys - 1); !ok || v != keys-1 {
+			t.Fatalf("Get(%d) = %d, %v; want %d, true", keys-1, v, ok, keys-1)
+		}
+		return m.Stats()
+	}
+
+	locked := commitWith(mvcc.TxOptions{})
+	if held := time.Duration(locked.MaxCommitLockHeldNanos); held < floor {
+		t.Fatalf("locked commit held the mutex %v, want at least %v of encoding", held, floor)
+	}
+
+	chunked := commitWith(mvcc.TxOptions{ChunkedCommit: true, ChunkSize: 64})
+	if chunked.Commits != 1 {
+		t.Errorf("chunked commit took the mutex %d times, want 1", chunked.Commits)
+	}
+	if held := time.Duration(chunked.MaxCommitLockHeldNanos); held >= floor {
+		t.Errorf("chunked commit held the mutex %v, want less than the %v spent staging", held, floor)
+	}
+}
+
+// TestChunkedCommit_TracesChecksOnce проверяет, что пересборка staging'а
+// после чужого коммита не повторяет отчётную проверку конфликтов: трасса
+// получает проверку каждого ключа один раз, из-под мьютекса коммита.
+func TestChunkedCommit_TracesChecksOnce(t *testing.T) {
+	ctx := context.Background()
+	var (
+		m     *mvcc.MVCCMap[string, int]
+		racer atomic.Bool
+	)
+	racer.Store(true)
+	m = mvcc.NewMVCCMap[string, int](ctx,
+		mvcc.WithValueCodec(
+			func(v int) int {
+				// Первое кодирование staging'а коммитит чужой ключ:
+				// база устаревает, и ChunkedCommit собирает версию заново.
+				if racer.CompareAndSwap(true, false) {
+					other := m.BeginTx(ctx)
+					_ = other.Put("other", 1)
+					if err := other.Commit(); err != nil {
+						t.Errorf("racing commit: %v", err)
+					}
+				}
+				return v
+			},
+			func(v int) int { return v },
+		),
+	)
+	defer m.Close()
+
+	tx := m.BeginTxWith(ctx, mvcc.TxOptions{ChunkedCommit: true, ChunkSize: 1, Trace: true})
+	_ = tx.Put("a", 1)
+	_ = tx.Put("b", 2)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if racer.Load() {
+		t.Fatal("staging never ran the racing commit")
+	}
+
+	checks := map[string]int{}
+	for _, ev := range tx.Trace() {
+		if ev.Kind == mvcc.TraceWriteCheck {
+			checks[ev.Key]++
+		}
+	}
+	for _, key := range []string{"a", "b"} {
+		if checks[key] != 1 {
+			t.Errorf("trace has %d write checks of %s, want 1", checks[key], key)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package mvcc
+
+import "time"
+
+// Clock — источник времени и тикеров для фоновых циклов GC и детектора
+// дедлоков. По умолчанию используются time.Now и time.NewTicker;
+// WithClockSource подменяет их, например, фейковыми часами, которые
+// тикают по команде теста. Now отмечает и проверяет heartbeat циклов
+// для HealthCheck.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker — минимальное подмножество *time.Ticker, нужное фоновым циклам.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock — Clock поверх пакета time.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct{ t *time.Ticker }
+
+func (r realTicker) C() <-chan time.Time { return r.t.C }
+func (r realTicker) Stop()               { r.t.Stop() }
@@ -0,0 +1,217 @@
+package mvcc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock — Clock, время и тикеры которого сдвигаются только по Advance.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+	created chan struct{}
+}
+
+type fakeTicker struct {
+	period  time.Duration
+	elapsed time.Duration
+	c       chan time.Time
+	stopped atomic.Bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		created: make(chan struct{}, 16),
+	}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) Ticker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{period: d, c: make(chan time.Time, 1)}
+	f.tickers = append(f.tickers, t)
+	f.created <- struct{}{}
+	return t
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+func (t *fakeTicker) Stop()               { t.stopped.Store(true) }
+
+// Advance сдвигает часы на d; каждый тикер, чей период истёк, срабатывает
+// (как и у time.Ticker, пропущенные тики не накапливаются).
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	for _, t := range f.tickers {
+		if t.stopped.Load() {
+			continue
+		}
+		t.elapsed += d
+		if t.elapsed >= t.period {
+			t.elapsed %= t.period
+			select {
+			case t.c <- time.Time{}:
+			default:
+			}
+		}
+	}
+}
+
+// waitTickers ждёт создания n тикеров фоновыми горутинами.
+func (f *fakeClock) waitTickers(t *testing.T, n int) {
+	t.Helper()
+	for range n {
+		select {
+		case <-f.created:
+		case <-time.After(time.Second):
+			t.Fatal("background loops did not create their tickers")
+		}
+	}
+}
+
+// TestClockSource_DrivesBackgroundLoops проверяет, что фейковые часы
+// детерминированно запускают по одному проходу детектора и GC.
+func TestClockSource_DrivesBackgroundLoops(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	m := NewMVCCMap[string, int](ctx,
+		WithClockSource(clock),
+		WithGCInterval(time.Second),
+		WithDeadlockCheckInterval(100*time.Millisecond),
+	)
+	defer m.Close()
+	clock.waitTickers(t, 2)
+
+	for i := range 3 {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("k", i)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	older := m.BeginTx(ctx)
+	defer older.Rollback()
+	younger := m.BeginTx(ctx)
+	defer younger.Rollback()
+	m.setWaitFor(older.id, younger.id)
+	m.setWaitFor(younger.id, older.id)
+
+	// Тик детектора: жертва прерывается, GC не запускается.
+	clock.Advance(100 * time.Millisecond)
+	select {
+	case <-younger.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("detector pass did not abort the victim")
+	}
+	if runs := m.GCStats().Runs; runs != 0 {
+		t.Errorf("GC ran %d times before its tick", runs)
+	}
+	younger.Rollback()
+	older.Rollback()
+
+	// Тик GC: ровно один проход собирает устаревшие версии.
+	clock.Advance(900 * time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for m.GCStats().Runs == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("GC pass did not run after its tick")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if runs := m.GCStats().Runs; runs != 1 {
+		t.Errorf("GCStats().Runs = %d, want 1", runs)
+	}
+	if n := m.VersionCount(); n != 1 {
+		t.Errorf("VersionCount() = %d after GC pass, want 1", n)
+	}
+}
+
+// gcStaller — Scheduler, который по команде теста подвешивает проход GC.
+type gcStaller struct {
+	armed   atomic.Bool
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *gcStaller) Yield(p SchedulePoint, _ uint64) {
+	if p == PointGC && s.armed.CompareAndSwap(true, false) {
+		close(s.entered)
+		<-s.release
+	}
+}
+
+// TestHealthCheck_ReportsStalledLoop проверяет, что HealthCheck сообщает
+// о зависшем GC, когда часы WithClockSource ушли дальше порога, а проход
+// так и не завершился, выздоравливает после прохода и учитывает порог
+// версий и Close.
+func TestHealthCheck_ReportsStalledLoop(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	staller := &gcStaller{entered: make(chan struct{}), release: make(chan struct{})}
+	m := NewMVCCMap[string, int](ctx,
+		WithClockSource(clock),
+		WithScheduler(staller),
+		WithGCInterval(10*time.Millisecond),
+		WithManualDeadlockDetection(),
+		WithHealthThresholds(20*time.Millisecond, 3),
+	)
+	clock.waitTickers(t, 1)
+
+	if err := m.HealthCheck(); err != nil {
+		t.Fatalf("fresh map: HealthCheck() = %v", err)
+	}
+
+	// Проход GC зависает, а часы идут дальше порога.
+	staller.armed.Store(true)
+	clock.Advance(10 * time.Millisecond)
+	<-staller.entered
+	if err := m.HealthCheck(); err != nil {
+		t.Fatalf("within threshold: HealthCheck() = %v", err)
+	}
+	clock.Advance(15 * time.Millisecond)
+	if err := m.HealthCheck(); !errors.Is(err, ErrUnhealthy) {
+		t.Fatalf("stalled GC: HealthCheck() = %v, want ErrUnhealthy", err)
+	}
+
+	close(staller.release)
+	deadline := time.Now().Add(time.Second)
+	for m.HealthCheck() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("finished GC pass did not restore health: %v", m.HealthCheck())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Активная транзакция удерживает версии от GC.
+	reader := m.BeginTx(ctx)
+	for i := range 4 {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("k", i)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.HealthCheck(); !errors.Is(err, ErrUnhealthy) {
+		t.Errorf("version backlog: HealthCheck() = %v, want ErrUnhealthy", err)
+	}
+	reader.Rollback()
+
+	m.Close()
+	if err := m.HealthCheck(); !errors.Is(err, ErrClosed) {
+		t.Errorf("closed map: HealthCheck() = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,51 @@
+package mvcc
+
+import "context"
+
+// defaultTxContext объединяет контекст вызова BeginTx с контекстом
+// WithDefaultTxContext: значения ищутся сначала в ctx, затем в def;
+// отмена любого из них отменяет результат; дедлайн — более ранний из двух.
+// Отмена через def приходит как Canceled с причиной из def (например,
+// DeadlineExceeded) — см. Tx.ctxErr.
+// release освобождает ресурсы связи и должна быть вызвана по завершении
+// транзакции.
+func defaultTxContext(ctx, def context.Context) (context.Context, func()) {
+	if def == nil {
+		return ctx, func() {}
+	}
+
+	merged, cancel := context.WithCancelCause(mergedValues{Context: ctx, fallback: def})
+	stop := context.AfterFunc(def, func() { cancel(context.Cause(def)) })
+	if def.Err() != nil {
+		// AfterFunc сработает асинхронно; отменяем сразу, чтобы initTx
+		// увидел отмену до регистрации транзакции.
+		cancel(context.Cause(def))
+	}
+	release := func() {
+		stop()
+		cancel(nil)
+	}
+
+	if d, ok := def.Deadline(); ok {
+		// WithDeadline оставляет дедлайн ctx, если он раньше.
+		withDeadline, cancelDeadline := context.WithDeadline(merged, d)
+		return withDeadline, func() {
+			cancelDeadline()
+			release()
+		}
+	}
+	return merged, release
+}
+
+// mergedValues — ctx, значения которого дополняются значениями fallback.
+type mergedValues struct {
+	context.Context
+	fallback context.Context
+}
+
+func (c mergedValues) Value(key any) any {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.fallback.Value(key)
+}
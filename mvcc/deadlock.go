@@ -2,44 +2,91 @@ package mvcc
 
 import (
 	"context"
+	"fmt"
 	"time"
 )
 
 // runDeadlockDetector запускает периодическую проверку графа ожидания транзакций.
 //
 // Алгоритм: DFS по wait-for графу. Если обнаружен цикл —
-// прерываем "жертву" (транзакцию с наименьшим ID в цикле —
-// youngest-victim стратегия, минимизирует потери работы).
+// прерываем "жертву" (по умолчанию самую молодую транзакцию цикла —
+// youngest-victim стратегия, минимизирует потери работы; см. VictimStrategy).
 //
 // Почему периодически, а не на каждом lock? Накладные расходы
 // на проверку при каждой операции избыточны для in-memory системы.
 // Интервал настраивается через Option.
 func (m *MVCCMap[K, V]) runDeadlockDetector(ctx context.Context, interval time.Duration) {
-	ticker := time.NewTicker(interval)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := m.cfg.clockSource.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			m.detectDeadlocks()
+			m.detectorBeat.beat(m.cfg.clockSource.Now())
 		}
 	}
 }
 
-func (m *MVCCMap[K, V]) detectDeadlocks() {
+// DetectDeadlocksNow синхронно выполняет одну проверку графа ожидания.
+// Возвращает ID прерванной жертвы или 0, если цикл не найден.
+// Вместе с WithManualDeadlockDetection делает сценарии дедлоков
+// в тестах детерминированными.
+func (m *MVCCMap[K, V]) DetectDeadlocksNow() uint64 {
+	return m.detectDeadlocks()
+}
+
+// detectDeadlocks ищет цикл в графе ожидания и прерывает жертву.
+// Возвращает ID жертвы или 0 (в том числе если проход запаниковал:
+// паника перехватывается и для фонового цикла, и для DetectDeadlocksNow).
+func (m *MVCCMap[K, V]) detectDeadlocks() (victim uint64) {
+	m.guardPass("deadlock detector", func() { victim = m.detectPass() })
+	return victim
+}
+
+// detectPass — тело проверки графа ожидания.
+func (m *MVCCMap[K, V]) detectPass() uint64 {
+	m.yield(PointDeadlockCheck, 0)
 	m.activeTxsMu.RLock()
+	active := len(m.activeTxs)
+	limit := m.cfg.maxDetectionTxs
+	sampled := limit > 0 && active > limit
+	if !sampled {
+		limit = active
+	}
 	// Снимаем граф ожидания без мьютекса txMeta (достаточно RLock на map).
-	graph := make(map[uint64]uint64, len(m.activeTxs))
+	// Под перегрузкой обходим только limit записей: порядок обхода map
+	// случаен, так что выборка меняется от прохода к проходу.
+	graph := make(map[uint64]uint64, limit)
+	var wedged []*txMeta
+	now := time.Now()
+	seen := 0
 	for id, meta := range m.activeTxs {
+		if seen == limit {
+			break
+		}
+		seen++
 		meta.mu.Lock()
 		if meta.waitFor != 0 {
 			graph[id] = meta.waitFor
 		}
+		if m.cfg.victimGrace > 0 && !meta.abortedAt.IsZero() && now.Sub(meta.abortedAt) >= m.cfg.victimGrace {
+			wedged = append(wedged, meta)
+		}
 		meta.mu.Unlock()
 	}
 	m.activeTxsMu.RUnlock()
+	m.noteDetectionSampling(sampled, active)
+	m.forceRollbackVictims(wedged)
+	for _, meta := range wedged {
+		delete(graph, meta.id) // откаченная жертва больше никого не ждёт
+	}
 
 	// DFS для поиска циклов.
 	visited := make(map[uint64]bool)
@@ -69,38 +116,146 @@ func (m *MVCCMap[K, V]) detectDeadlocks() {
 	for id := range graph {
 		if !visited[id] {
 			if cycle := dfs(id); cycle != nil {
-				m.resolveDeadlock(cycle)
-				return // обрабатываем по одному циклу за итерацию
+				return m.resolveDeadlock(cycle) // обрабатываем по одному циклу за итерацию
 			}
 		}
 	}
+	return 0
 }
 
-// resolveDeadlock выбирает "жертву" и отменяет её транзакцию.
-// Youngest-victim: прерываем транзакцию с наибольшим ID
-// (самую молодую — она выполнила меньше всего работы).
-func (m *MVCCMap[K, V]) resolveDeadlock(cycle []uint64) {
-	var victim uint64
-	for _, id := range cycle {
-		if id > victim {
-			victim = id
+// noteDetectionSampling логирует вход детектора в режим выборки
+// (WithMaxActiveTxsForDetection) и выход из него.
+func (m *MVCCMap[K, V]) noteDetectionSampling(sampled bool, active int) {
+	if m.detectionSampled.Swap(sampled) == sampled {
+		return
+	}
+	if sampled {
+		m.logger.Warn("too many active transactions, deadlock detection degraded to sampling",
+			"active", active,
+			"limit", m.cfg.maxDetectionTxs,
+		)
+		return
+	}
+	m.logger.Info("deadlock detection back to the full wait-for graph", "active", active)
+}
+
+// VictimStrategy задаёт выбор жертвы при разрешении дедлока.
+type VictimStrategy int
+
+const (
+	// YoungestVictim — стратегия по умолчанию: прерывается транзакция
+	// с наибольшим ID (самая молодая — она выполнила меньше всего работы).
+	YoungestVictim VictimStrategy = iota
+
+	// LowestPriority прерывает транзакцию с наименьшим TxOptions.Priority
+	// независимо от возраста; при равных приоритетах — самую молодую.
+	LowestPriority
+)
+
+// resolveDeadlock выбирает "жертву" по WithVictimStrategy и отменяет
+// её транзакцию.
+func (m *MVCCMap[K, V]) resolveDeadlock(cycle []uint64) uint64 {
+	// Метаданные снимаем одним RLock: они нужны и для выбора жертвы,
+	// и для лога. Метки (TxOptions.Label) делают лог понятным без
+	// сопоставления ID с логическими операциями.
+	metas := make([]*txMeta, len(cycle))
+	labels := make([]string, len(cycle))
+	m.activeTxsMu.RLock()
+	for i, id := range cycle {
+		if meta, ok := m.activeTxs[id]; ok {
+			metas[i] = meta
+			labels[i] = meta.label
+		}
+	}
+	m.activeTxsMu.RUnlock()
+
+	var victim *txMeta
+	for _, meta := range metas {
+		if meta != nil && (victim == nil || m.preferVictim(meta, victim)) {
+			victim = meta
 		}
 	}
+	if victim == nil {
+		return 0 // все участники цикла уже завершились
+	}
 
 	m.logger.Warn("deadlock detected, aborting victim transaction",
 		"cycle", cycle,
-		"victim", victim,
+		"labels", labels,
+		"victim", victim.id,
+		"victim_label", victim.label,
+		"victim_priority", victim.priority,
 	)
 
-	m.activeTxsMu.RLock()
-	meta, ok := m.activeTxs[victim]
-	m.activeTxsMu.RUnlock()
+	victim.mu.Lock()
+	if victim.abortedAt.IsZero() {
+		victim.abortedAt = time.Now()
+	}
+	victim.mu.Unlock()
 
-	if ok {
-		meta.mu.Lock()
-		// Сигнализируем транзакции через cancel её контекста.
-		// Транзакция обнаружит отмену при следующем Put/Get/Commit.
+	// Сигнализируем транзакции через cancel её контекста с причиной
+	// ErrDeadlock. Транзакция обнаружит отмену при следующем Put/Commit.
+	victim.abort(fmt.Errorf("%w: aborted as victim of cycle %v (labels %q)", ErrDeadlock, cycle, labels))
+	return victim.id
+}
+
+// forceRollbackVictims откатывает жертвы дедлоков, не завершившиеся за
+// WithDeadlockVictimGrace: их горутины не реагируют на отмену, а снапшоты
+// и рёбра графа ожидания держатся. Откат снимает транзакцию из activeTxs
+// и освобождает снапшот, так что остальная система продолжает работу.
+//
+// Сам Tx детектор не трогает: он принадлежит зависшей горутине. Откат
+// идёт по txMeta через тот же флаг finalized, что и у владельца, поэтому
+// ресурсы освобождаются ровно один раз, а meta, устаревший после Reset,
+// новую транзакцию не затронет. Владелец при следующей операции получит
+// ErrDeadlock (reapForced). Транзакции, уже вошедшие в Commit, пропускаются.
+func (m *MVCCMap[K, V]) forceRollbackVictims(wedged []*txMeta) {
+	for _, meta := range wedged {
+		if !m.forceFinalize(meta) {
+			continue
+		}
+		m.logger.Warn("deadlock victim ignored cancellation, forcing rollback",
+			"tx", meta.id,
+			"label", meta.label,
+			"grace", m.cfg.victimGrace,
+		)
+	}
+}
+
+// forceFinalize освобождает ресурсы транзакции по её meta. Возвращает
+// false, если владелец успел завершить транзакцию сам или уже вошёл
+// в Commit: коммит доведёт до конца и освободит ресурсы его finalize.
+func (m *MVCCMap[K, V]) forceFinalize(meta *txMeta) bool {
+	meta.mu.Lock()
+	if meta.committing || !meta.finalized.CompareAndSwap(false, true) {
 		meta.mu.Unlock()
-		_ = meta // В реальной системе: вызов cancel() через сохранённую ссылку
+		return false
+	}
+	unpin := meta.unpin
+	// forcedErr публикуется под mu: enterCommit проверяет forced под ним же.
+	meta.forcedErr = fmt.Errorf("%w: victim tx %d rolled back after ignoring cancellation for %v",
+		ErrDeadlock, meta.id, m.cfg.victimGrace)
+	meta.forced.Store(true)
+	meta.mu.Unlock()
+
+	meta.release()
+
+	m.activeTxsMu.Lock()
+	if m.activeTxs[meta.id] == meta {
+		delete(m.activeTxs, meta.id)
+	}
+	m.activeTxsMu.Unlock()
+
+	// Владелец ещё может читать снапшот: карта не должна уйти в пул.
+	unpin()
+	m.releaseTxSlot()
+	return true
+}
+
+// preferVictim сообщает, является ли a более предпочтительной жертвой, чем b.
+func (m *MVCCMap[K, V]) preferVictim(a, b *txMeta) bool {
+	if m.cfg.victimStrategy == LowestPriority && a.priority != b.priority {
+		return a.priority < b.priority
 	}
+	return a.id > b.id // youngest-victim
 }
@@ -0,0 +1,371 @@
+package mvcc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setWaitFor добавляет ребро tx → waitFor в граф ожидания.
+func (m *MVCCMap[K, V]) setWaitFor(txID, waitFor uint64) {
+	m.activeTxsMu.RLock()
+	meta := m.activeTxs[txID]
+	m.activeTxsMu.RUnlock()
+
+	meta.mu.Lock()
+	meta.waitFor = waitFor
+	meta.mu.Unlock()
+}
+
+// TestManualDeadlockDetection_ChoosesVictimOnExplicitCall проверяет, что
+// в ручном режиме дедлок разрешается только явным DetectDeadlocksNow,
+// а жертвой становится самая молодая транзакция цикла.
+func TestManualDeadlockDetection_ChoosesVictimOnExplicitCall(t *testing.T) {
+	ctx := context.Background()
+	m := NewMVCCMap[string, int](ctx,
+		WithManualGC(),
+		WithManualDeadlockDetection(),
+		WithDeadlockCheckInterval(time.Millisecond),
+	)
+	defer m.Close()
+
+	older := m.BeginTx(ctx)
+	defer older.Rollback()
+	younger := m.BeginTx(ctx)
+	defer younger.Rollback()
+
+	m.setWaitFor(older.id, younger.id)
+	m.setWaitFor(younger.id, older.id)
+
+	// Фоновый детектор отключён: никто не должен быть прерван сам по себе.
+	time.Sleep(20 * time.Millisecond)
+	if err := younger.Put("k", 1); err != nil {
+		t.Fatalf("transaction aborted without an explicit detection call: %v", err)
+	}
+
+	if victim := m.DetectDeadlocksNow(); victim != younger.id {
+		t.Fatalf("DetectDeadlocksNow() = %d, want youngest tx %d", victim, younger.id)
+	}
+
+	err := younger.Put("k", 2)
+	if !errors.Is(err, ErrDeadlock) || !errors.Is(err, ErrTxCanceled) {
+		t.Errorf("victim Put: got %v, want ErrDeadlock wrapped in ErrTxCanceled", err)
+	}
+	if err := older.Put("k", 3); err != nil {
+		t.Errorf("survivor must stay usable: %v", err)
+	}
+
+	if victim := m.DetectDeadlocksNow(); victim != 0 {
+		t.Errorf("cycle persisted after the victim finalized: victim %d", victim)
+	}
+}
+
+// TestManualGC_RunsOnlyOnExplicitCall проверяет, что с WithManualGC
+// версии собираются только явным RunGCNow.
+func TestManualGC_RunsOnlyOnExplicitCall(t *testing.T) {
+	ctx := context.Background()
+	m := NewMVCCMap[string, int](ctx, WithManualGC(), WithGCInterval(time.Millisecond))
+	defer m.Close()
+
+	for i := range 3 {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("k", i)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if n := m.VersionCount(); n != 4 {
+		t.Fatalf("VersionCount = %d before RunGCNow, want 4", n)
+	}
+	if got := m.RunGCNow(); got != 3 {
+		t.Errorf("RunGCNow() = %d, want 3", got)
+	}
+}
+
+// TestDeadlock_LogsTransactionLabels проверяет, что метки транзакций
+// цикла попадают в Warn-лог детектора.
+func TestDeadlock_LogsTransactionLabels(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	m := NewMVCCMap[string, int](ctx,
+		WithManualGC(),
+		WithManualDeadlockDetection(),
+		WithLogger(slog.New(slog.NewTextHandler(&buf, nil))),
+	)
+	defer m.Close()
+
+	transfer := m.BeginTxWith(ctx, TxOptions{Label: "transfer-funds"})
+	defer transfer.Rollback()
+	audit := m.BeginTxWith(ctx, TxOptions{Label: "audit-balance"})
+	defer audit.Rollback()
+
+	m.setWaitFor(transfer.id, audit.id)
+	m.setWaitFor(audit.id, transfer.id)
+
+	if victim := m.DetectDeadlocksNow(); victim != audit.id {
+		t.Fatalf("DetectDeadlocksNow() = %d, want %d", victim, audit.id)
+	}
+
+	out := buf.String()
+	for _, label := range []string{"transfer-funds", "audit-balance"} {
+		if !strings.Contains(out, label) {
+			t.Errorf("deadlock log misses label %q:\n%s", label, out)
+		}
+	}
+	if !strings.Contains(out, "victim_label=audit-balance") {
+		t.Errorf("deadlock log misses victim label:\n%s", out)
+	}
+}
+
+// TestDeadlock_LowestPriorityVictim проверяет, что стратегия LowestPriority
+// прерывает транзакцию с наименьшим приоритетом, даже если она старше.
+func TestDeadlock_LowestPriorityVictim(t *testing.T) {
+	ctx := context.Background()
+	m := NewMVCCMap[string, int](ctx,
+		WithManualGC(),
+		WithManualDeadlockDetection(),
+		WithVictimStrategy(LowestPriority),
+	)
+	defer m.Close()
+
+	low := m.BeginTxWith(ctx, TxOptions{Priority: 1})
+	defer low.Rollback()
+	high := m.BeginTxWith(ctx, TxOptions{Priority: 10})
+	defer high.Rollback()
+	mid := m.BeginTxWith(ctx, TxOptions{Priority: 5})
+	defer mid.Rollback()
+
+	m.setWaitFor(low.id, high.id)
+	m.setWaitFor(high.id, mid.id)
+	m.setWaitFor(mid.id, low.id)
+
+	if victim := m.DetectDeadlocksNow(); victim != low.id {
+		t.Fatalf("DetectDeadlocksNow() = %d, want lowest-priority tx %d", victim, low.id)
+	}
+	if err := low.Put("k", 1); !errors.Is(err, ErrDeadlock) {
+		t.Errorf("victim Put: got %v, want ErrDeadlock", err)
+	}
+	if err := high.Put("k", 1); err != nil {
+		t.Errorf("higher-priority tx must survive: %v", err)
+	}
+}
+
+// TestDeadlock_SamplesUnderOverload проверяет, что с
+// WithMaxActiveTxsForDetection при тысячах активных транзакций проходы
+// детектора не мешают коммитам, а деградация логируется один раз при
+// входе в режим выборки и снимается, когда нагрузка спадает.
+func TestDeadlock_SamplesUnderOverload(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	m := NewMVCCMap[string, int](ctx,
+		WithManualGC(),
+		WithManualDeadlockDetection(),
+		WithMaxActiveTxsForDetection(100),
+		WithLogger(slog.New(slog.NewTextHandler(&buf, nil))),
+	)
+	defer m.Close()
+
+	idle := make([]*Tx[string, int], 5000)
+	for i := range idle {
+		idle[i] = m.BeginTx(ctx)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			m.DetectDeadlocksNow()
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	for i := range 50 {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("k", i)
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit during detection: %v", err)
+		}
+	}
+	close(stop)
+	<-done
+
+	if got := strings.Count(buf.String(), "deadlock detection degraded to sampling"); got != 1 {
+		t.Errorf("degradation warning logged %d times, want once:\n%s", got, buf.String())
+	}
+
+	for _, tx := range idle {
+		tx.Rollback()
+	}
+	m.DetectDeadlocksNow()
+	if !strings.Contains(buf.String(), "back to the full wait-for graph") {
+		t.Errorf("recovery not logged:\n%s", buf.String())
+	}
+}
+
+// TestDeadlock_ForcesRollbackOfWedgedVictim проверяет, что жертва,
+// не отреагировавшая на отмену за WithDeadlockVictimGrace, принудительно
+// откатывается следующим проходом детектора: уходит из activeTxs
+// и освобождает снапшот, а сама жертва при следующей операции получает
+// ErrDeadlock и по-прежнему может дочитать снапшот, даже с WithDataMapPool.
+func TestDeadlock_ForcesRollbackOfWedgedVictim(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	m := NewMVCCMap[string, int](ctx,
+		WithManualGC(),
+		WithManualDeadlockDetection(),
+		WithDataMapPool(true),
+		WithDeadlockVictimGrace(20*time.Millisecond),
+		WithLogger(slog.New(slog.NewTextHandler(&buf, nil))),
+	)
+	defer m.Close()
+
+	setup := m.BeginTx(ctx)
+	_ = setup.Put("seen", 1)
+	if err := setup.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	older := m.BeginTx(ctx)
+	defer older.Rollback()
+	younger := m.BeginTx(ctx)
+	m.setWaitFor(older.id, younger.id)
+	m.setWaitFor(younger.id, older.id)
+
+	if victim := m.DetectDeadlocksNow(); victim != younger.id {
+		t.Fatalf("DetectDeadlocksNow() = %d, want %d", victim, younger.id)
+	}
+	// Жертва "зависла" и не вызывает ничего; до истечения grace её не трогают.
+	m.DetectDeadlocksNow()
+	if !m.isActive(younger.id) {
+		t.Fatal("victim force-rolled back before the grace period")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	m.DetectDeadlocksNow()
+	if m.isActive(younger.id) {
+		t.Fatal("wedged victim still registered after the grace period")
+	}
+	if refs := younger.snapshot.refCount.Load(); refs != 1 {
+		t.Errorf("snapshot refCount = %d, want 1 (only the survivor)", refs)
+	}
+
+	if err := older.Put("k", 2); err != nil {
+		t.Errorf("survivor must stay usable: %v", err)
+	}
+
+	// Снапшот жертвы не должен попасть в пул, пока она может его читать.
+	older.Rollback()
+	for i := range 3 {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("k", i)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.RunGCNow()
+	if vv, ok := younger.snapshot.data.Get("seen"); !ok || vv.value != 1 {
+		t.Errorf("victim snapshot after GC: seen = %d, %v; want 1, true", vv.value, ok)
+	}
+
+	if err := younger.Put("k", 1); !errors.Is(err, ErrDeadlock) {
+		t.Errorf("victim Put after forced rollback = %v, want ErrDeadlock", err)
+	}
+	if err := younger.Commit(); !errors.Is(err, ErrDeadlock) {
+		t.Errorf("victim Commit after forced rollback = %v, want ErrDeadlock", err)
+	}
+	if !strings.Contains(buf.String(), "forcing rollback") {
+		t.Errorf("forced rollback not logged:\n%s", buf.String())
+	}
+	if victim := m.DetectDeadlocksNow(); victim != 0 {
+		t.Errorf("cycle persists after forced rollback: victim %d", victim)
+	}
+
+	// Устаревший meta не завершает транзакцию, начатую Reset на том же Tx.
+	stale := younger.meta
+	if err := younger.Reset(ctx); err != nil {
+		t.Fatalf("Reset after forced rollback: %v", err)
+	}
+	defer younger.Rollback()
+	if m.forceFinalize(stale) {
+		t.Error("stale meta finalized again")
+	}
+	if err := younger.Put("k", 2); err != nil {
+		t.Errorf("reset transaction must stay usable: %v", err)
+	}
+}
+
+// TestDeadlock_SkipsVictimInsideCommit проверяет, что жертва, зависшая
+// уже внутри Commit (в хуке коммита), не откатывается принудительно:
+// она остаётся зарегистрированной до конца коммита, а ресурсы
+// освобождает сам Commit.
+func TestDeadlock_SkipsVictimInsideCommit(t *testing.T) {
+	ctx := context.Background()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	m := NewMVCCMap[string, int](ctx,
+		WithManualGC(),
+		WithManualDeadlockDetection(),
+		WithDeadlockVictimGrace(time.Millisecond),
+		WithNoLogger(),
+		WithCommitHook(func(_ context.Context, _ uint64, changes map[string]int, _ []string) error {
+			if _, ok := changes["victim"]; ok {
+				close(entered)
+				<-release
+			}
+			return nil
+		}),
+	)
+	defer m.Close()
+
+	older := m.BeginTx(ctx)
+	defer older.Rollback()
+	younger := m.BeginTx(ctx)
+	_ = younger.Put("victim", 1)
+	m.setWaitFor(older.id, younger.id)
+	m.setWaitFor(younger.id, older.id)
+
+	done := make(chan error, 1)
+	go func() { done <- younger.Commit() }()
+	<-entered
+
+	if victim := m.DetectDeadlocksNow(); victim != younger.id {
+		t.Fatalf("DetectDeadlocksNow() = %d, want %d", victim, younger.id)
+	}
+	time.Sleep(5 * time.Millisecond)
+	m.DetectDeadlocksNow()
+	if !m.isActive(younger.id) {
+		t.Fatal("victim inside Commit was force-rolled back")
+	}
+	if refs := younger.snapshot.refCount.Load(); refs != 2 {
+		t.Errorf("snapshot refCount = %d, want 2 (both transactions)", refs)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Commit of a victim already inside the commit path: %v", err)
+	}
+	if m.isActive(younger.id) {
+		t.Error("committed victim still registered")
+	}
+	if refs := younger.snapshot.refCount.Load(); refs != 1 {
+		t.Errorf("snapshot refCount after Commit = %d, want 1 (only the survivor)", refs)
+	}
+}
+
+// isActive сообщает, зарегистрирована ли транзакция в activeTxs.
+func (m *MVCCMap[K, V]) isActive(txID uint64) bool {
+	m.activeTxsMu.RLock()
+	defer m.activeTxsMu.RUnlock()
+	_, ok := m.activeTxs[txID]
+	return ok
+}
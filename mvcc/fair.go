@@ -0,0 +1,64 @@
+package mvcc
+
+import "sync"
+
+// fairQueue — очередь коммитов перед m.mu, справедливая между группами
+// (TxOptions.Group, WithFairCommitScheduling). Одновременно коммитит
+// один участник; освобождая очередь, он передаёт её ожидающему из
+// следующей по кругу группы, а не тому, кто первым схватит мьютекс.
+// Поэтому группа, заваливающая map коммитами, получает свою долю
+// наравне с остальными, а не пропорционально числу своих горутин.
+type fairQueue struct {
+	mu      sync.Mutex
+	busy    bool
+	current string                     // группа того, кто сейчас коммитит (при busy)
+	waiting map[string][]chan struct{} // ожидающие по группам, FIFO
+	ring    []string                   // группы с ожидающими в порядке обслуживания, кроме current
+}
+
+// acquire ждёт очереди коммита для группы group.
+func (q *fairQueue) acquire(group string) {
+	q.mu.Lock()
+	if !q.busy {
+		q.busy, q.current = true, group
+		q.mu.Unlock()
+		return
+	}
+	if q.waiting == nil {
+		q.waiting = make(map[string][]chan struct{})
+	}
+	turn := make(chan struct{})
+	// Группа коммитящего встаёт в круг только при release — позади
+	// групп, пришедших за время его коммита.
+	if len(q.waiting[group]) == 0 && group != q.current {
+		q.ring = append(q.ring, group)
+	}
+	q.waiting[group] = append(q.waiting[group], turn)
+	q.mu.Unlock()
+	<-turn
+}
+
+// release передаёт очередь первому ожидающему следующей по кругу группы;
+// если ожидающих нет, очередь освобождается.
+func (q *fairQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting[q.current]) > 0 {
+		q.ring = append(q.ring, q.current)
+	}
+	if len(q.ring) == 0 {
+		q.busy = false
+		return
+	}
+	group := q.ring[0]
+	q.ring = q.ring[1:]
+	waiters := q.waiting[group]
+	turn := waiters[0]
+	if len(waiters) > 1 {
+		q.waiting[group] = waiters[1:]
+	} else {
+		delete(q.waiting, group)
+	}
+	q.current = group
+	close(turn) // busy остаётся true: очередь переходит к ожидающему
+}
@@ -18,31 +18,133 @@ import (
 func (m *MVCCMap[K, V]) runGC(ctx context.Context, interval time.Duration) {
 	defer close(m.gcDone)
 
-	ticker := time.NewTicker(interval)
+	if interval <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := m.cfg.clockSource.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			m.collectVersions()
+			m.gcBeat.beat(m.cfg.clockSource.Now())
 		}
 	}
 }
 
-func (m *MVCCMap[K, V]) collectVersions() {
+// RunGCNow синхронно выполняет один проход GC и возвращает число
+// удалённых версий. Вместе с WithManualGC позволяет тестам управлять
+// сборкой детерминированно.
+func (m *MVCCMap[K, V]) RunGCNow() int {
+	return m.collectVersions()
+}
+
+// GCAggressive — ручной клапан сброса давления памяти: синхронно выполняет
+// полный проход GC, игнорируя WithGCTimeBudget и курсор предыдущего
+// прохода, и возвращает число удалённых версий. Остаются только текущая
+// версия и версии, закреплённые активными транзакциями, Snapshot и тегами:
+// политики удержания истории сверх этого у map нет.
+//
+// Возвращает ошибку ctx, если он отменён до начала прохода.
+func (m *MVCCMap[K, V]) GCAggressive(ctx context.Context) (collected int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return m.collect(0), nil
+}
+
+// maybeInlineGC выполняет проход GC на каждом n-м коммите (WithInlineGC).
+// Вызывается после освобождения m.mu, чтобы не удлинять критическую секцию.
+func (m *MVCCMap[K, V]) maybeInlineGC() {
+	n := m.cfg.inlineGCEvery
+	if n <= 0 {
+		return
+	}
+	if m.inlineGCCommits.Add(1)%uint64(n) == 0 {
+		m.collectVersions()
+	}
+}
+
+// GCStats — агрегированная статистика проходов GC для подбора интервала.
+type GCStats struct {
+	Runs              uint64        // число выполненных проходов
+	TotalCollected    uint64        // всего удалено версий
+	MaxCollectedInRun int           // максимум удалённых версий за один проход
+	AvgLiveVersions   float64       // среднее число версий, оставшихся после прохода
+	TotalDuration     time.Duration // суммарное время проходов
+}
+
+// gcStats накапливает счётчики; защищён versionsMu, который
+// collectVersions и так удерживает на время прохода.
+type gcStats struct {
+	GCStats
+	liveSum uint64
+}
+
+// GCStats возвращает статистику GC на текущий момент. После Close —
+// итог всех проходов до остановки GC-горутины.
+func (m *MVCCMap[K, V]) GCStats() GCStats {
+	m.versionsMu.Lock()
+	defer m.versionsMu.Unlock()
+
+	s := m.gcStats.GCStats
+	if s.Runs > 0 {
+		s.AvgLiveVersions = float64(m.gcStats.liveSum) / float64(s.Runs)
+	}
+	return s
+}
+
+// gcBudgetCheckEvery — как часто (в версиях) проход GC сверяется
+// с WithGCTimeBudget.
+const gcBudgetCheckEvery = 64
+
+// collectVersions выполняет один проход GC и возвращает число удалённых версий.
+func (m *MVCCMap[K, V]) collectVersions() int {
+	return m.collect(m.cfg.gcTimeBudget)
+}
+
+// collect — проход GC с бюджетом времени budget; 0 — без ограничения,
+// с начала списка версий. Через него идут все точки входа (фоновый цикл,
+// RunGCNow, GCAggressive, WithInlineGC), поэтому паника прохода
+// перехватывается здесь; прерванный проход возвращает 0.
+func (m *MVCCMap[K, V]) collect(budget time.Duration) (collected int) {
+	m.guardPass("gc", func() { collected = m.collectPass(budget) })
+	return collected
+}
+
+// collectPass — тело прохода GC.
+func (m *MVCCMap[K, V]) collectPass(budget time.Duration) int {
+	m.yield(PointGC, 0)
+	start := time.Now()
+
 	// Шаг 1: определяем минимальный snapshotID среди активных транзакций.
 	minSnapshotID := m.currentVersionID()
 
 	m.activeTxsMu.RLock()
 	for _, meta := range m.activeTxs {
-		// txMeta не хранит snapshotID напрямую — используем refCount версий.
-		// Версия с refCount > 0 используется хотя бы одной транзакцией.
+		// snapshotID из txMeta нужен только MinActiveSnapshot: удаление
+		// решается по refCount — версия с refCount > 0 используется хотя бы
+		// одной транзакцией или Snapshot.
 		_ = meta
 	}
 	m.activeTxsMu.RUnlock()
 
+	// Финализатор вызывается после освобождения versionsMu (defer'ы
+	// выполняются в обратном порядке), чтобы он мог обращаться к map.
+	var dropped []*version[K, V]
+	if fn := m.cfg.versionFinalizer; fn != nil {
+		defer func() {
+			for _, v := range dropped {
+				fn(v.id, v.refCount.Load())
+			}
+		}()
+	}
+
 	m.versionsMu.Lock()
 	defer m.versionsMu.Unlock()
 
@@ -50,19 +152,71 @@ func (m *MVCCMap[K, V]) collectVersions() {
 	// - не являются текущей (current)
 	// - имеют refCount == 0 (нет активных транзакций на этом снапшоте)
 	// - ID меньше minSnapshotID (не нужны будущим читателям)
+	//
+	// currentID читается до проверки refCount: вместе с повторной проверкой
+	// в acquireCurrent это закрывает гонку между Load() и refCount.Add(1).
 	currentID := m.currentVersionID()
-	kept := m.versions[:0] // reuse backing array, избегаем лишних аллокаций
 
-	for _, v := range m.versions {
+	// С WithGCTimeBudget проход начинается с курсора, где остановился
+	// предыдущий: версии до него уже просмотрены и сохраняются как есть.
+	cursor := 0
+	if budget > 0 {
+		cursor = min(m.gcCursor, len(m.versions))
+	}
+	kept := m.versions[:cursor] // reuse backing array, избегаем лишних аллокаций
+	m.gcCursor = 0
+
+	for i := cursor; i < len(m.versions); i++ {
+		// Бюджет проверяется раз в gcBudgetCheckEvery версий: time.Since
+		// на каждой версии заметно удорожил бы проход. Остаток просмотрится
+		// следующим проходом.
+		if budget > 0 && (i-cursor)%gcBudgetCheckEvery == 0 && i > cursor && time.Since(start) > budget {
+			m.gcCursor = len(kept)
+			kept = append(kept, m.versions[i:]...)
+			break
+		}
+		v := m.versions[i]
 		if v.id == currentID || v.refCount.Load() > 0 || v.id >= minSnapshotID {
 			kept = append(kept, v)
 		} else {
 			m.logger.Debug("GC: collected version", "versionID", v.id)
+			m.recycleData(v)
+			if m.cfg.versionFinalizer != nil {
+				dropped = append(dropped, v)
+			}
 			// v.data будет собрана GC рантайма после потери последней ссылки.
 		}
 	}
 
+	collected := len(m.versions) - len(kept)
+	clear(m.versions[len(kept):]) // не удерживаем собранные версии в хвосте массива
 	m.versions = kept
+
+	if collected > 0 {
+		// Будим BeginTx, ожидающие свободного места (admission control).
+		close(m.versionsFreed)
+		m.versionsFreed = make(chan struct{})
+	}
+
+	m.gcStats.Runs++
+	m.gcStats.TotalCollected += uint64(collected)
+	m.gcStats.MaxCollectedInRun = max(m.gcStats.MaxCollectedInRun, collected)
+	m.gcStats.liveSum += uint64(len(kept))
+	m.gcStats.TotalDuration += time.Since(start)
+
+	return collected
+}
+
+// recycleData возвращает карту собранной версии в WithDataMapPool.
+// Вызывается под versionsMu для версии, прошедшей проверки GC: не текущей,
+// с нулевым refCount — читателей у неё нет и больше не появится.
+func (m *MVCCMap[K, V]) recycleData(v *version[K, V]) {
+	data, ok := v.data.(mapStore[K, V])
+	if m.dataPool == nil || !ok || v.noRecycle.Load() || v.refCount.Load() != 0 {
+		return
+	}
+	clear(data)
+	m.dataPool.Put(data)
 }
 
 func (m *MVCCMap[K, V]) currentVersionID() uint64 {
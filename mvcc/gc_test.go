@@ -0,0 +1,517 @@
+package mvcc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func (m *MVCCMap[K, V]) isRetained(v *version[K, V]) bool {
+	m.versionsMu.Lock()
+	defer m.versionsMu.Unlock()
+	for _, kept := range m.versions {
+		if kept == v {
+			return true
+		}
+	}
+	return false
+}
+
+// TestBeginTx_SnapshotNeverCollectedWhileReferenced проверяет, что версия,
+// захваченная BeginTx, не удаляется GC, пока на неё ссылается транзакция,
+// даже при тысячах конкурентных BeginTx/Commit и непрерывном GC.
+func TestBeginTx_SnapshotNeverCollectedWhileReferenced(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMVCCMap[int, int](ctx, WithGCInterval(time.Millisecond))
+	defer m.Close()
+
+	var stop atomic.Bool
+	gcDone := make(chan struct{})
+	go func() {
+		defer close(gcDone)
+		for !stop.Load() {
+			m.collectVersions()
+		}
+	}()
+
+	const workers = 16
+	const txPerWorker = 500
+
+	var wg sync.WaitGroup
+	var violations atomic.Int64
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range txPerWorker {
+				tx := m.BeginTx(ctx)
+				if !m.isRetained(tx.snapshot) {
+					violations.Add(1)
+				}
+				_ = tx.Put(w*txPerWorker+i, i)
+				_ = tx.Commit()
+			}
+		}()
+	}
+	wg.Wait()
+	stop.Store(true)
+	<-gcDone
+
+	if n := violations.Load(); n > 0 {
+		t.Fatalf("%d snapshots were collected while referenced by an active transaction", n)
+	}
+}
+
+// TestGCStats_ConsistentWithCommits проверяет, что агрегаты GCStats
+// согласуются с числом коммитов между проходами GC.
+func TestGCStats_ConsistentWithCommits(t *testing.T) {
+	ctx := context.Background()
+	m := NewMVCCMap[int, int](ctx, WithGCInterval(time.Hour))
+	defer m.Close()
+
+	const rounds = 3
+	const commitsPerRound = 10
+
+	for r := range rounds {
+		for i := range commitsPerRound {
+			tx := m.BeginTx(ctx)
+			_ = tx.Put(i, r)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := m.collectVersions(); got != commitsPerRound {
+			t.Fatalf("round %d: collected %d versions, want %d", r, got, commitsPerRound)
+		}
+	}
+
+	s := m.GCStats()
+	if s.Runs != rounds {
+		t.Errorf("Runs = %d, want %d", s.Runs, rounds)
+	}
+	if s.TotalCollected != rounds*commitsPerRound {
+		t.Errorf("TotalCollected = %d, want %d", s.TotalCollected, rounds*commitsPerRound)
+	}
+	if s.MaxCollectedInRun != commitsPerRound {
+		t.Errorf("MaxCollectedInRun = %d, want %d", s.MaxCollectedInRun, commitsPerRound)
+	}
+	if s.AvgLiveVersions != 1 {
+		t.Errorf("AvgLiveVersions = %v, want 1 (only current survives)", s.AvgLiveVersions)
+	}
+	if s.TotalDuration <= 0 {
+		t.Errorf("TotalDuration = %v, want > 0", s.TotalDuration)
+	}
+}
+
+// TestAdmissionControl_BeginTxBlocksAtCeiling проверяет, что BeginTx
+// блокируется на потолке версий и продолжает работу после прохода GC.
+func TestAdmissionControl_BeginTxBlocksAtCeiling(t *testing.T) {
+	ctx := context.Background()
+	m := NewMVCCMap[string, int](ctx,
+		WithGCInterval(time.Hour),
+		WithMaxVersions(3),
+		WithAdmissionControl(true),
+	)
+	defer m.Close()
+
+	for i := range 2 {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("k", i)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := m.VersionCount(); n != 3 {
+		t.Fatalf("VersionCount = %d, want 3", n)
+	}
+
+	admitted := make(chan *Tx[string, int])
+	go func() { admitted <- m.BeginTx(ctx) }()
+
+	select {
+	case tx := <-admitted:
+		tx.Rollback()
+		t.Fatal("BeginTx was admitted at the version ceiling")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.collectVersions()
+
+	select {
+	case tx := <-admitted:
+		tx.Rollback()
+	case <-time.After(time.Second):
+		t.Fatal("BeginTx stayed blocked after GC freed capacity")
+	}
+}
+
+// TestGCTimeBudget_DrainsBacklogOverPasses проверяет, что с исчерпанным
+// бюджетом проход останавливается после порции версий, а следующие
+// проходы продолжают с курсора, пока backlog не будет собран.
+func TestGCTimeBudget_DrainsBacklogOverPasses(t *testing.T) {
+	ctx := context.Background()
+	m := NewMVCCMap[int, int](ctx, WithManualGC(), WithGCTimeBudget(time.Nanosecond))
+	defer m.Close()
+
+	const backlog = 1000
+	for i := range backlog {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put(i%10, i)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	passes, total := 0, 0
+	for m.VersionCount() > 1 {
+		n := m.RunGCNow()
+		if n > gcBudgetCheckEvery {
+			t.Fatalf("pass %d collected %d versions, budget allows at most %d", passes, n, gcBudgetCheckEvery)
+		}
+		total += n
+		passes++
+		if passes > 2*backlog/gcBudgetCheckEvery+2 {
+			t.Fatalf("backlog not drained after %d passes: %d versions left", passes, m.VersionCount())
+		}
+	}
+	if total != backlog {
+		t.Errorf("collected %d versions, want %d", total, backlog)
+	}
+	if passes < backlog/gcBudgetCheckEvery {
+		t.Errorf("backlog drained in %d passes, want several budget-limited passes", passes)
+	}
+}
+
+// TestGCAggressive_IgnoresTimeBudget проверяет, что GCAggressive за один
+// вызов собирает весь бэклог, который обычный проход с WithGCTimeBudget
+// разбирает частями, и не трогает версию, закреплённую Snapshot.
+func TestGCAggressive_IgnoresTimeBudget(t *testing.T) {
+	ctx := context.Background()
+	m := NewMVCCMap[int, int](ctx, WithManualGC(), WithGCTimeBudget(time.Nanosecond))
+	defer m.Close()
+
+	commit := func(i int) {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put(i%10, i)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit(0)
+	pinned := m.CurrentSnapshot()
+	defer pinned.Release()
+	const backlog = 1000
+	for i := 1; i <= backlog; i++ {
+		commit(i)
+	}
+
+	normal := m.RunGCNow()
+	aggressive, err := m.GCAggressive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aggressive <= normal {
+		t.Errorf("GCAggressive collected %d versions, normal pass %d; want more", aggressive, normal)
+	}
+	if got := m.VersionCount(); got != 2 {
+		t.Errorf("VersionCount = %d after GCAggressive, want 2 (current + pinned)", got)
+	}
+	if v, ok := pinned.Get(0); !ok || v != 0 {
+		t.Errorf("pinned snapshot Get(0) = %d, %v; want 0, true", v, ok)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := m.GCAggressive(canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("GCAggressive(canceled) err = %v, want context.Canceled", err)
+	}
+}
+
+// TestVersionFinalizer_ReportsFinalRefCount проверяет, что при обычной
+// нагрузке каждая удалённая версия финализируется с refCount 0, а лишний
+// декремент обнаруживается как ненулевой счётчик.
+func TestVersionFinalizer_ReportsFinalRefCount(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	final := make(map[uint64]int64)
+	m := NewMVCCMap[string, int](ctx,
+		WithManualGC(),
+		WithVersionFinalizer(func(id uint64, refCount int64) {
+			mu.Lock()
+			final[id] = refCount
+			mu.Unlock()
+		}),
+	)
+	defer m.Close()
+
+	for i := range 20 {
+		reader := m.BeginTx(ctx)
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("k", i)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		snap := m.CurrentSnapshot()
+		reader.Rollback()
+		snap.Release()
+	}
+	collected := m.RunGCNow()
+
+	mu.Lock()
+	if len(final) != collected || collected == 0 {
+		t.Errorf("finalized %d versions, GC collected %d", len(final), collected)
+	}
+	for id, refs := range final {
+		if refs != 0 {
+			t.Errorf("version %d finalized with refCount %d, want 0", id, refs)
+		}
+	}
+	clear(final)
+	mu.Unlock()
+
+	// Имитируем ошибку учёта: лишний декремент у версии.
+	buggy := m.current.Load()
+	buggy.refCount.Add(-1)
+	tx := m.BeginTx(ctx)
+	_ = tx.Put("k", -1)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	m.RunGCNow()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if refs, ok := final[buggy.id]; !ok || refs != -1 {
+		t.Errorf("buggy version %d finalized with refCount %d (seen %v), want -1", buggy.id, refs, ok)
+	}
+}
+
+// TestDataMapPool_ChurnStaysConsistentWithFewerAllocs проверяет, что при
+// интенсивных коммитах с переиспользованием карт читатели видят
+// согласованные снимки, а коммит аллоцирует меньше, чем без пула.
+func TestDataMapPool_ChurnStaysConsistentWithFewerAllocs(t *testing.T) {
+	ctx := context.Background()
+	const keys = 256
+
+	// Каждый коммит пишет одно и то же значение во все ключи, поэтому
+	// любой согласованный снимок содержит одинаковые значения.
+	commitAll := func(m *MVCCMap[int, int], v int) {
+		tx := m.BeginTx(ctx)
+		for k := range keys {
+			_ = tx.Put(k, v)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewMVCCMap[int, int](ctx, WithManualGC(), WithDataMapPool(true))
+	defer m.Close()
+	commitAll(m, 0)
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				tx := m.BeginTx(ctx)
+				first, _ := tx.Get(0)
+				for k := 1; k < keys; k++ {
+					if v, _ := tx.Get(k); v != first {
+						t.Errorf("torn snapshot: key 0 = %d, key %d = %d", first, k, v)
+						stop.Store(true)
+						break
+					}
+				}
+				tx.Rollback()
+			}
+		}()
+	}
+	for i := 1; i <= 300; i++ {
+		commitAll(m, i)
+		m.RunGCNow()
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	// Аллокации одного коммита одного ключа с проходом GC.
+	allocs := func(m *MVCCMap[int, int]) float64 {
+		i := 0
+		return testing.AllocsPerRun(200, func() {
+			i++
+			tx := m.BeginTx(ctx)
+			_ = tx.Put(i%keys, i)
+			_ = tx.Commit()
+			m.RunGCNow()
+		})
+	}
+	plain := NewMVCCMap[int, int](ctx, WithManualGC())
+	defer plain.Close()
+	commitAll(plain, 0)
+
+	withPool, without := allocs(m), allocs(plain)
+	if withPool >= without {
+		t.Errorf("allocs per commit: with pool %.1f, without %.1f; want fewer with pool", withPool, without)
+	}
+}
+
+// TestGCLoop_RecoversFromPanickingPass проверяет, что паника в проходе
+// GC (из пользовательского финализатора) перехватывается и учитывается
+// в BackgroundPanics, а следующий тик снова собирает версии.
+func TestGCLoop_RecoversFromPanickingPass(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	var calls atomic.Int64
+	m := NewMVCCMap[string, int](ctx,
+		WithClockSource(clock),
+		WithGCInterval(time.Second),
+		WithManualDeadlockDetection(),
+		WithNoLogger(),
+		WithVersionFinalizer(func(uint64, int64) {
+			if calls.Add(1) == 1 {
+				panic("finalizer bug")
+			}
+		}),
+	)
+	defer m.Close()
+	clock.waitTickers(t, 1)
+
+	commit := func(v int) {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("k", v)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// tick проводит один проход GC и ждёт, пока он отметится в heartbeat.
+	tick := func() {
+		before := m.gcBeat.last.Load()
+		clock.Advance(time.Second)
+		deadline := time.Now().Add(time.Second)
+		for m.gcBeat.last.Load() == before {
+			if time.Now().After(deadline) {
+				t.Fatal("GC loop did not complete a pass after its tick")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	commit(1)
+	tick()
+	if n := m.Stats().BackgroundPanics; n != 1 {
+		t.Fatalf("BackgroundPanics = %d after panicking pass, want 1", n)
+	}
+
+	commit(2)
+	tick()
+	if n := m.VersionCount(); n != 1 {
+		t.Errorf("VersionCount() = %d after recovered pass, want 1", n)
+	}
+	if n := m.Stats().BackgroundPanics; n != 1 {
+		t.Errorf("BackgroundPanics = %d, want 1", n)
+	}
+	if calls.Load() < 2 {
+		t.Error("finalizer was not called again after the panic")
+	}
+}
+
+// TestGC_ManualPassesRecoverFromPanic проверяет, что паника прохода GC
+// перехватывается и на ручных точках входа: RunGCNow, GCAggressive и
+// inline GC на коммите не роняют вызывающего и учитываются в BackgroundPanics.
+func TestGC_ManualPassesRecoverFromPanic(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		pass func(m *MVCCMap[string, int])
+	}{
+		{"RunGCNow", nil, func(m *MVCCMap[string, int]) { m.RunGCNow() }},
+		{"GCAggressive", nil, func(m *MVCCMap[string, int]) {
+			if _, err := m.GCAggressive(ctx); err != nil {
+				t.Errorf("GCAggressive: %v", err)
+			}
+		}},
+		{"inline", []Option{WithInlineGC(1)}, func(m *MVCCMap[string, int]) {
+			tx := m.BeginTx(ctx)
+			_ = tx.Put("k", 2)
+			if err := tx.Commit(); err != nil {
+				t.Errorf("Commit with a panicking inline GC: %v", err)
+			}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMVCCMap[string, int](ctx, append([]Option{
+				WithManualGC(),
+				WithManualDeadlockDetection(),
+				WithNoLogger(),
+				WithVersionFinalizer(func(uint64, int64) { panic("finalizer bug") }),
+			}, tc.opts...)...)
+			defer m.Close()
+
+			// Коммит оставляет начальную версию сборщику; inline GC
+			// соберёт её на следующем коммите, когда снапшот отпущен.
+			tx := m.BeginTx(ctx)
+			_ = tx.Put("k", 1)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			tc.pass(m)
+			if n := m.Stats().BackgroundPanics; n != 1 {
+				t.Errorf("BackgroundPanics = %d, want 1", n)
+			}
+		})
+	}
+}
+
+// TestVersionLeakDetector_FiresWhenReadersPinVersions проверяет, что
+// детектор срабатывает, когда удерживаемые читателями версии превышают
+// потолок, и молчит, пока GC держит их число в пределах.
+func TestVersionLeakDetector_FiresWhenReadersPinVersions(t *testing.T) {
+	ctx := context.Background()
+	var fired []int
+	m := NewMVCCMap[string, int](ctx,
+		WithManualGC(),
+		WithVersionLeakDetector(4, func(live, limit int) {
+			if limit != 4 {
+				t.Errorf("handler limit = %d, want 4", limit)
+			}
+			fired = append(fired, live)
+		}),
+	)
+	defer m.Close()
+
+	commit := func(v int) {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("k", v)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := range 10 {
+		commit(i)
+		m.RunGCNow()
+	}
+	if len(fired) != 0 {
+		t.Fatalf("detector fired with GC keeping up: %v", fired)
+	}
+
+	var readers []*Tx[string, int]
+	for i := range 5 {
+		readers = append(readers, m.BeginTx(ctx))
+		commit(i)
+		m.RunGCNow()
+	}
+	for _, r := range readers {
+		r.Rollback()
+	}
+	if len(fired) == 0 || fired[len(fired)-1] <= 4 {
+		t.Errorf("detector did not fire while readers pinned versions: %v", fired)
+	}
+}
@@ -0,0 +1,157 @@
+package mvcc
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// groupCommitter накапливает транзакции, готовые к коммиту, для WithGroupCommit.
+//
+// Первый пришедший коммит становится лидером группы: ждёт window, забирает
+// всех накопившихся и коммитит их одной версией. Остальные просто ждут
+// результата. Пока лидер коммитит, следующие коммиты формируют новую группу.
+type groupCommitter[K comparable, V any] struct {
+	mu      sync.Mutex
+	pending []*groupMember[K, V]
+	leading bool
+}
+
+type groupMember[K comparable, V any] struct {
+	tx   *Tx[K, V]
+	done chan error
+}
+
+func (m *MVCCMap[K, V]) commitGrouped(tx *Tx[K, V]) error {
+	member := &groupMember[K, V]{tx: tx, done: make(chan error, 1)}
+
+	g := &m.group
+	g.mu.Lock()
+	g.pending = append(g.pending, member)
+	if g.leading {
+		g.mu.Unlock()
+		return <-member.done
+	}
+	g.leading = true
+	g.mu.Unlock()
+
+	time.Sleep(m.cfg.groupCommitWindow)
+
+	g.mu.Lock()
+	batch := g.pending
+	g.pending = nil
+	g.leading = false
+	g.mu.Unlock()
+
+	m.commitBatch(batch)
+	return <-member.done
+}
+
+// commitBatch коммитит группу транзакций одной версией.
+//
+// Один clone на группу. Члены применяются по очереди к рабочей копии,
+// и каждый проверяется на конфликты не с current, а с рабочей копией —
+// то есть с учётом записей принятых ранее членов той же группы. Поэтому
+// конфликты внутри группы обнаруживаются так же, как между отдельными
+// коммитами: раньше принятый выигрывает, конфликтующий получает ErrConflict,
+// а остальные члены группы всё равно коммитятся.
+//
+// Для всех наблюдателей версии группа — один коммит: хук коммита,
+// асинхронный хук, WatchKeys и учёт tombstone'ов получают объединённый
+// набор изменений ровно один раз на установленную версию. Поэтому
+// версии в них строго возрастают, как и без WithGroupCommit. Хук группы
+// получает контекст первого принятого члена; его отказ
+// (WithCommitHookFailsCommit) отклоняет всю группу.
+func (m *MVCCMap[K, V]) commitBatch(batch []*groupMember[K, V]) {
+	unlock := m.lockCommit()
+	defer unlock()
+
+	current := m.current.Load()
+	newVID := m.nextVersionID.Load() + 1
+
+	// working — "виртуальная текущая версия" с ID будущей версии:
+	// он больше ID любого снапшота, поэтому validate сравнивает писателей
+	// каждого ключа, а не пропускает проверку. base позволяет отличить
+	// конфликт с current от конфликта с ранее принятым членом группы.
+	working := newVersion[K, V](newVID, m.cloneData(current))
+	working.size = current.size
+	working.base = current
+
+	var applied []*groupMember[K, V]
+	for _, member := range batch {
+		tx := member.tx
+		if err := m.validate(tx, working); err != nil {
+			member.done <- err
+			continue
+		}
+		m.resolveMerges(tx, working)
+		if err := m.runCommitValidator(tx); err != nil {
+			member.done <- err
+			continue
+		}
+		working.size = m.applyWrites(working.data, working.size, tx)
+		m.stampCommitTS(working.data, tx)
+		applied = append(applied, member)
+	}
+
+	var group *Tx[K, V]
+	if len(applied) > 0 {
+		group = m.groupTx(applied)
+		if err := m.callCommitHook(group, newVID); err != nil {
+			for _, member := range applied {
+				member.done <- err
+			}
+			applied = nil
+		}
+	}
+
+	if len(applied) > 0 {
+		m.purgeTombstones(working.data)
+		m.installVersion(newVID, working.data, working.size)
+		m.versionCommitted(group.writes, newVID)
+		for _, member := range applied {
+			m.txCommitted(member.tx, newVID)
+		}
+	} else {
+		// Версия newVID не появится: конфликты с членами группы
+		// ссылались бы на несуществующую версию.
+		for _, member := range batch {
+			for i := range member.tx.conflicts {
+				if c := &member.tx.conflicts[i]; c.TheirsVersion == newVID {
+					c.TheirsVersion = current.id
+				}
+			}
+		}
+	}
+
+	// Результат отдаём только после установки версии: Commit не должен
+	// вернуть nil раньше, чем изменения станут видимы.
+	for _, member := range batch {
+		select {
+		case member.done <- nil:
+		default: // уже получил ошибку
+		}
+	}
+}
+
+// groupTx собирает служебную транзакцию с объединённым write buffer
+// принятых членов группы: при пересечении ключей побеждает поздний член,
+// как и в данных версии. Контекст и ID — первого принятого члена.
+func (m *MVCCMap[K, V]) groupTx(applied []*groupMember[K, V]) *Tx[K, V] {
+	first := applied[0].tx
+	if len(applied) == 1 {
+		return first
+	}
+	group := &Tx[K, V]{
+		id:       first.id,
+		snapshot: first.snapshot,
+		ctx:      first.ctx,
+		opts:     first.opts,
+		writes:   make(map[K]versionedValue[V]),
+		db:       m,
+	}
+	for _, member := range applied {
+		maps.Copy(group.writes, member.tx.writes)
+	}
+	return group
+}
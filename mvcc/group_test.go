@@ -0,0 +1,227 @@
+package mvcc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mvcc-map/mvcc"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// commitConcurrently коммитит транзакции одновременно и возвращает ошибки по порядку.
+func commitConcurrently[K comparable, V any](txs ...*mvcc.Tx[K, V]) []error {
+	errs := make([]error, len(txs))
+	var wg sync.WaitGroup
+	for i, tx := range txs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = tx.Commit()
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// TestGroupCommit_DetectsConflictsWithinGroup проверяет, что конфликт
+// между транзакциями одной группы обнаруживается, а неконфликтующие
+// члены группы коммитятся одной версией.
+func TestGroupCommit_DetectsConflictsWithinGroup(t *testing.T) {
+	ctx := context.Background()
+	m := mvcc.NewMVCCMap[string, int](ctx,
+		mvcc.WithGCInterval(time.Hour),
+		mvcc.WithGroupCommit(50*time.Millisecond),
+	)
+	defer m.Close()
+
+	a := m.BeginTx(ctx)
+	b := m.BeginTx(ctx)
+	c := m.BeginTx(ctx)
+	_ = a.Put("same", 1)
+	_ = b.Put("same", 2)
+	_ = c.Put("other", 3)
+
+	before := m.VersionCount()
+	errs := commitConcurrently(a, b, c)
+
+	var conflicts int
+	for _, err := range errs[:2] {
+		if errors.Is(err, mvcc.ErrConflict) {
+			conflicts++
+		} else if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if conflicts != 1 {
+		t.Fatalf("writers of the same key: %d conflicts (errs %v), want exactly 1", conflicts, errs[:2])
+	}
+	if errs[2] != nil {
+		t.Fatalf("non-conflicting member failed: %v", errs[2])
+	}
+	if n := m.VersionCount(); n != before+1 {
+		t.Errorf("group produced %d versions, want 1", n-before)
+	}
+
+	check := m.BeginTx(ctx)
+	defer check.Rollback()
+	if v, _ := check.Get("other"); v != 3 {
+		t.Errorf("other = %d, want 3", v)
+	}
+	if v, _ := check.Get("same"); v != 1 && v != 2 {
+		t.Errorf("same = %d, want the winner's value", v)
+	}
+}
+
+// TestGroupCommit_ObserversSeeEachVersionOnce проверяет, что хук коммита
+// и асинхронный хук получают групповую версию один раз с объединёнными
+// изменениями, так что реплика через ApplyCommit принимает все версии.
+func TestGroupCommit_ObserversSeeEachVersionOnce(t *testing.T) {
+	ctx := context.Background()
+	replica := mvcc.NewMVCCMap[string, int](ctx)
+	defer replica.Close()
+
+	var (
+		mu        sync.Mutex
+		hookVIDs  []uint64
+		asyncVIDs []uint64
+		applyErrs []error
+	)
+	m := mvcc.NewMVCCMap[string, int](ctx,
+		mvcc.WithGroupCommit(50*time.Millisecond),
+		mvcc.WithCommitHook(func(_ context.Context, vid uint64, changes map[string]int, deletes []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			hookVIDs = append(hookVIDs, vid)
+			if err := replica.ApplyCommit(vid, changes, deletes); err != nil {
+				applyErrs = append(applyErrs, err)
+			}
+			return nil
+		}),
+		mvcc.WithAsyncCommitHook(func(vid uint64, _ map[string]int, _ []string) {
+			mu.Lock()
+			asyncVIDs = append(asyncVIDs, vid)
+			mu.Unlock()
+		}),
+	)
+
+	txs := make([]*mvcc.Tx[string, int], 4)
+	for i := range txs {
+		txs[i] = m.BeginTx(ctx)
+		_ = txs[i].Put(fmt.Sprintf("k%d", i), i)
+	}
+	for i, err := range commitConcurrently(txs...) {
+		if err != nil {
+			t.Fatalf("commit %d: %v", i, err)
+		}
+	}
+	m.Close() // дожидается асинхронного хука
+
+	mu.Lock()
+	defer mu.Unlock()
+	for name, vids := range map[string][]uint64{"commit hook": hookVIDs, "async hook": asyncVIDs} {
+		for i := 1; i < len(vids); i++ {
+			if vids[i] <= vids[i-1] {
+				t.Errorf("%s versions %v are not strictly increasing", name, vids)
+				break
+			}
+		}
+	}
+	if len(applyErrs) > 0 {
+		t.Errorf("replica rejected grouped versions: %v", applyErrs)
+	}
+	check := replica.BeginTx(ctx)
+	defer check.Rollback()
+	for i := range txs {
+		if v, ok := check.Get(fmt.Sprintf("k%d", i)); !ok || v != i {
+			t.Errorf("replica k%d = %d, %v; want %d", i, v, ok, i)
+		}
+	}
+}
+
+// TestGroupCommit_ConflictVersionWhenNothingInstalled проверяет, что
+// конфликт внутри группы, которая так и не установила версию (хук
+// отклонил коммит), ссылается на существующую текущую версию.
+func TestGroupCommit_ConflictVersionWhenNothingInstalled(t *testing.T) {
+	ctx := context.Background()
+	m := mvcc.NewMVCCMap[string, int](ctx,
+		mvcc.WithGroupCommit(50*time.Millisecond),
+		mvcc.WithCommitHookFailsCommit(true),
+		mvcc.WithCommitHook(func(context.Context, uint64, map[string]int, []string) error {
+			return errors.New("rejected")
+		}),
+	)
+	defer m.Close()
+
+	current := m.CurrentSnapshot()
+	currentID := current.ID()
+	current.Release()
+
+	a := m.BeginTx(ctx)
+	b := m.BeginTx(ctx)
+	_ = a.Put("k", 1)
+	_ = b.Put("k", 2)
+
+	outcomes := make([]*mvcc.CommitOutcome[string, int], 2)
+	var wg sync.WaitGroup
+	for i, tx := range []*mvcc.Tx[string, int]{a, b} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outcomes[i], _ = tx.CommitDetailed()
+		}()
+	}
+	wg.Wait()
+
+	var conflicts int
+	for _, out := range outcomes {
+		for _, c := range out.Conflicts {
+			conflicts++
+			if c.TheirsVersion != currentID {
+				t.Errorf("conflict on %q reports TheirsVersion %d, want installed %d", c.Key, c.TheirsVersion, currentID)
+			}
+		}
+	}
+	if conflicts != 1 {
+		t.Errorf("got %d conflicts, want 1 between the group members", conflicts)
+	}
+}
+
+// BenchmarkSmallWriters сравнивает throughput многих мелких писателей
+// над крупной map с group commit и без него: при group commit clone
+// всей карты выполняется один раз на группу, а не на каждый коммит.
+func BenchmarkSmallWriters(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []mvcc.Option
+	}{
+		{"direct", nil},
+		{"group", []mvcc.Option{mvcc.WithGroupCommit(200 * time.Microsecond)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			ctx := context.Background()
+			m := mvcc.NewMVCCMap[string, int](ctx, bc.opts...)
+			defer m.Close()
+
+			setup := m.BeginTx(ctx)
+			for i := range 50_000 {
+				_ = setup.Put(fmt.Sprintf("seed-%d", i), i)
+			}
+			_ = setup.Commit()
+
+			var writer atomic.Int64
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				key := fmt.Sprintf("w-%d", writer.Add(1))
+				for i := 0; pb.Next(); i++ {
+					tx := m.BeginTx(ctx)
+					_ = tx.Put(key, i)
+					_ = tx.Commit()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package mvcc
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// heartbeat отмечает живость фонового цикла: время последнего тика
+// по часам WithClockSource.
+type heartbeat struct {
+	interval time.Duration // 0 — цикл отключён и не проверяется
+	last     atomic.Int64  // UnixNano
+}
+
+func (h *heartbeat) beat(now time.Time) {
+	h.last.Store(now.UnixNano())
+}
+
+// stalled сообщает, не отмечался ли цикл к моменту now дольше stallAfter
+// (stallAfter <= 0 — три его интервала).
+func (h *heartbeat) stalled(now time.Time, stallAfter time.Duration) (time.Duration, bool) {
+	if h.interval <= 0 {
+		return 0, false
+	}
+	if stallAfter <= 0 {
+		stallAfter = 3 * h.interval
+	}
+	since := now.Sub(time.Unix(0, h.last.Load()))
+	return since, since > stallAfter
+}
+
+// guardPass выполняет один проход GC или детектора дедлоков, перехватывая
+// панику (ошибка в коде map или в пользовательском хуке вроде
+// WithVersionFinalizer). Без этого фоновая горутина умерла бы молча:
+// версии копились бы или дедлоки перестали бы разрешаться, а ручной
+// проход (RunGCNow, коммит с WithInlineGC) уронил бы вызывающего. Паника
+// логируется с трассой стека и считается в Stats.BackgroundPanics;
+// фоновый цикл продолжается со следующего тика.
+func (m *MVCCMap[K, V]) guardPass(loop string, pass func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			m.stats.backgroundPanics.Add(1)
+			m.logger.Error("GC or deadlock detector pass panicked, recovered",
+				"loop", loop,
+				"panic", r,
+				"stack", string(debug.Stack()),
+			)
+		}
+	}()
+	pass()
+}
+
+// HealthCheck — проверка для liveness-проб. Возвращает ErrClosed после
+// Close и ErrUnhealthy (с пояснением), если фоновый цикл GC или детектора
+// дедлоков перестал тикать или число версий превысило порог
+// WithHealthThresholds. Отключённые циклы (WithManualGC и т. п.)
+// не проверяются.
+func (m *MVCCMap[K, V]) HealthCheck() error {
+	if m.closed.Load() {
+		return ErrClosed
+	}
+
+	now, stallAfter := m.cfg.clockSource.Now(), m.cfg.healthStallAfter
+	if since, ok := m.gcBeat.stalled(now, stallAfter); ok {
+		return fmt.Errorf("%w: GC loop stalled, last tick %v ago", ErrUnhealthy, since)
+	}
+	if since, ok := m.detectorBeat.stalled(now, stallAfter); ok {
+		return fmt.Errorf("%w: deadlock detector stalled, last tick %v ago", ErrUnhealthy, since)
+	}
+
+	if limit := m.cfg.healthMaxVersions; limit > 0 {
+		if n := m.VersionCount(); n > limit {
+			return fmt.Errorf("%w: %d versions retained, danger threshold %d", ErrUnhealthy, n, limit)
+		}
+	}
+	return nil
+}
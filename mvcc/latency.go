@@ -0,0 +1,104 @@
+package mvcc
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// latencySubBuckets — число поддиапазонов на каждую степень двойки:
+// оценка квантиля завышена не более чем на 1/latencySubBuckets (12.5%).
+const (
+	latencySubBits    = 3
+	latencySubBuckets = 1 << latencySubBits
+	latencyBuckets    = 65 * latencySubBuckets
+)
+
+// latencyHistogram — потоковая оценка квантилей длительности транзакций:
+// лог-линейная гистограмма наносекунд с атомарными счётчиками. Запись —
+// несколько атомарных операций без мьютекса, память фиксирована
+// независимо от числа транзакций.
+type latencyHistogram struct {
+	counts [latencyBuckets]atomic.Uint64
+	total  atomic.Uint64
+	max    atomic.Int64
+}
+
+// latencyBucket возвращает индекс корзины для ns: старшие биты задают
+// степень двойки, следующие latencySubBits — поддиапазон внутри неё.
+func latencyBucket(ns uint64) int {
+	n := bits.Len64(ns)
+	if n <= latencySubBits {
+		return int(ns)
+	}
+	sub := (ns >> (n - 1 - latencySubBits)) & (latencySubBuckets - 1)
+	return n*latencySubBuckets + int(sub)
+}
+
+// latencyUpperBound возвращает верхнюю границу корзины i в наносекундах.
+func latencyUpperBound(i int) uint64 {
+	if i < latencySubBuckets {
+		return uint64(i)
+	}
+	n, sub := i/latencySubBuckets, uint64(i%latencySubBuckets)
+	base := uint64(1) << (n - 1)
+	step := base >> latencySubBits
+	return base + (sub+1)*step - 1
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	ns := max(d.Nanoseconds(), 0)
+	h.counts[latencyBucket(uint64(ns))].Add(1)
+	h.total.Add(1)
+	for {
+		cur := h.max.Load()
+		if ns <= cur || h.max.CompareAndSwap(cur, ns) {
+			return
+		}
+	}
+}
+
+// quantile возвращает оценку q-квантиля (0 < q <= 1): верхнюю границу
+// корзины, в которой накопленная доля достигает q, но не больше максимума.
+func (h *latencyHistogram) quantile(q float64, total uint64) time.Duration {
+	rank := uint64(q*float64(total) + 0.5)
+	rank = max(rank, 1)
+	var seen uint64
+	for i := range h.counts {
+		seen += h.counts[i].Load()
+		if seen >= rank {
+			return time.Duration(min(latencyUpperBound(i), uint64(h.max.Load())))
+		}
+	}
+	return time.Duration(h.max.Load())
+}
+
+// LatencyStats — распределение длительности транзакций от BeginTx до
+// завершения (Commit или Rollback). Квантили — оценки с погрешностью
+// до 12.5% в большую сторону.
+type LatencyStats struct {
+	Count         uint64
+	P50, P95, P99 time.Duration
+	Max           time.Duration
+}
+
+// TxLatencyStats возвращает распределение длительности транзакций,
+// завершённых с момента создания map. Без WithLatencyTracking —
+// нулевое значение.
+func (m *MVCCMap[K, V]) TxLatencyStats() LatencyStats {
+	h := m.latency
+	if h == nil {
+		return LatencyStats{}
+	}
+	total := h.total.Load()
+	if total == 0 {
+		return LatencyStats{}
+	}
+	return LatencyStats{
+		Count: total,
+		P50:   h.quantile(0.50, total),
+		P95:   h.quantile(0.95, total),
+		P99:   h.quantile(0.99, total),
+		Max:   time.Duration(h.max.Load()),
+	}
+}
@@ -2,10 +2,16 @@ package mvcc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // MVCCMap — конкурентная in-memory map с поддержкой транзакций
@@ -26,6 +32,17 @@ type MVCCMap[K comparable, V any] struct {
 	nextTxID      atomic.Uint64
 	nextVersionID atomic.Uint64
 
+	// ackedVersion — последняя версия, чей коммит подтверждён вызывающему
+	// (барьер TxOptions.Linearizable). Пишется под m.mu.
+	ackedVersion atomic.Uint64
+
+	// fair — очередь коммитов WithFairCommitScheduling.
+	fair fairQueue
+
+	// Тревога WithIDWrapGuard уже поднята для счётчика.
+	txIDWrapWarned      atomic.Bool
+	versionIDWrapWarned atomic.Bool
+
 	// activeTxs хранит метаданные активных транзакций для:
 	// 1. GC: min(snapshotID среди активных) — ниже не удаляем версии
 	// 2. Deadlock detection: граф ожидания
@@ -36,9 +53,66 @@ type MVCCMap[K comparable, V any] struct {
 	// Храним отдельно от linked list, т.к. нам нужен O(1) доступ по ID.
 	versions   []*version[K, V]
 	versionsMu sync.Mutex
+	gcStats    gcStats // защищён versionsMu
+
+	// versionsFreed закрывается (и заменяется новым) после прохода GC,
+	// удалившего хотя бы одну версию. Защищён versionsMu.
+	versionsFreed chan struct{}
+	gcCursor      int // позиция продолжения прохода GC (WithGCTimeBudget), под versionsMu
+
+	// versionInstalled закрывается (и обнуляется) при установке версии,
+	// будя WaitForVersion. nil — никто не ждёт. Защищён versionsMu.
+	versionInstalled chan struct{}
+
+	tombstones []tombstoneRef[K] // очередь на удаление из новых версий, под mu
+
+	inlineGCCommits atomic.Uint64 // счётчик коммитов для WithInlineGC
+
+	group groupCommitter[K, V] // WithGroupCommit
+
+	watchers watchRegistry[K, V] // WatchKeys
+	async    *asyncQueue[K, V]   // WithAsyncCommitHook, nil — без хука
+
+	// tags — именованные закреплённые версии (Tag/ReadTag/DropTag).
+	tags   map[string]*Snapshot[K, V]
+	tagsMu sync.Mutex
 
-	logger *slog.Logger
+	// txSlots — счётный семафор WithMaxConcurrentTx (nil — без ограничения).
+	txSlots chan struct{}
 
+	cfg   config
+	stats mapStats
+
+	encode    func(V) V // WithValueCodec, nil — без преобразования
+	decode    func(V) V
+	hasher    func(V) uint64    // WithValueHasher, nil — без пропуска no-op записей
+	equals    func(a, b V) bool // WithValueEquals, nil — записи не сравниваются со снапшотом
+	normalize func(K) K         // WithKeyNormalizer, nil — ключи как есть
+
+	access  *accessTracker[K] // WithAccessTracking, nil — без учёта чтений
+	latency *latencyHistogram // WithLatencyTracking, nil — без учёта длительности
+
+	// dataPool — очищенные карты собранных версий (WithDataMapPool), nil — без пула.
+	dataPool *sync.Pool
+
+	// newStore создаёт пустой Store для нулевой версии (WithStore).
+	newStore func() Store[K, V]
+
+	commitHook CommitHook[K, V]
+	validator  func(changes map[K]V, deletes []K) error
+	onConflict ConflictCallback[K]
+	logger     *slog.Logger
+
+	// Живость фоновых циклов для HealthCheck.
+	gcBeat       heartbeat
+	detectorBeat heartbeat
+
+	// detectionSampled — последний проход детектора строил граф по выборке
+	// (WithMaxActiveTxsForDetection); предупреждение пишется при входе
+	// в этот режим, а не на каждом проходе.
+	detectionSampled atomic.Bool
+
+	closed atomic.Bool
 	stopGC context.CancelFunc
 	gcDone chan struct{}
 }
@@ -56,58 +130,517 @@ func NewMVCCMap[K comparable, V any](ctx context.Context, opts ...Option) *MVCCM
 	gcCtx, stopGC := context.WithCancel(ctx)
 
 	m := &MVCCMap[K, V]{
-		activeTxs: make(map[uint64]*txMeta),
-		logger:    cfg.logger,
-		stopGC:    stopGC,
-		gcDone:    make(chan struct{}),
+		activeTxs:     make(map[uint64]*txMeta),
+		versionsFreed: make(chan struct{}),
+		cfg:           cfg,
+		encode:        typedOption[func(V) V](cfg.valueEncode, "WithValueCodec"),
+		decode:        typedOption[func(V) V](cfg.valueDecode, "WithValueCodec"),
+		hasher:        typedOption[func(V) uint64](cfg.valueHasher, "WithValueHasher"),
+		equals:        typedOption[func(a, b V) bool](cfg.valueEquals, "WithValueEquals"),
+		normalize:     typedOption[func(K) K](cfg.keyNormalize, "WithKeyNormalizer"),
+		newStore:      typedOption[func() Store[K, V]](cfg.storeFactory, "WithStore"),
+		commitHook:    typedOption[CommitHook[K, V]](cfg.commitHook, "WithCommitHook"),
+		validator:     typedOption[func(map[K]V, []K) error](cfg.validator, "WithCommitValidator"),
+		onConflict:    typedOption[ConflictCallback[K]](cfg.onConflict, "WithOnConflict"),
+		logger:        cfg.logger,
+		stopGC:        stopGC,
+		gcDone:        make(chan struct{}),
+	}
+
+	if m.newStore == nil {
+		m.newStore = newMapStore[K, V]
+	}
+	if cfg.maxConcurrentTx > 0 {
+		m.txSlots = make(chan struct{}, cfg.maxConcurrentTx)
+	}
+	if cfg.accessTracking {
+		m.access = &accessTracker[K]{}
+	}
+	if cfg.dataMapPool {
+		m.dataPool = &sync.Pool{}
+	}
+	if cfg.latencyTracking {
+		m.latency = &latencyHistogram{}
+	}
+	if hook := typedOption[AsyncCommitHook[K, V]](cfg.asyncHook, "WithAsyncCommitHook"); hook != nil {
+		m.async = &asyncQueue[K, V]{
+			hook: hook,
+			ch:   make(chan asyncEvent[K, V], cfg.asyncQueueSize),
+			done: make(chan struct{}),
+		}
+		go m.async.run()
 	}
 
 	// Инициализируем нулевую версию (пустая карта).
-	v0 := newVersion[K, V](0, make(map[K]versionedValue[V]))
+	v0 := newVersion[K, V](0, m.newStore())
 	m.current.Store(v0)
 	m.versions = []*version[K, V]{v0}
 
-	go m.runGC(gcCtx, cfg.gcInterval)
-	go m.runDeadlockDetector(gcCtx, cfg.deadlockCheckInterval)
+	gcInterval, deadlockInterval := cfg.gcInterval, cfg.deadlockCheckInterval
+	if cfg.manualGC {
+		gcInterval = 0
+	}
+	if cfg.manualDeadlockDetection {
+		deadlockInterval = 0
+	}
+	// Первый удар — до запуска горутин, чтобы HealthCheck сразу после
+	// NewMVCCMap не принял ещё не стартовавший цикл за зависший.
+	m.gcBeat.interval, m.detectorBeat.interval = gcInterval, deadlockInterval
+	m.gcBeat.beat(m.cfg.clockSource.Now())
+	m.detectorBeat.beat(m.cfg.clockSource.Now())
+	go m.runGC(gcCtx, gcInterval)
+	go m.runDeadlockDetector(gcCtx, deadlockInterval)
 
 	return m
 }
 
 // Close останавливает фоновые горутины. Блокируется до их завершения.
+//
+// После Close новые транзакции не создаются (ErrClosed), а Commit
+// ещё не завершённых возвращает ErrClosed: без GC-горутины их версии
+// копились бы бесконечно. Повторный вызов безопасен.
 func (m *MVCCMap[K, V]) Close() {
+	m.closed.Store(true)
+	m.wakeVersionWaiters()
 	m.stopGC()
 	<-m.gcDone
+	m.closeAsync()
 }
 
 // BeginTx начинает новую транзакцию, захватывая снапшот текущей версии.
 //
 // Снапшот захватывается атомарно через atomic.Pointer — без мьютекса.
 // Это ключевое свойство: readers никогда не ждут writers.
+//
+// Если транзакцию начать нельзя (например, map закрыта), возвращается
+// уже завершённая транзакция, все операции которой возвращают причину.
+// Чтобы получить ошибку сразу, используйте BeginTxContext.
 func (m *MVCCMap[K, V]) BeginTx(ctx context.Context) *Tx[K, V] {
-	txID := m.nextTxID.Add(1)
+	tx, err := m.BeginTxContext(ctx)
+	if err != nil {
+		return m.doneTx(ctx, err)
+	}
+	return tx
+}
 
-	// atomic.Pointer.Load() — acquire семантика, гарантирует, что мы видим
-	// все записи, которые предшествовали Store() этой версии.
-	snap := m.current.Load()
-	snap.refCount.Add(1) // держим версию живой, пока транзакция активна
+// BeginTxContext — как BeginTx, но возвращает ErrClosed после Close
+// и ErrTxCanceled, если ctx уже отменён.
+func (m *MVCCMap[K, V]) BeginTxContext(ctx context.Context) (*Tx[K, V], error) {
+	return m.beginTx(ctx, TxOptions{})
+}
 
-	txCtx, cancel := context.WithCancel(ctx)
+// BeginTxWith — как BeginTx, но с параметрами транзакции.
+func (m *MVCCMap[K, V]) BeginTxWith(ctx context.Context, opts TxOptions) *Tx[K, V] {
+	tx, err := m.beginTx(ctx, opts)
+	if err != nil {
+		return m.doneTx(ctx, err)
+	}
+	return tx
+}
+
+// Update атомарно изменяет один ключ без ручных BeginTx/Commit: fn получает
+// текущее значение (existed == false, если ключа нет) и возвращает новое;
+// keep == false удаляет ключ; если ключа и не было, ничего не пишется:
+// ни tombstone, ни новой версии, ни вызова хуков. При ErrConflict
+// транзакция повторяется с новым снапшотом, поэтому fn может вызываться
+// несколько раз и не должна иметь побочных эффектов. Повторы прекращаются
+// при отмене ctx.
+func (m *MVCCMap[K, V]) Update(ctx context.Context, key K, fn func(old V, existed bool) (v V, keep bool)) error {
+	for {
+		tx, err := m.BeginTxContext(ctx)
+		if err != nil {
+			return err
+		}
+		old, existed := tx.lookup(key)
+		if v, keep := fn(old, existed); keep {
+			err = tx.Put(key, v)
+		} else if existed {
+			err = tx.Delete(key)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		tx.Rollback()
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+}
+
+// independentCommitAttempts — сколько раз CommitIndependent пробует
+// закоммитить ключ, получивший ErrConflict.
+const independentCommitAttempts = 3
+
+// CommitIndependent записывает entries, коммитя каждый ключ отдельной
+// маленькой транзакцией с повтором при ErrConflict. Для загрузчиков,
+// которым не нужна атомарность всего пакета: конфликт одного ключа
+// не отменяет остальные. Возвращает число применённых ключей и ключи,
+// которые применить не удалось — конфликт после всех повторов либо
+// отмена ctx или Close.
+func (m *MVCCMap[K, V]) CommitIndependent(ctx context.Context, entries map[K]V) (applied int, conflicts []K) {
+	for k, v := range entries {
+		if err := m.commitSingle(ctx, k, v); err != nil {
+			conflicts = append(conflicts, k)
+			continue
+		}
+		applied++
+	}
+	return applied, conflicts
+}
+
+// commitSingle коммитит одну запись, повторяя её при ErrConflict.
+func (m *MVCCMap[K, V]) commitSingle(ctx context.Context, key K, value V) error {
+	var err error
+	for range independentCommitAttempts {
+		var tx *Tx[K, V]
+		if tx, err = m.BeginTxContext(ctx); err != nil {
+			return err
+		}
+		if err = tx.Put(key, value); err == nil {
+			err = tx.Commit()
+		}
+		tx.Rollback()
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+// PutBatch записывает entries, повторяя при ErrConflict не весь пакет,
+// а только конфликтующие ключи. Транзакция пакета коммитится с полным
+// отчётом о конфликтах; конфликтующие ключи откладываются, остальные
+// коммитятся сразу новой транзакцией, а отложенные повторяются следующими,
+// до independentCommitAttempts раундов. Для больших пакетов это дешевле
+// повтора целиком, но пакет перестаёт быть атомарным: его основная часть
+// видима раньше, чем повторённые ключи.
+//
+// Возвращает ключи, ушедшие на повтор после первого раунда (с
+// WithKeyNormalizer — нормализованные). Ошибка — ErrConflict, если ключи
+// не удалось записать за все раунды, отмена ctx или Close; в этом случае
+// часть пакета уже может быть закоммичена.
+func (m *MVCCMap[K, V]) PutBatch(ctx context.Context, entries map[K]V) (retried []K, err error) {
+	// Конфликты сообщают нормализованные ключи: пакет приводим к ним же,
+	// иначе отложенный ключ не нашёлся бы в pending.
+	pending := entries
+	if m.normalize != nil {
+		pending = make(map[K]V, len(entries))
+		for k, v := range entries {
+			pending[m.normalize(k)] = v
+		}
+	}
+	for round := range independentCommitAttempts {
+		var failed map[K]V
+		if failed, err = m.putBatchRound(ctx, pending); err != nil {
+			return retried, err
+		}
+		if len(failed) == 0 {
+			return retried, nil
+		}
+		if round == 0 {
+			retried = slices.Collect(maps.Keys(failed))
+		}
+		pending = failed
+	}
+	return retried, fmt.Errorf("%w: %d batch keys still conflicting after %d attempts",
+		ErrConflict, len(pending), independentCommitAttempts)
+}
+
+// putBatchRound коммитит entries, исключая ключи, на которых коммит
+// получает ErrConflict, и возвращает исключённые ключи с их значениями.
+// Каждая неудачная попытка убирает хотя бы один ключ, поэтому цикл конечен.
+func (m *MVCCMap[K, V]) putBatchRound(ctx context.Context, entries map[K]V) (map[K]V, error) {
+	var failed map[K]V
+	pending := maps.Clone(entries)
+	for len(pending) > 0 {
+		tx, err := m.beginTx(ctx, TxOptions{FullConflictReport: true})
+		if err != nil {
+			return failed, err
+		}
+		for k, v := range pending {
+			if err = tx.Put(k, v); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		tx.Rollback()
+		if err == nil {
+			return failed, nil
+		}
+		if !errors.Is(err, ErrConflict) || len(tx.conflicts) == 0 {
+			return failed, err
+		}
+		if failed == nil {
+			failed = make(map[K]V, len(tx.conflicts))
+		}
+		for _, c := range tx.conflicts {
+			failed[c.Key] = pending[c.Key]
+			delete(pending, c.Key)
+		}
+	}
+	return failed, nil
+}
 
+// CompareAndReplace заменяет всё содержимое map на newData одной новой
+// версией, если текущая версия — expectedVersion; иначе возвращает
+// ErrConflict. Оптимистичная конкурентность на уровне всего набора
+// данных (например, конфигурации): прочитать версию через Snapshot,
+// построить новое состояние и заменить, только если никто не успел
+// закоммитить раньше. Возвращает ID установленной версии.
+//
+// Ключи, отсутствующие в newData, удаляются, а присутствующие с нулевым
+// значением V сохраняются как ключи: newData трактуется как полный набор
+// присутствующих ключей. Хук коммита, WatchKeys и асинхронный хук
+// получают изменения как от обычной транзакции.
+func (m *MVCCMap[K, V]) CompareAndReplace(expectedVersion uint64, newData map[K]V) (uint64, error) {
+	if m.closed.Load() {
+		return 0, ErrClosed
+	}
+
+	defer m.enterFairQueue("")()
+	unlock := m.lockCommit()
+	defer unlock()
+
+	current := m.current.Load()
+	if current.id != expectedVersion {
+		return 0, fmt.Errorf("%w: map is at version %d, expected %d", ErrConflict, current.id, expectedVersion)
+	}
+	if m.normalize != nil {
+		normalized := make(map[K]V, len(newData))
+		for k, v := range newData {
+			normalized[m.normalize(k)] = v
+		}
+		newData = normalized
+	}
+
+	// Служебная транзакция не регистрируется в activeTxs: её снапшот —
+	// текущая версия под m.mu, конфликтовать ей не с чем.
+	tx := &Tx[K, V]{
+		id:       m.newTxID(),
+		db:       m,
+		snapshot: current,
+		ctx:      context.Background(),
+		writes:   make(map[K]versionedValue[V], len(newData)),
+	}
+	for k, vv := range current.data.Iterate {
+		if _, keep := newData[k]; !keep && !vv.deleted {
+			tx.writes[k] = versionedValue[V]{writerTxID: tx.id, deleted: true}
+		}
+	}
+	for k, v := range newData {
+		tx.writes[k] = versionedValue[V]{value: v, writerTxID: tx.id}
+	}
+
+	newVID := m.nextVersionID.Load() + 1
+	if err := m.runCommitHook(tx, newVID); err != nil {
+		return 0, err
+	}
+	data := m.cloneData(current)
+	size := m.applyWrites(data, current.size, tx)
+	m.stampCommitTS(data, tx)
+	m.purgeTombstones(data)
+	m.installVersion(newVID, data, size)
+	m.committed(tx, newVID)
+	return newVID, nil
+}
+
+func (m *MVCCMap[K, V]) beginTx(ctx context.Context, opts TxOptions) (*Tx[K, V], error) {
 	tx := &Tx[K, V]{
+		writes:  make(map[K]versionedValue[V]),
+		readSet: make(map[K]struct{}),
+		db:      m,
+	}
+	if err := m.initTx(tx, ctx, opts); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// initTx (пере)инициализирует tx как новую активную транзакцию.
+// Буферы tx.writes и tx.readSet должны быть пустыми.
+func (m *MVCCMap[K, V]) initTx(tx *Tx[K, V], ctx context.Context, opts TxOptions) error {
+	if m.closed.Load() {
+		return ErrClosed
+	}
+
+	// Барьер читается до снапшота: транзакция должна увидеть всё,
+	// что было подтверждено до начала BeginTx.
+	var barrier uint64
+	if opts.Linearizable {
+		barrier = m.ackedVersion.Load()
+	}
+
+	ctx, releaseCtx := defaultTxContext(ctx, m.cfg.defaultTxCtx)
+	admitted := false
+	defer func() {
+		if !admitted {
+			releaseCtx()
+		}
+	}()
+
+	if m.cfg.admissionControl && m.cfg.maxVersions > 0 {
+		m.waitForVersionCapacity(ctx)
+	}
+
+	// Отменённый ctx: транзакция всё равно не сможет ничего сделать.
+	// Не регистрируем её и не закрепляем снапшот — иначе цикл повторов
+	// с мёртвым ctx копил бы записи activeTxs и ссылки на версии.
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrTxCanceled, context.Cause(ctx))
+	}
+
+	if err := m.acquireTxSlot(ctx); err != nil {
+		return err
+	}
+
+	txID := m.newTxID()
+
+	snap := m.acquireCurrent() // держим версию живой, пока транзакция активна
+
+	txCtx, abort := context.WithCancelCause(ctx)
+
+	var beginTS uint64
+	if m.cfg.clock != nil {
+		beginTS = m.cfg.clock()
+	}
+
+	tx.id = txID
+	tx.snapshot = snap
+	tx.beginTS = beginTS
+	tx.opts = opts
+	tx.strictReads = m.cfg.strictReads
+	tx.doneErr = nil
+	tx.commitVID = 0
+	tx.bump = false
+	tx.conflicts = tx.conflicts[:0]
+	tx.merged = tx.merged[:0]
+	tx.trace = tx.trace[:0]
+	tx.ctx = txCtx
+	tx.cancel = func() {
+		abort(nil)
+		releaseCtx()
+	}
+
+	tx.begunAt = time.Now()
+	meta := &txMeta{
 		id:       txID,
-		snapshot: snap,
+		label:    opts.Label,
+		priority: opts.Priority,
+		begunAt:  tx.begunAt,
+		abort:    abort,
+		release:  tx.cancel,
+		unpin:    snap.unpinOrphaned,
+	}
+	meta.snapshotID.Store(snap.id)
+	tx.meta = meta
+	tx.state.Store(uint32(txActive))
+	m.activeTxsMu.Lock()
+	m.activeTxs[txID] = meta
+	m.activeTxsMu.Unlock()
+
+	admitted = true
+	if snap.id < barrier {
+		if err := tx.awaitSnapshot(barrier); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+// doneTx создаёт транзакцию, завершённую с самого начала: она не
+// регистрируется в activeTxs и не удерживает снапшот, а все её
+// операции возвращают err.
+func (m *MVCCMap[K, V]) doneTx(ctx context.Context, err error) *Tx[K, V] {
+	txCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	tx := &Tx[K, V]{
+		snapshot: m.current.Load(), // без refCount: Commit/Rollback его не отпустят
 		writes:   make(map[K]versionedValue[V]),
 		readSet:  make(map[K]struct{}),
+		doneErr:  err,
 		ctx:      txCtx,
 		cancel:   cancel,
 		db:       m,
 	}
+	tx.meta = &txMeta{}
+	tx.meta.finalized.Store(true) // освобождать нечего
+	tx.state.Store(uint32(txRolledBack))
+	return tx
+}
 
-	m.activeTxsMu.Lock()
-	m.activeTxs[txID] = &txMeta{id: txID}
-	m.activeTxsMu.Unlock()
+// waitForVersionCapacity блокируется, пока число версий не опустится
+// ниже потолка WithMaxVersions, или до отмены ctx. Во втором случае
+// initTx возвращает ErrTxCanceled, не регистрируя транзакцию.
+func (m *MVCCMap[K, V]) waitForVersionCapacity(ctx context.Context) {
+	for {
+		m.versionsMu.Lock()
+		if len(m.versions) < m.cfg.maxVersions {
+			m.versionsMu.Unlock()
+			return
+		}
+		freed := m.versionsFreed
+		m.versionsMu.Unlock()
 
-	return tx
+		select {
+		case <-ctx.Done():
+			return
+		case <-freed:
+		}
+	}
+}
+
+// acquireTxSlot занимает слот семафора WithMaxConcurrentTx, блокируясь
+// до освобождения слота или отмены ctx.
+func (m *MVCCMap[K, V]) acquireTxSlot(ctx context.Context) error {
+	if m.txSlots == nil {
+		return nil
+	}
+	select {
+	case m.txSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrTxCanceled, ctx.Err())
+	}
+}
+
+func (m *MVCCMap[K, V]) releaseTxSlot() {
+	if m.txSlots != nil {
+		<-m.txSlots
+	}
+}
+
+// acquireCurrent загружает текущую версию и увеличивает её refCount.
+//
+// Между Load() и refCount.Add(1) есть узкое окно: конкурентный commit
+// может сделать версию нетекущей, а GC — увидеть refCount == 0 и удалить её
+// из m.versions. Поэтому после инкремента мы перечитываем m.current:
+//   - если указатель не изменился — GC уже не сможет удалить версию;
+//   - иначе отпускаем ссылку и повторяем с новой текущей версией.
+//
+// Порядок памяти: все операции sync/atomic в Go последовательно согласованы.
+// Если повторный Load() вернул snap, то он предшествует Store() следующей
+// версии, а значит и чтению currentID в collectVersions. Проверка refCount
+// в GC идёт после чтения currentID и гарантированно видит наш инкремент.
+// GC никогда не удаляет текущую версию, поэтому версия, оставшаяся текущей
+// после инкремента, защищена с обеих сторон.
+//
+// Цикл не создаёт livelock на практике: повтор нужен только если коммит
+// произошёл ровно между двумя Load(), а это окно в несколько наносекунд.
+func (m *MVCCMap[K, V]) acquireCurrent() *version[K, V] {
+	for {
+		// atomic.Pointer.Load() — acquire семантика, гарантирует, что мы видим
+		// все записи, которые предшествовали Store() этой версии.
+		snap := m.current.Load()
+		snap.refCount.Add(1)
+		if m.current.Load() == snap {
+			return snap
+		}
+		snap.refCount.Add(-1)
+	}
 }
 
 // commit выполняется под мьютексом для атомарной проверки конфликтов
@@ -118,51 +651,494 @@ func (m *MVCCMap[K, V]) BeginTx(ctx context.Context) *Tx[K, V] {
 // Мьютекс гарантирует прогресс (fairness через runtime планировщик).
 // При этом критическая секция минимальна: только conflict check + pointer swap.
 func (m *MVCCMap[K, V]) commit(tx *Tx[K, V]) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.yield(PointCommit, tx.id)
+	if m.cfg.groupCommitWindow > 0 && !tx.opts.ChunkedCommit {
+		// Члены группового коммита ждут лидера: удерживая очередь
+		// WithFairCommitScheduling, они заблокировали бы его.
+		return m.commitGrouped(tx)
+	}
+	defer m.enterFairQueue(tx.opts.Group)()
+	if tx.opts.ChunkedCommit {
+		return m.commitChunked(tx)
+	}
+	return m.commitLocked(tx)
+}
+
+// commitLocked — обычный путь коммита: всё, включая clone, под m.mu.
+func (m *MVCCMap[K, V]) commitLocked(tx *Tx[K, V]) error {
+	var validated *version[K, V]
+	if m.cfg.optimisticValidation {
+		pre := m.acquireCurrent()
+		if m.prevalidate(tx, pre) {
+			validated = pre
+		}
+		pre.refCount.Add(-1)
+	}
+
+	unlock := m.lockCommit()
+	defer unlock()
+
+	// Авторитетна проверка под m.mu. Если с успешной предварительной
+	// проверки версия не сменилась, её результат остаётся верным: versions
+	// неизменяемы, а новые коммиты проходят только через m.mu. Найденный
+	// заранее конфликт подтверждается здесь же, чтобы трасса, отчёт и
+	// WithOnConflict получили его один раз и под мьютексом.
+	current := m.current.Load()
+	if current != validated {
+		if err := m.validate(tx, current); err != nil {
+			return err
+		}
+	}
+	m.resolveMerges(tx, current)
+
+	// ID версии резервируем, но счётчик двигаем только после установки:
+	// отклонённый хуком коммит не должен оставлять дыр в нумерации.
+	// Под m.mu других писателей nextVersionID нет.
+	newVID := m.nextVersionID.Load() + 1
+
+	if err := m.runCommitHook(tx, newVID); err != nil {
+		return err
+	}
+
+	// Создаём новую версию: клонируем текущую и применяем наши изменения.
+	newData := m.cloneData(current)
+	size := m.applyWrites(newData, current.size, tx)
+	m.stampCommitTS(newData, tx)
+
+	m.purgeTombstones(newData)
+	m.installVersion(newVID, newData, size)
+	m.committed(tx, newVID)
+	return nil
+}
+
+// bumpVersion устанавливает новую версию без изменений для CommitBump.
+// Версии неизменяемы, поэтому новая разделяет data с текущей: O(1)
+// вместо clone. Хук вызывается, как и для любой версии, — иначе
+// потребители (например, реплика через ApplyCommit) увидели бы дыру
+// в нумерации.
+func (m *MVCCMap[K, V]) bumpVersion(tx *Tx[K, V]) error {
+	defer m.enterFairQueue(tx.opts.Group)()
+	unlock := m.lockCommit()
+	defer unlock()
 
 	current := m.current.Load()
+	newVID := m.nextVersionID.Load() + 1
+	if err := m.runCommitHook(tx, newVID); err != nil {
+		return err
+	}
+	current.noRecycle.Store(true)
+	m.installVersion(newVID, current.data, current.size).noRecycle.Store(true)
+	m.committed(tx, newVID)
+	return nil
+}
+
+// enterFairQueue занимает очередь WithFairCommitScheduling для group
+// и возвращает функцию, освобождающую её. Без опции ничего не делает.
+// Вызывается до lockCommit всеми, кто устанавливает версии, кроме
+// участников группового коммита.
+func (m *MVCCMap[K, V]) enterFairQueue(group string) (release func()) {
+	if !m.cfg.fairCommits {
+		return func() {}
+	}
+	m.fair.acquire(group)
+	return m.fair.release
+}
 
-	// Write-write conflict detection:
+// lockCommit захватывает m.mu и возвращает функцию разблокировки,
+// которая учитывает время удержания мьютекса в Stats.
+func (m *MVCCMap[K, V]) lockCommit() (unlock func()) {
+	// TryLock без ожидания почти бесплатен; неудача означает, что коммиту
+	// придётся ждать, — это и считаем contention.
+	if !m.mu.TryLock() {
+		m.stats.contended.Add(1)
+		m.mu.Lock()
+	}
+	// Монотонные часы time.Now() — дешёвый способ измерить удержание m.mu.
+	lockedAt := time.Now()
+	return func() {
+		m.stats.recordCommitLock(time.Since(lockedAt))
+		m.mu.Unlock()
+	}
+}
+
+// validate выполняет проверки конфликтов транзакции относительно current.
+// Вызывается под m.mu: конфликты записываются в трассу, в tx.conflicts
+// и в WithOnConflict.
+func (m *MVCCMap[K, V]) validate(tx *Tx[K, V], current *version[K, V]) error {
+	return m.checkConflicts(tx, current, true)
+}
+
+// prevalidate — предварительная проверка WithOptimisticValidation против
+// закреплённой версии без m.mu. Побочных эффектов не имеет: трассу,
+// конфликты и колбэк оставляет авторитетной проверке под мьютексом.
+func (m *MVCCMap[K, V]) prevalidate(tx *Tx[K, V], current *version[K, V]) bool {
+	return m.checkConflicts(tx, current, false) == nil
+}
+
+// checkConflicts — общая часть validate и prevalidate; report включает
+// трассу и отчёт о конфликтах.
+func (m *MVCCMap[K, V]) checkConflicts(tx *Tx[K, V], current *version[K, V], report bool) error {
+	// С WithFullConflictReport (или TxOptions.FullConflictReport) проверка
+	// не останавливается на первом конфликте, чтобы CommitDetailed
+	// перечислил все ключи.
+	fullReport := report && (m.cfg.fullConflictReport || tx.opts.FullConflictReport)
+	traced := report && tx.opts.Trace
+	var conflictErr error
+
+	// Write-write conflict detection (first-committer-wins):
 	// Для каждого ключа, который мы хотим записать, проверяем:
 	// был ли он изменён ПОСЛЕ нашего снапшота (т.е. другой транзакцией)?
 	for key := range tx.writes {
-		if vv, exists := current.data[key]; exists {
+		if _, merged := tx.merges[key]; merged {
+			if traced {
+				tx.traceCheck(TraceWriteCheck, key, false, "merge applies to the current value")
+			}
+			continue // Merge применяется к текущему значению и не конфликтует
+		}
+		if vv, exists := current.data.Get(key); exists {
 			// Если writerTxID != 0 и транзакция с таким ID уже не в нашем снапшоте —
 			// значит, этот ключ изменили после нашего BeginTx.
 			if vv.writerTxID != 0 && current.id > tx.snapshot.id {
 				// Проверяем, изменился ли именно этот ключ после нашего снапшота.
-				if snapVV, inSnap := tx.snapshot.data[key]; !inSnap ||
+				if snapVV, inSnap := tx.snapshot.data.Get(key); !inSnap ||
 					snapVV.writerTxID != vv.writerTxID {
-					return fmt.Errorf("%w: key conflict detected during commit", ErrConflict)
+					// С WithCommitTimestamps запись, закоммиченная не позже
+					// нашего времени начала, считается "старше чтения".
+					if m.cfg.clock != nil && vv.commitTS <= tx.beginTS {
+						if traced {
+							tx.traceCheck(TraceWriteCheck, key, false, "committed before the transaction began")
+						}
+						continue
+					}
+					if traced {
+						tx.traceCheck(TraceWriteCheck, key, true, "written by another transaction after the snapshot")
+					}
+					if report {
+						m.reportConflict(tx, key, current)
+					}
+					conflictErr = fmt.Errorf("%w: key conflict detected during commit%s", ErrConflict, tx.labelSuffix())
+					if !fullReport {
+						return conflictErr
+					}
+					continue
+				}
+			}
+		}
+		if traced {
+			tx.traceCheck(TraceWriteCheck, key, false, "unchanged since the snapshot")
+		}
+	}
+	if conflictErr != nil {
+		return conflictErr
+	}
+
+	// Serializable: валидация read set'а по принципу first-committer-wins.
+	// Для каждого прочитанного ключа сравниваем писателя в текущей версии
+	// с писателем, которого видела транзакция в своём снапшоте. Если они
+	// различаются — ключ изменила транзакция, закоммиченная раньше нас,
+	// и выигрывает именно она: мы получаем ErrReadValidation, а её коммит
+	// уже необратим. Последний коммитящий никогда не "перетирает" первого.
+	if m.cfg.isolation == Serializable && current.id > tx.snapshot.id {
+		for key := range tx.readSet {
+			changed := current.changedSince(tx.snapshot, key)
+			if traced {
+				reason := "unchanged since the snapshot"
+				if changed {
+					reason = "changed by an earlier committer"
+				}
+				tx.traceCheck(TraceReadCheck, key, changed, reason)
+			}
+			if changed {
+				if report {
+					m.reportConflict(tx, key, current)
+				}
+				conflictErr = fmt.Errorf("%w: key read by the transaction was changed by an earlier committer%s",
+					ErrReadValidation, tx.labelSuffix())
+				if !fullReport {
+					return conflictErr
 				}
 			}
 		}
 	}
 
-	// Создаём новую версию: клонируем текущую и применяем наши изменения.
-	newData := current.clone()
+	return conflictErr
+}
+
+// reportConflict запоминает конфликт в tx (для CommitDetailed), логирует
+// его и вызывает WithOnConflict. Только на пути отказа — успешные коммиты
+// за лог и колбэк не платят.
+func (m *MVCCMap[K, V]) reportConflict(tx *Tx[K, V], key K, current *version[K, V]) {
+	theirs := current.id
+	// Рабочая копия группового коммита: если ключ не трогали члены группы,
+	// конфликт — с уже установленной версией, а не с будущей.
+	if base := current.base; base != nil {
+		cur, _ := current.data.Get(key)
+		if prev, ok := base.data.Get(key); ok && prev.writerTxID == cur.writerTxID {
+			theirs = base.id
+		}
+	}
+	tx.conflicts = append(tx.conflicts, KeyConflict[K]{
+		Key:           key,
+		MineVersion:   tx.snapshot.id,
+		TheirsVersion: theirs,
+	})
+	m.logger.Debug("transaction conflict",
+		"tx", tx.id,
+		"label", tx.opts.Label,
+		"key", key,
+		"snapshot", tx.snapshot.id,
+		"current", theirs,
+	)
+	if m.onConflict != nil {
+		m.onConflict(tx.id, key, tx.snapshot.id, theirs)
+	}
+}
+
+// applyWrites переносит write buffer транзакции в data (кодируя значения)
+// и возвращает новое число живых ключей, начиная с size.
+func (m *MVCCMap[K, V]) applyWrites(data Store[K, V], size int, tx *Tx[K, V]) int {
 	for k, vv := range tx.writes {
-		newData[k] = vv
+		size = m.applyWrite(data, size, k, vv)
+	}
+	return size
+}
+
+func (m *MVCCMap[K, V]) applyWrite(data Store[K, V], size int, k K, vv versionedValue[V]) int {
+	prev, ok := data.Get(k)
+	if m.hasher != nil && !vv.deleted {
+		vv.hash = m.hasher(vv.value)
+		// Хеш — лишь быстрый фильтр: запись пропускается только при
+		// точном совпадении значений.
+		if ok && !prev.deleted && prev.hash == vv.hash &&
+			reflect.DeepEqual(vv.value, m.decodeValue(prev.value)) {
+			return size
+		}
+	}
+	if m.encode != nil && !vv.deleted {
+		vv.value = m.encode(vv.value)
+	}
+	if ok && !prev.deleted {
+		size--
 	}
+	if !vv.deleted {
+		size++
+	}
+	data.Set(k, vv)
+	return size
+}
 
-	newVID := m.nextVersionID.Add(1)
-	newVer := newVersion[K, V](newVID, newData)
+// stampCommitTS проставляет время коммита записанным ключам (WithCommitTimestamps).
+// Вызывается под m.mu, чтобы метки шли в порядке установки версий.
+func (m *MVCCMap[K, V]) stampCommitTS(data Store[K, V], tx *Tx[K, V]) {
+	if m.cfg.clock == nil {
+		return
+	}
+	commitTS := m.cfg.clock()
+	for k := range tx.writes {
+		vv, _ := data.Get(k)
+		if vv.writerTxID != tx.id { // no-op запись, пропущенная applyWrite
+			continue
+		}
+		vv.commitTS = commitTS
+		data.Set(k, vv)
+	}
+}
+
+// installVersion публикует новую версию и возвращает её. Вызывается под m.mu.
+func (m *MVCCMap[K, V]) installVersion(vid uint64, data Store[K, V], size int) *version[K, V] {
+	m.checkIDWrap("version", vid)
+	m.nextVersionID.Store(vid)
+	newVer := newVersion[K, V](vid, data)
+	newVer.size = size
+
+	// Сначала регистрируем версию для GC и Pin, потом публикуем: иначе
+	// транзакция могла бы захватить текущую версию, которой ещё нет
+	// в m.versions, и Pin её ID вернул бы ErrVersionCollected. Ещё не
+	// опубликованную версию GC не тронет: её ID не меньше minSnapshotID.
+	m.versionsMu.Lock()
+	m.versions = append(m.versions, newVer)
+	live := len(m.versions)
 
 	// Store с release семантикой: все операции до этого момента
-	// будут видны тем, кто сделает Load() после.
+	// будут видны тем, кто сделает Load() после. Публикация под
+	// versionsMu не даёт WaitForVersion проверить current между
+	// установкой и пробуждением и проспать её.
 	m.current.Store(newVer)
+	installed := m.versionInstalled
+	m.versionInstalled = nil
+	m.versionsMu.Unlock()
 
+	if installed != nil {
+		close(installed)
+	}
+	if limit := m.cfg.leakMaxVersions; limit > 0 && live > limit {
+		m.reportVersionLeak(live)
+	}
+	return newVer
+}
+
+// wakeVersionWaiters будит WaitForVersion при Close.
+func (m *MVCCMap[K, V]) wakeVersionWaiters() {
 	m.versionsMu.Lock()
-	m.versions = append(m.versions, newVer)
+	if m.versionInstalled != nil {
+		close(m.versionInstalled)
+		m.versionInstalled = nil
+	}
 	m.versionsMu.Unlock()
+}
+
+// newTxID выдаёт следующий ID транзакции.
+func (m *MVCCMap[K, V]) newTxID() uint64 {
+	id := m.nextTxID.Add(1)
+	m.checkIDWrap("tx", id)
+	return id
+}
+
+// checkIDWrap паникует, если счётчик counter перешёл через ноль (0 —
+// зарезервированное "нет ID"), и поднимает тревогу WithIDWrapGuard,
+// когда до переполнения осталось не больше headroom значений.
+func (m *MVCCMap[K, V]) checkIDWrap(counter string, id uint64) {
+	if id == 0 {
+		panic(fmt.Sprintf("mvcc: %s ID counter wrapped around math.MaxUint64", counter))
+	}
+	headroom := m.cfg.idWrapHeadroom
+	if headroom == 0 || id < math.MaxUint64-headroom {
+		return
+	}
+	warned := &m.txIDWrapWarned
+	if counter == "version" {
+		warned = &m.versionIDWrapWarned
+	}
+	if !warned.CompareAndSwap(false, true) {
+		return
+	}
+	if m.cfg.idWrapAlert != nil {
+		m.cfg.idWrapAlert(counter, id)
+		return
+	}
+	m.logger.Error("ID counter approaching wraparound", "counter", counter, "id", id)
+}
+
+// reportVersionLeak сообщает о превышении потолка WithVersionLeakDetector:
+// вызывает обработчик или, если его нет, паникует.
+func (m *MVCCMap[K, V]) reportVersionLeak(live int) {
+	if fn := m.cfg.leakHandler; fn != nil {
+		fn(live, m.cfg.leakMaxVersions)
+		return
+	}
+	panic(fmt.Sprintf("mvcc: %d live versions exceed leak detector ceiling %d", live, m.cfg.leakMaxVersions))
+}
+
+// cloneData копирует данные версии для нового коммита. С WithDataMapPool
+// карта берётся из пула собранных версий вместо новой аллокации; пул
+// пополняется только картами mapStore, так что с WithStore он пуст.
+func (m *MVCCMap[K, V]) cloneData(v *version[K, V]) Store[K, V] {
+	if m.dataPool == nil {
+		return v.clone()
+	}
+	data, ok := m.dataPool.Get().(mapStore[K, V])
+	if !ok {
+		return v.clone()
+	}
+	for k, vv := range v.data.Iterate {
+		data[k] = vv
+	}
+	return data
+}
 
+// committed завершает успешный коммит после установки версии: запоминает
+// её ID в tx (для CommitDetailed), уведомляет WatchKeys и логирует.
+// Вызывается под m.mu, чтобы уведомления шли в порядке версий.
+func (m *MVCCMap[K, V]) committed(tx *Tx[K, V], vid uint64) {
+	m.versionCommitted(tx.writes, vid)
+	m.txCommitted(tx, vid)
+}
+
+// versionCommitted — часть committed, относящаяся к версии, а не
+// к транзакции: ровно один раз на установленную версию (групповой коммит
+// передаёт объединённые записи группы).
+func (m *MVCCMap[K, V]) versionCommitted(writes map[K]versionedValue[V], vid uint64) {
+	m.ackedVersion.Store(vid)
+	for k, vv := range writes {
+		if vv.deleted {
+			m.trackTombstone(k, vv.writerTxID, vid)
+		}
+	}
+	if m.async != nil {
+		changes, deletes := changeSetOf(writes)
+		m.enqueueAsync(vid, changes, deletes)
+	}
+	if m.watchers.count.Load() > 0 {
+		for k, vv := range writes {
+			m.notifyWatchers(KeyChange[K, V]{Key: k, Value: vv.value, Deleted: vv.deleted, VersionID: vid})
+		}
+	}
+}
+
+// txCommitted — часть committed для каждой транзакции версии.
+func (m *MVCCMap[K, V]) txCommitted(tx *Tx[K, V], vid uint64) {
+	tx.commitVID = vid
+	// Проверка Enabled до вызова избавляет горячий путь от упаковки
+	// аргументов в []any, когда Debug выключен (в том числе WithNoLogger).
+	if !m.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
 	m.logger.Debug("committed transaction",
 		"txID", tx.id,
-		"versionID", newVID,
+		"versionID", vid,
 		"writtenKeys", len(tx.writes),
 	)
+}
 
+// runCommitHook вызывает WithCommitValidator и WithCommitHook до установки
+// новой версии. Отказ валидатора отклоняет коммит всегда, и хук тогда
+// не вызывается.
+//
+// Хук получает контекст транзакции: он отменяется, если транзакцию
+// прервали (отмена родителя, deadlock detector), и хук с I/O может
+// прекратить работу досрочно. Ошибка хука отклоняет коммит только
+// с WithCommitHookFailsCommit(true); тогда версия так и не становится
+// видимой, и состояние map не меняется. Иначе ошибка лишь логируется.
+//
+// Хук выполняется в критической секции коммита — долгий хук
+// задерживает всех писателей.
+func (m *MVCCMap[K, V]) runCommitHook(tx *Tx[K, V], versionID uint64) error {
+	if err := m.runCommitValidator(tx); err != nil {
+		return err
+	}
+	return m.callCommitHook(tx, versionID)
+}
+
+// runCommitValidator вызывает WithCommitValidator для изменений tx.
+func (m *MVCCMap[K, V]) runCommitValidator(tx *Tx[K, V]) error {
+	if m.validator == nil {
+		return nil
+	}
+	changes, deletes := tx.changeSet()
+	if err := m.validator(changes, deletes); err != nil {
+		return fmt.Errorf("%w: %w", ErrCommitRejected, err)
+	}
+	return nil
+}
+
+// callCommitHook вызывает WithCommitHook для изменений tx.
+func (m *MVCCMap[K, V]) callCommitHook(tx *Tx[K, V], versionID uint64) error {
+	if m.commitHook == nil {
+		return nil
+	}
+	changes, deletes := tx.changeSet()
+	err := m.commitHook(tx.ctx, versionID, changes, deletes)
+	if err == nil {
+		return nil
+	}
+	if m.cfg.commitHookFailsCommit {
+		return fmt.Errorf("%w: %w", ErrCommitHookFailed, err)
+	}
+	m.logger.Warn("commit hook failed, commit proceeds",
+		"txID", tx.id,
+		"versionID", versionID,
+		"error", err,
+	)
 	return nil
 }
 
@@ -172,10 +1148,100 @@ func (m *MVCCMap[K, V]) unregisterTx(txID uint64) {
 	m.activeTxsMu.Unlock()
 }
 
+// MinActiveSnapshot возвращает ID самой старой версии, которую держит
+// снапшот активной транзакции, — водяной знак, ниже которого GC может
+// собирать версии. false, если активных транзакций нет. Snapshot'ы
+// (Pin, CurrentSnapshot, Tag) не учитываются: они не регистрируются
+// в activeTxs.
+func (m *MVCCMap[K, V]) MinActiveSnapshot() (uint64, bool) {
+	m.activeTxsMu.RLock()
+	defer m.activeTxsMu.RUnlock()
+
+	var minID uint64
+	found := false
+	for _, meta := range m.activeTxs {
+		if id := meta.snapshotID.Load(); !found || id < minID {
+			minID, found = id, true
+		}
+	}
+	return minID, found
+}
+
+// AbortOlderThan прерывает все активные транзакции, начатые более d назад,
+// и возвращает их число — ручной инструмент для инцидентов, когда
+// зависшие транзакции держат старые версии. Транзакции отменяются через
+// контекст с причиной ErrTxTooOld и обнаруживают это при следующей
+// операции, как жертвы deadlock detector'а.
+func (m *MVCCMap[K, V]) AbortOlderThan(d time.Duration) int {
+	cutoff := time.Now().Add(-d)
+
+	m.activeTxsMu.RLock()
+	var old []*txMeta
+	for _, meta := range m.activeTxs {
+		if meta.begunAt.Before(cutoff) {
+			old = append(old, meta)
+		}
+	}
+	m.activeTxsMu.RUnlock()
+
+	for _, meta := range old {
+		age := time.Since(meta.begunAt)
+		m.logger.Warn("aborting old transaction",
+			"txID", meta.id,
+			"label", meta.label,
+			"age", age,
+		)
+		meta.abort(fmt.Errorf("%w: age %v exceeds %v", ErrTxTooOld, age, d))
+	}
+	return len(old)
+}
+
 // VersionCount возвращает количество живых версий.
 // Используется в тестах и метриках для контроля утечек памяти.
+// После Close возвращает число версий, оставшихся после остановки GC:
+// фоновых проходов больше нет, и оно меняется только через RunGCNow.
 func (m *MVCCMap[K, V]) VersionCount() int {
 	m.versionsMu.Lock()
 	defer m.versionsMu.Unlock()
 	return len(m.versions)
 }
+
+// ForEachVersion обходит хранимые версии в порядке создания под versionsMu,
+// передавая в fn ID версии, копию её данных (без tombstone'ов) и refCount.
+// Обход прекращается, если fn возвращает false.
+//
+// Предназначен для построения собственных checkpoint/diff поверх сырых версий.
+// Не для горячего пути: копирует данные каждой версии и на всё время обхода
+// блокирует GC и регистрацию новых версий в коммитах.
+func (m *MVCCMap[K, V]) ForEachVersion(fn func(id uint64, data map[K]V, refCount int64) bool) {
+	m.versionsMu.Lock()
+	defer m.versionsMu.Unlock()
+
+	for _, v := range m.versions {
+		data := make(map[K]V, v.size)
+		for k, vv := range v.data.Iterate {
+			if !vv.deleted {
+				data[k] = m.decodeValue(vv.value)
+			}
+		}
+		if !fn(v.id, data, v.refCount.Load()) {
+			return
+		}
+	}
+}
+
+// normalizeKey приводит ключ к каноническому виду (WithKeyNormalizer).
+func (m *MVCCMap[K, V]) normalizeKey(key K) K {
+	if m.normalize == nil {
+		return key
+	}
+	return m.normalize(key)
+}
+
+// decodeValue применяет decode из WithValueCodec к значению из версии.
+func (m *MVCCMap[K, V]) decodeValue(v V) V {
+	if m.decode == nil {
+		return v
+	}
+	return m.decode(v)
+}
@@ -0,0 +1,352 @@
+package mvcc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestValueCodec_StoredFormDiffersFromReadForm проверяет, что значения
+// хранятся в закодированном виде, а читаются в декодированном.
+func TestValueCodec_StoredFormDiffersFromReadForm(t *testing.T) {
+	ctx := context.Background()
+	xor := func(v int) int { return v ^ 0x5A5A }
+	m := NewMVCCMap[string, int](ctx, WithValueCodec(xor, xor))
+	defer m.Close()
+
+	tx := m.BeginTx(ctx)
+	_ = tx.Put("k", 42)
+	if v, _ := tx.Get("k"); v != 42 {
+		t.Fatalf("own write: Get(k) = %d, want 42 (buffer is not encoded)", v)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	vv, _ := m.current.Load().data.Get("k")
+	stored := vv.value
+	if stored == 42 || stored != xor(42) {
+		t.Errorf("stored form = %d, want encoded %d", stored, xor(42))
+	}
+
+	reader := m.BeginTx(ctx)
+	defer reader.Rollback()
+	if v, ok := reader.Get("k"); !ok || v != 42 {
+		t.Errorf("Get(k) = %d, %v; want decoded 42, true", v, ok)
+	}
+}
+
+// TestValueCodec_TypeMismatchPanics проверяет, что кодек с чужим
+// типом значения отклоняется при создании map.
+func TestValueCodec_TypeMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for a codec of a different value type")
+		}
+	}()
+	id := func(s string) string { return s }
+	m := NewMVCCMap[string, int](context.Background(), WithValueCodec(id, id))
+	m.Close()
+}
+
+// TestBeginTx_CanceledContextDoesNotLeak проверяет, что BeginTx с уже
+// отменённым ctx не регистрирует транзакции и не закрепляет снапшот.
+func TestBeginTx_CanceledContextDoesNotLeak(t *testing.T) {
+	m := NewMVCCMap[string, int](context.Background(), WithManualGC())
+	defer m.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for range 100 {
+		tx := m.BeginTx(ctx)
+		if err := tx.Put("k", 1); !errors.Is(err, ErrTxCanceled) {
+			t.Fatalf("Put on tx from canceled ctx: got %v, want ErrTxCanceled", err)
+		}
+	}
+	if _, err := m.BeginTxContext(ctx); !errors.Is(err, ErrTxCanceled) {
+		t.Errorf("BeginTxContext: got %v, want ErrTxCanceled", err)
+	}
+
+	m.activeTxsMu.RLock()
+	active := len(m.activeTxs)
+	m.activeTxsMu.RUnlock()
+	if active != 0 {
+		t.Errorf("len(activeTxs) = %d, want 0", active)
+	}
+	if refs := m.current.Load().refCount.Load(); refs != 0 {
+		t.Errorf("current version refCount = %d, want 0", refs)
+	}
+}
+
+// TestCommitBump_SharesDataWithoutClone проверяет, что CommitBump без
+// записей создаёт версию с большим ID, разделяющую data с предыдущей.
+func TestCommitBump_SharesDataWithoutClone(t *testing.T) {
+	ctx := context.Background()
+	m := NewMVCCMap[string, int](ctx, WithManualGC())
+	defer m.Close()
+
+	seed := m.BeginTx(ctx)
+	_ = seed.Put("k", 1)
+	if err := seed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	before := m.current.Load()
+
+	tx := m.BeginTx(ctx)
+	_, _ = tx.Get("k")
+	vid, err := tx.CommitBump()
+	if err != nil {
+		t.Fatalf("CommitBump: %v", err)
+	}
+	after := m.current.Load()
+
+	if vid <= before.id || after.id != vid {
+		t.Errorf("CommitBump() = %d, before %d, current %d; want a new higher current version", vid, before.id, after.id)
+	}
+	if reflect.ValueOf(after.data).Pointer() != reflect.ValueOf(before.data).Pointer() {
+		t.Error("bumped version cloned the data map")
+	}
+	if after.size != before.size {
+		t.Errorf("size = %d, want %d", after.size, before.size)
+	}
+}
+
+// TestFairCommitScheduling_CoversServicePaths проверяет, что с
+// WithFairCommitScheduling CompareAndReplace, ApplyCommit и CommitBump
+// ждут очереди коммитов, а не захватывают мьютекс в обход неё.
+func TestFairCommitScheduling_CoversServicePaths(t *testing.T) {
+	ctx := context.Background()
+	paths := map[string]func(m *MVCCMap[string, int]) error{
+		"CompareAndReplace": func(m *MVCCMap[string, int]) error {
+			_, err := m.CompareAndReplace(m.current.Load().id, map[string]int{"k": 1})
+			return err
+		},
+		"ApplyCommit": func(m *MVCCMap[string, int]) error {
+			return m.ApplyCommit(m.current.Load().id+1, map[string]int{"k": 1}, nil)
+		},
+		"CommitBump": func(m *MVCCMap[string, int]) error {
+			_, err := m.BeginTx(ctx).CommitBump()
+			return err
+		},
+	}
+	for name, path := range paths {
+		t.Run(name, func(t *testing.T) {
+			m := NewMVCCMap[string, int](ctx, WithFairCommitScheduling())
+			defer m.Close()
+
+			m.fair.acquire("busy") // очередь занята чужим коммитом
+			done := make(chan error, 1)
+			go func() { done <- path(m) }()
+			select {
+			case err := <-done:
+				t.Fatalf("%s finished while the commit queue was busy: %v", name, err)
+			case <-time.After(20 * time.Millisecond):
+			}
+			m.fair.release()
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestFinalize_ReleasesSnapshotExactlyOnce проверяет, что любые сочетания
+// Commit и Rollback (и повторный finalize) освобождают снапшот один раз.
+func TestFinalize_ReleasesSnapshotExactlyOnce(t *testing.T) {
+	ctx := context.Background()
+	m := NewMVCCMap[string, int](ctx, WithManualGC())
+	defer m.Close()
+
+	finish := map[string]func(tx *Tx[string, int]){
+		"commit then rollback": func(tx *Tx[string, int]) {
+			_ = tx.Commit()
+			tx.Rollback()
+		},
+		"rollback then commit": func(tx *Tx[string, int]) {
+			tx.Rollback()
+			_ = tx.Commit()
+		},
+		"repeated finalize": func(tx *Tx[string, int]) {
+			tx.Rollback()
+			tx.finalize()
+		},
+	}
+	for name, fn := range finish {
+		// Сторонний читатель держит версию, чтобы лишний декремент
+		// был виден как отклонение его счётчика.
+		holder := m.BeginTx(ctx)
+		snap := holder.snapshot
+
+		tx := m.BeginTx(ctx)
+		if tx.snapshot != snap {
+			t.Fatalf("%s: transactions got different snapshots", name)
+		}
+		_, _ = tx.Get("k")
+		fn(tx)
+
+		if refs := snap.refCount.Load(); refs != 1 {
+			t.Errorf("%s: refCount = %d, want 1 (only the holder)", name, refs)
+		}
+		holder.Rollback()
+		if refs := snap.refCount.Load(); refs != 0 {
+			t.Errorf("%s: refCount = %d after all finished, want 0", name, refs)
+		}
+	}
+}
+
+// TestTombstones_RetainedWhileOldReaderActive проверяет, что tombstone
+// удалённого ключа остаётся в новых версиях, пока активен читатель со
+// снапшотом до удаления (он видит ключ и получает конфликт при записи),
+// и удаляется первым коммитом после его завершения.
+func TestTombstones_RetainedWhileOldReaderActive(t *testing.T) {
+	ctx := context.Background()
+	m := NewMVCCMap[string, int](ctx, WithManualGC())
+	defer m.Close()
+
+	commit := func(fn func(tx *Tx[string, int])) {
+		t.Helper()
+		tx := m.BeginTx(ctx)
+		fn(tx)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hasTombstone := func() bool {
+		vv, ok := m.current.Load().data.Get("k")
+		return ok && vv.deleted
+	}
+
+	commit(func(tx *Tx[string, int]) { _ = tx.Put("k", 1) })
+	old := m.BeginTx(ctx)
+	defer old.Rollback()
+	commit(func(tx *Tx[string, int]) { _ = tx.Delete("k") })
+
+	after := m.BeginTx(ctx)
+	if after.Has("k") {
+		t.Error("reader after the delete sees the key")
+	}
+	after.Rollback()
+
+	commit(func(tx *Tx[string, int]) { _ = tx.Put("other", 1) })
+	mid := m.BeginTx(ctx) // снапшот уже содержит tombstone
+	defer mid.Rollback()
+	if !hasTombstone() {
+		t.Fatal("tombstone purged while a reader from before the delete is active")
+	}
+	if v, ok := old.Get("k"); !ok || v != 1 {
+		t.Errorf("old reader: Get(k) = %d, %v; want 1, true", v, ok)
+	}
+	_ = old.Put("k", 2)
+	if err := old.Commit(); !errors.Is(err, ErrConflict) {
+		t.Errorf("old reader writing the deleted key: Commit = %v, want ErrConflict", err)
+	}
+
+	commit(func(tx *Tx[string, int]) { _ = tx.Put("other", 2) })
+	if hasTombstone() {
+		t.Error("tombstone retained after the old reader closed")
+	}
+	if n := m.Stats().TombstonesPurged; n != 1 {
+		t.Errorf("TombstonesPurged = %d, want 1", n)
+	}
+
+	// Транзакция, чей снапшот содержал tombstone, не конфликтует из-за
+	// его удаления.
+	_ = mid.Put("k", 3)
+	if err := mid.Commit(); err != nil {
+		t.Errorf("writer from after the delete: Commit = %v, want nil", err)
+	}
+}
+
+// TestCloneData_CostIndependentOfValueSize проверяет, что клон версии
+// копирует заголовки слайсов, а не их содержимое: клон map с крупными
+// значениями аллоцирует столько же, сколько клон с крошечными.
+func TestCloneData_CostIndependentOfValueSize(t *testing.T) {
+	ctx := context.Background()
+	cloneBytes := func(valueSize int) uint64 {
+		m := NewMVCCMap[int, []byte](ctx, WithManualGC(), WithManualDeadlockDetection())
+		defer m.Close()
+		tx := m.BeginTx(ctx)
+		for k := range 200 {
+			_ = tx.Put(k, make([]byte, valueSize))
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		current := m.current.Load()
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		data := m.cloneData(current)
+		runtime.ReadMemStats(&after)
+		runtime.KeepAlive(data)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+
+	small, large := cloneBytes(1), cloneBytes(64<<10)
+	if large > 2*small {
+		t.Errorf("clone of 64KiB values allocated %d bytes, of 1-byte values %d: want comparable", large, small)
+	}
+}
+
+// TestIDWrapGuard_AlertsNearMaxAndPanicsOnWrap проверяет, что у границы
+// math.MaxUint64 коммиты продолжают работать, тревога поднимается один
+// раз при входе в запас, а переход счётчика версий через ноль — паника.
+func TestIDWrapGuard_AlertsNearMaxAndPanicsOnWrap(t *testing.T) {
+	ctx := context.Background()
+	var alerts []uint64
+	m := NewMVCCMap[string, int](ctx,
+		WithManualGC(),
+		WithManualDeadlockDetection(),
+		WithIDWrapGuard(2, func(counter string, id uint64) {
+			if counter == "version" {
+				alerts = append(alerts, id)
+			}
+		}),
+	)
+	defer m.Close()
+	m.nextVersionID.Store(math.MaxUint64 - 5)
+
+	commit := func(v int) error {
+		tx := m.BeginTx(ctx)
+		defer tx.Rollback()
+		_ = tx.Put("k", v)
+		return tx.Commit()
+	}
+	for i := range 3 { // версии MaxUint64-4 … MaxUint64-2
+		if err := commit(i); err != nil {
+			t.Fatalf("commit %d near the boundary: %v", i, err)
+		}
+	}
+
+	// Конфликты у границы по-прежнему обнаруживаются.
+	stale := m.BeginTx(ctx)
+	defer stale.Rollback()
+	if err := commit(10); err != nil { // MaxUint64-1
+		t.Fatal(err)
+	}
+	_ = stale.Put("k", 11)
+	if err := stale.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("stale Commit near the boundary = %v, want ErrConflict", err)
+	}
+	if err := commit(12); err != nil { // MaxUint64
+		t.Fatal(err)
+	}
+	if id := m.current.Load().id; id != math.MaxUint64 {
+		t.Fatalf("current version = %d, want MaxUint64", id)
+	}
+	if len(alerts) != 1 || alerts[0] != math.MaxUint64-2 {
+		t.Errorf("alerts = %v, want a single alert at MaxUint64-2", alerts)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("version counter wrapped without a panic")
+		}
+	}()
+	_ = commit(13)
+}
@@ -1,20 +1,28 @@
 package mvcc_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"mvcc-map/mvcc"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
-func newTestMap(t *testing.T) (*mvcc.MVCCMap[string, int], context.CancelFunc) {
+func newTestMap(t *testing.T, opts ...mvcc.Option) (*mvcc.MVCCMap[string, int], context.CancelFunc) {
 	t.Helper()
 	ctx, cancel := context.WithCancel(context.Background())
 	m := mvcc.NewMVCCMap[string, int](ctx,
-		mvcc.WithGCInterval(50*time.Millisecond),
+		append([]mvcc.Option{mvcc.WithGCInterval(50 * time.Millisecond)}, opts...)...,
 	)
 	t.Cleanup(func() {
 		cancel()
@@ -26,142 +34,152 @@ func newTestMap(t *testing.T) (*mvcc.MVCCMap[string, int], context.CancelFunc) {
 // TestSnapshotIsolation_NoReadSkew проверяет, что транзакция не видит
 // изменений, сделанных другими транзакциями после её начала.
 func TestSnapshotIsolation_NoReadSkew(t *testing.T) {
-	m, _ := newTestMap(t)
-	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx := context.Background()
 
-	// Устанавливаем начальное значение.
-	setup := m.BeginTx(ctx)
-	_ = setup.Put("balance", 100)
-	if err := setup.Commit(); err != nil {
-		t.Fatal(err)
-	}
+		// Устанавливаем начальное значение.
+		setup := m.BeginTx(ctx)
+		_ = setup.Put("balance", 100)
+		if err := setup.Commit(); err != nil {
+			t.Fatal(err)
+		}
 
-	// Начинаем "длинную" читающую транзакцию.
-	reader := m.BeginTx(ctx)
+		// Начинаем "длинную" читающую транзакцию.
+		reader := m.BeginTx(ctx)
 
-	// Другая транзакция изменяет значение.
-	writer := m.BeginTx(ctx)
-	_ = writer.Put("balance", 200)
-	if err := writer.Commit(); err != nil {
-		t.Fatal(err)
-	}
+		// Другая транзакция изменяет значение.
+		writer := m.BeginTx(ctx)
+		_ = writer.Put("balance", 200)
+		if err := writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
 
-	// Читатель должен видеть старое значение (100), несмотря на коммит writer.
-	val, ok := reader.Get("balance")
-	if !ok {
-		t.Fatal("key not found")
-	}
-	if val != 100 {
-		t.Errorf("read skew detected: expected 100, got %d", val)
-	}
-	reader.Rollback()
+		// Читатель должен видеть старое значение (100), несмотря на коммит writer.
+		val, ok := reader.Get("balance")
+		if !ok {
+			t.Fatal("key not found")
+		}
+		if val != 100 {
+			t.Errorf("read skew detected: expected 100, got %d", val)
+		}
+		reader.Rollback()
+	})
 }
 
 // TestWriteWriteConflict проверяет, что две транзакции, изменяющие
 // один ключ, порождают конфликт.
 func TestWriteWriteConflict(t *testing.T) {
-	m, _ := newTestMap(t)
-	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx := context.Background()
 
-	tx1 := m.BeginTx(ctx)
-	tx2 := m.BeginTx(ctx)
+		tx1 := m.BeginTx(ctx)
+		tx2 := m.BeginTx(ctx)
 
-	_ = tx1.Put("counter", 1)
-	_ = tx2.Put("counter", 2)
+		_ = tx1.Put("counter", 1)
+		_ = tx2.Put("counter", 2)
 
-	// tx1 коммитится первым — успех.
-	if err := tx1.Commit(); err != nil {
-		t.Fatalf("tx1 commit failed unexpectedly: %v", err)
-	}
+		// tx1 коммитится первым — успех.
+		if err := tx1.Commit(); err != nil {
+			t.Fatalf("tx1 commit failed unexpectedly: %v", err)
+		}
 
-	// tx2 должна упасть с ErrConflict.
-	err := tx2.Commit()
-	if err == nil {
-		t.Fatal("expected conflict error, got nil")
-	}
-	if !errors.Is(err, mvcc.ErrConflict) {
-		t.Errorf("expected ErrConflict, got: %v", err)
-	}
+		// tx2 должна упасть с ErrConflict.
+		err := tx2.Commit()
+		if err == nil {
+			t.Fatal("expected conflict error, got nil")
+		}
+		if !errors.Is(err, mvcc.ErrConflict) {
+			t.Errorf("expected ErrConflict, got: %v", err)
+		}
+	})
 }
 
 // TestReadersDoNotBlockWriters проверяет отсутствие блокировок
 // между читателями и писателями.
 func TestReadersDoNotBlockWriters(t *testing.T) {
-	m, _ := newTestMap(t)
-	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx := context.Background()
 
-	// Запускаем 100 долгоживущих читателей.
-	var wg sync.WaitGroup
-	for range 100 {
-		wg.Add(1)
+		// Запускаем 100 долгоживущих читателей.
+		var wg sync.WaitGroup
+		for range 100 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tx := m.BeginTx(ctx)
+				defer tx.Rollback()
+				_, _ = tx.Get("key")
+				time.Sleep(50 * time.Millisecond) // имитируем долгую транзакцию
+			}()
+		}
+
+		// Писатель должен завершиться быстро, не ожидая читателей.
+		done := make(chan struct{})
 		go func() {
-			defer wg.Done()
+			defer close(done)
 			tx := m.BeginTx(ctx)
-			defer tx.Rollback()
-			_, _ = tx.Get("key")
-			time.Sleep(50 * time.Millisecond) // имитируем долгую транзакцию
+			_ = tx.Put("key", 42)
+			if err := tx.Commit(); err != nil {
+				t.Errorf("writer failed: %v", err)
+			}
 		}()
-	}
 
-	// Писатель должен завершиться быстро, не ожидая читателей.
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		tx := m.BeginTx(ctx)
-		_ = tx.Put("key", 42)
-		if err := tx.Commit(); err != nil {
-			t.Errorf("writer failed: %v", err)
+		select {
+		case <-done:
+			// OK: писатель не заблокировался
+		case <-time.After(10 * time.Millisecond):
+			t.Error("writer was blocked by readers")
 		}
-	}()
-
-	select {
-	case <-done:
-		// OK: писатель не заблокировался
-	case <-time.After(10 * time.Millisecond):
-		t.Error("writer was blocked by readers")
-	}
 
-	wg.Wait()
+		wg.Wait()
+	})
 }
 
 // TestNoMemoryLeakWithLongTransactions проверяет, что долгие транзакции
 // не приводят к бесконтрольному росту числа версий.
 func TestNoMemoryLeakWithLongTransactions(t *testing.T) {
-	m, _ := newTestMap(t)
-	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx := context.Background()
 
-	// Запускаем 1000 коммитов.
-	for i := range 1000 {
-		tx := m.BeginTx(ctx)
-		_ = tx.Put("key", i)
-		if err := tx.Commit(); err != nil {
-			t.Fatal(err)
+		// Запускаем 1000 коммитов.
+		for i := range 1000 {
+			tx := m.BeginTx(ctx)
+			_ = tx.Put("key", i)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
 		}
-	}
 
-	// Ждём GC.
-	time.Sleep(200 * time.Millisecond)
+		// Ждём GC.
+		time.Sleep(200 * time.Millisecond)
 
-	// Проверяем, что количество версий не растёт линейно.
-	// В идеале должна остаться только текущая версия.
-	count := m.VersionCount()
-	if count > 5 { // небольшой буфер на race window
-		t.Errorf("version leak: %d versions still alive", count)
-	}
+		// Проверяем, что количество версий не растёт линейно.
+		// В идеале должна остаться только текущая версия.
+		count := m.VersionCount()
+		if count > 5 { // небольшой буфер на race window
+			t.Errorf("version leak: %d versions still alive", count)
+		}
+	})
 }
 
 // TestReadYourOwnWrites проверяет, что транзакция видит собственные изменения.
 func TestReadYourOwnWrites(t *testing.T) {
-	m, _ := newTestMap(t)
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
 
-	tx := m.BeginTx(context.Background())
-	defer tx.Rollback()
+		tx := m.BeginTx(context.Background())
+		defer tx.Rollback()
 
-	_ = tx.Put("x", 42)
-	val, ok := tx.Get("x")
-	if !ok || val != 42 {
-		t.Errorf("expected read-your-own-writes: got %v, %v", val, ok)
-	}
+		_ = tx.Put("x", 42)
+		val, ok := tx.Get("x")
+		if !ok || val != 42 {
+			t.Errorf("expected read-your-own-writes: got %v, %v", val, ok)
+		}
+	})
 }
 
 // BenchmarkConcurrentReadWrite измеряет throughput при смешанной нагрузке.
@@ -191,3 +209,2808 @@ func BenchmarkConcurrentReadWrite(b *testing.B) {
 		}
 	})
 }
+
+// TestCommit_ReadOnlyCreatesNoVersion проверяет, что Commit транзакции
+// без записей не создаёт новую версию.
+func TestCommit_ReadOnlyCreatesNoVersion(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithGCInterval(time.Hour))...)
+		defer m.Close()
+
+		setup := m.BeginTx(ctx)
+		_ = setup.Put("k", 1)
+		if err := setup.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		before := m.VersionCount()
+
+		reader := m.BeginTx(ctx)
+		if v, ok := reader.Get("k"); !ok || v != 1 {
+			t.Fatalf("Get(k) = %d, %v; want 1, true", v, ok)
+		}
+		if err := reader.Commit(); err != nil {
+			t.Fatalf("read-only commit failed: %v", err)
+		}
+
+		if after := m.VersionCount(); after != before {
+			t.Errorf("read-only commit created a version: count %d → %d", before, after)
+		}
+	})
+}
+
+// TestWriteSetKeys проверяет, что WriteSetKeys возвращает ключи
+// буферизованных записей, а изменение среза не влияет на транзакцию.
+func TestWriteSetKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		tx := m.BeginTx(context.Background())
+		defer tx.Rollback()
+
+		_ = tx.Put("a", 1)
+		_ = tx.Put("b", 2)
+		_ = tx.Put("a", 3)
+
+		keys := tx.WriteSetKeys()
+		slices.Sort(keys)
+		if !slices.Equal(keys, []string{"a", "b"}) {
+			t.Fatalf("WriteSetKeys() = %v, want [a b]", keys)
+		}
+
+		keys[0] = "mutated"
+		again := tx.WriteSetKeys()
+		slices.Sort(again)
+		if !slices.Equal(again, []string{"a", "b"}) {
+			t.Errorf("mutating the returned slice affected the tx: %v", again)
+		}
+	})
+}
+
+// TestDelete проверяет, что удалённый ключ не виден ни самой транзакции,
+// ни последующим, а снапшоты до удаления его по-прежнему видят.
+func TestDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx := context.Background()
+
+		setup := m.BeginTx(ctx)
+		_ = setup.Put("k", 1)
+		if err := setup.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		old := m.BeginTx(ctx)
+		defer old.Rollback()
+
+		del := m.BeginTx(ctx)
+		_ = del.Delete("k")
+		if _, ok := del.Get("k"); ok {
+			t.Error("transaction sees its own deleted key")
+		}
+		if err := del.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		after := m.BeginTx(ctx)
+		defer after.Rollback()
+		if _, ok := after.Get("k"); ok {
+			t.Error("deleted key is visible after commit")
+		}
+		if v, ok := old.Get("k"); !ok || v != 1 {
+			t.Errorf("old snapshot: Get(k) = %d, %v; want 1, true", v, ok)
+		}
+	})
+}
+
+// TestStats_CommitLockHeldGrowsWithWriteSet проверяет, что время удержания
+// мьютекса коммита растёт для больших write set'ов. Хук коммита работает
+// под мьютексом и тратит время пропорционально числу ключей, поэтому
+// нижняя граница удержания известна заранее и не зависит от скорости машины.
+func TestStats_CommitLockHeldGrowsWithWriteSet(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		const perKey = time.Microsecond
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithManualGC(),
+			mvcc.WithCommitHook(func(_ context.Context, _ uint64, changes map[string]int, _ []string) error {
+				time.Sleep(time.Duration(len(changes)) * perKey)
+				return nil
+			}),
+		)...)
+		defer m.Close()
+
+		for i := range 10 {
+			commitPut(t, m, fmt.Sprintf("small-%d", i), i)
+		}
+		small := m.Stats()
+
+		const largeKeys = 5000
+		tx := m.BeginTx(ctx)
+		for i := range largeKeys {
+			_ = tx.Put(fmt.Sprintf("large-%d", i), i)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		large := m.Stats()
+
+		if large.Commits != small.Commits+1 {
+			t.Errorf("Commits = %d, want %d", large.Commits, small.Commits+1)
+		}
+		if floor := int64(largeKeys * perKey); large.MaxCommitLockHeldNanos < floor {
+			t.Errorf("max lock hold = %dns, want at least the hook's %dns", large.MaxCommitLockHeldNanos, floor)
+		}
+		if large.MaxCommitLockHeldNanos <= small.MaxCommitLockHeldNanos {
+			t.Errorf("max lock hold did not grow: small=%dns large=%dns",
+				small.MaxCommitLockHeldNanos, large.MaxCommitLockHeldNanos)
+		}
+		if large.AvgCommitLockHeldNanos <= small.AvgCommitLockHeldNanos {
+			t.Errorf("avg lock hold did not grow: small=%dns large=%dns",
+				small.AvgCommitLockHeldNanos, large.AvgCommitLockHeldNanos)
+		}
+	})
+}
+
+// TestCommitTimestamps_ConflictWindow проверяет, что с WithCommitTimestamps
+// конфликтом считается только запись, закоммиченная позже начала транзакции.
+func TestCommitTimestamps_ConflictWindow(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		var now atomic.Uint64
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithCommitTimestamps(now.Load))...)
+		defer m.Close()
+
+		now.Store(10)
+		tx := m.BeginTx(ctx) // beginTS = 10
+
+		// Конкурентная запись с меткой 5 ≤ 10 — "старше нашего чтения".
+		now.Store(5)
+		older := m.BeginTx(ctx)
+		_ = older.Put("k", 1)
+		if err := older.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = tx.Put("k", 2)
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("write older than begin time must not conflict: %v", err)
+		}
+
+		now.Store(10)
+		late := m.BeginTx(ctx) // beginTS = 10
+
+		// Конкурентная запись с меткой 20 > 10 — настоящий конфликт.
+		now.Store(20)
+		newer := m.BeginTx(ctx)
+		_ = newer.Put("k", 3)
+		if err := newer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = late.Put("k", 4)
+		if err := late.Commit(); !errors.Is(err, mvcc.ErrConflict) {
+			t.Fatalf("expected ErrConflict for a write newer than begin time, got %v", err)
+		}
+	})
+}
+
+// TestGetOr проверяет GetOr для присутствующего, отсутствующего
+// и удалённого ключа, включая собственные изменения транзакции.
+func TestGetOr(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx := context.Background()
+
+		setup := m.BeginTx(ctx)
+		_ = setup.Put("present", 1)
+		_ = setup.Put("deleted", 2)
+		_ = setup.Put("deleted-in-tx", 3)
+		if err := setup.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		del := m.BeginTx(ctx)
+		_ = del.Delete("deleted")
+		if err := del.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		tx := m.BeginTx(ctx)
+		defer tx.Rollback()
+		_ = tx.Delete("deleted-in-tx")
+		_ = tx.Put("own", 4)
+
+		cases := []struct {
+			key  string
+			want int
+		}{
+			{"present", 1},
+			{"absent", -1},
+			{"deleted", -1},
+			{"deleted-in-tx", -1},
+			{"own", 4},
+		}
+		for _, c := range cases {
+			if got := tx.GetOr(c.key, -1); got != c.want {
+				t.Errorf("GetOr(%q) = %d, want %d", c.key, got, c.want)
+			}
+		}
+	})
+}
+
+// TestGetAllInto_ReusesDestination проверяет переиспользование dst
+// между двумя опросами и обработку отсутствующих ключей по флагу.
+func TestGetAllInto_ReusesDestination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx := context.Background()
+
+		setup := m.BeginTx(ctx)
+		_ = setup.Put("a", 1)
+		_ = setup.Put("b", 2)
+		if err := setup.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := make(map[string]int)
+		keys := []string{"a", "b", "c"}
+
+		first := m.BeginTx(ctx)
+		first.GetAllInto(keys, dst, true)
+		first.Rollback()
+		if len(dst) != 2 || dst["a"] != 1 || dst["b"] != 2 {
+			t.Fatalf("first poll: dst = %v", dst)
+		}
+
+		del := m.BeginTx(ctx)
+		_ = del.Delete("b")
+		_ = del.Put("a", 10)
+		if err := del.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		keep := maps.Clone(dst)
+		second := m.BeginTx(ctx)
+		second.GetAllInto(keys, keep, false)
+		second.GetAllInto(keys, dst, true)
+		second.Rollback()
+
+		if len(dst) != 1 || dst["a"] != 10 {
+			t.Errorf("deleteAbsent=true: dst = %v, want map[a:10]", dst)
+		}
+		if len(keep) != 2 || keep["a"] != 10 || keep["b"] != 2 {
+			t.Errorf("deleteAbsent=false: dst = %v, want stale b left in place", keep)
+		}
+	})
+}
+
+// TestSerializable_FirstCommitterWins проверяет, что при Serializable
+// из двух транзакций с пересекающимися read/write set'ами (write skew)
+// коммитится ровно первая, а вторая получает ErrReadValidation.
+func TestSerializable_FirstCommitterWins(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithIsolationLevel(mvcc.Serializable))...)
+		defer m.Close()
+
+		setup := m.BeginTx(ctx)
+		_ = setup.Put("x", 1)
+		_ = setup.Put("y", 1)
+		if err := setup.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Классический write skew: каждая читает оба ключа и пишет "чужой".
+		tx1 := m.BeginTx(ctx)
+		tx2 := m.BeginTx(ctx)
+
+		x1, _ := tx1.Get("x")
+		y1, _ := tx1.Get("y")
+		_ = tx1.Put("y", x1+y1)
+
+		x2, _ := tx2.Get("x")
+		y2, _ := tx2.Get("y")
+		_ = tx2.Put("x", x2+y2)
+
+		if err := tx1.Commit(); err != nil {
+			t.Fatalf("first committer must win: %v", err)
+		}
+		err := tx2.Commit()
+		if !errors.Is(err, mvcc.ErrReadValidation) {
+			t.Fatalf("expected ErrReadValidation, got %v", err)
+		}
+		if !errors.Is(err, mvcc.ErrConflict) {
+			t.Errorf("ErrReadValidation must also match ErrConflict")
+		}
+
+		check := m.BeginTx(ctx)
+		defer check.Rollback()
+		if x, _ := check.Get("x"); x != 1 {
+			t.Errorf("x = %d, want 1 (second committer's write must not apply)", x)
+		}
+		if y, _ := check.Get("y"); y != 2 {
+			t.Errorf("y = %d, want 2 (first committer's write)", y)
+		}
+	})
+}
+
+// TestInlineGC_BoundsVersionsWithoutBackgroundGC проверяет, что при
+// отключённой фоновой горутине inline GC держит число версий ограниченным.
+func TestInlineGC_BoundsVersionsWithoutBackgroundGC(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		const everyN = 5
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithGCInterval(0),
+			mvcc.WithInlineGC(everyN),
+		)...)
+		defer m.Close()
+
+		for i := range 100 {
+			tx := m.BeginTx(ctx)
+			_ = tx.Put("k", i)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			// Снапшот коммитящей транзакции ещё удерживается во время inline-прохода,
+			// поэтому между проходами живёт не более everyN+1 версий.
+			if n := m.VersionCount(); n > everyN+1 {
+				t.Fatalf("after commit %d: VersionCount = %d, want <= %d", i, n, everyN+1)
+			}
+		}
+	})
+}
+
+// TestClose_RejectsOperations проверяет, что после Close новые транзакции
+// и Commit уже начатых отклоняются с ErrClosed, не создавая версий.
+func TestClose_RejectsOperations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend)...)
+
+		inFlight := m.BeginTx(ctx)
+		_ = inFlight.Put("k", 1)
+
+		m.Close()
+		m.Close() // идемпотентно
+
+		versions := m.VersionCount()
+
+		if err := inFlight.Commit(); !errors.Is(err, mvcc.ErrClosed) {
+			t.Errorf("in-flight Commit after Close: got %v, want ErrClosed", err)
+		}
+
+		if tx, err := m.BeginTxContext(ctx); !errors.Is(err, mvcc.ErrClosed) || tx != nil {
+			t.Errorf("BeginTxContext after Close: got (%v, %v), want (nil, ErrClosed)", tx, err)
+		}
+
+		tx := m.BeginTx(ctx)
+		if err := tx.Put("k", 2); !errors.Is(err, mvcc.ErrClosed) {
+			t.Errorf("Put on tx begun after Close: got %v, want ErrClosed", err)
+		}
+		if err := tx.Commit(); !errors.Is(err, mvcc.ErrClosed) {
+			t.Errorf("Commit on tx begun after Close: got %v, want ErrClosed", err)
+		}
+		tx.Rollback()
+
+		if n := m.VersionCount(); n != versions {
+			t.Errorf("VersionCount changed after Close: %d → %d", versions, n)
+		}
+	})
+}
+
+// TestForEachVersion проверяет, что ForEachVersion обходит все хранимые
+// версии с корректными ID, данными и refCount.
+func TestForEachVersion(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithGCInterval(time.Hour))...)
+		defer m.Close()
+
+		for i := 1; i <= 3; i++ {
+			tx := m.BeginTx(ctx)
+			_ = tx.Put(fmt.Sprintf("k%d", i), i)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if i == 1 {
+				reader := m.BeginTx(ctx) // держит версию 1
+				defer reader.Rollback()
+			}
+		}
+
+		var ids []uint64
+		m.ForEachVersion(func(id uint64, data map[string]int, refCount int64) bool {
+			ids = append(ids, id)
+			if len(data) != int(id) {
+				t.Errorf("version %d: %d keys, want %d", id, len(data), id)
+			}
+			wantRef := int64(0)
+			if id == 1 {
+				wantRef = 1
+			}
+			if refCount != wantRef {
+				t.Errorf("version %d: refCount = %d, want %d", id, refCount, wantRef)
+			}
+			data["mutated"] = 0 // копия: не должно повлиять на версию
+			return true
+		})
+		if !slices.Equal(ids, []uint64{0, 1, 2, 3}) {
+			t.Fatalf("visited versions %v, want [0 1 2 3]", ids)
+		}
+
+		check := m.BeginTx(ctx)
+		defer check.Rollback()
+		if _, ok := check.Get("mutated"); ok {
+			t.Error("mutating the data passed to fn changed the version")
+		}
+
+		visited := 0
+		m.ForEachVersion(func(uint64, map[string]int, int64) bool {
+			visited++
+			return false
+		})
+		if visited != 1 {
+			t.Errorf("returning false visited %d versions, want 1", visited)
+		}
+	})
+}
+
+// TestEagerConflictCheck проверяет, что Put с EagerConflictCheck сразу
+// возвращает ErrConflict после конкурентного коммита того же ключа.
+func TestEagerConflictCheck(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx := context.Background()
+
+		tx := m.BeginTxWith(ctx, mvcc.TxOptions{EagerConflictCheck: true})
+		defer tx.Rollback()
+
+		if err := tx.Put("other", 1); err != nil {
+			t.Fatalf("Put on an unchanged key: %v", err)
+		}
+
+		writer := m.BeginTx(ctx)
+		_ = writer.Put("k", 1)
+		if err := writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tx.Put("k", 2); !errors.Is(err, mvcc.ErrConflict) {
+			t.Fatalf("eager Put: got %v, want ErrConflict", err)
+		}
+		if err := tx.Put("other", 2); err != nil {
+			t.Errorf("transaction must stay usable after an eager conflict: %v", err)
+		}
+	})
+}
+
+// TestMaxConcurrentTx_BlocksUntilSlotFreed проверяет, что (n+1)-я
+// транзакция ждёт завершения одной из активных.
+func TestMaxConcurrentTx_BlocksUntilSlotFreed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithMaxConcurrentTx(2))...)
+		defer m.Close()
+
+		tx1 := m.BeginTx(ctx)
+		tx2 := m.BeginTx(ctx)
+		defer tx2.Rollback()
+
+		started := make(chan *mvcc.Tx[string, int])
+		go func() { started <- m.BeginTx(ctx) }()
+
+		select {
+		case tx := <-started:
+			tx.Rollback()
+			t.Fatal("third BeginTx did not block at the limit")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		_ = tx1.Put("k", 1)
+		if err := tx1.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case tx := <-started:
+			tx.Rollback()
+		case <-time.After(time.Second):
+			t.Fatal("third BeginTx stayed blocked after a slot was freed")
+		}
+
+		// При занятых слотах ожидание прерывается отменой контекста.
+		tx3 := m.BeginTx(ctx)
+		defer tx3.Rollback()
+		short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		if _, err := m.BeginTxContext(short); !errors.Is(err, mvcc.ErrTxCanceled) {
+			t.Errorf("BeginTxContext at the limit with expiring ctx: got %v, want ErrTxCanceled", err)
+		}
+	})
+}
+
+// TestCommitHook_FailureAbortsCommit проверяет, что с
+// WithCommitHookFailsCommit ошибка хука отклоняет коммит и оставляет
+// состояние map неизменным, а хук получает контекст транзакции.
+func TestCommitHook_FailureAbortsCommit(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		errQuota := errors.New("quota exceeded")
+
+		var hookCtx context.Context
+		var hookVersion uint64
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithGCInterval(time.Hour),
+			mvcc.WithCommitHookFailsCommit(true),
+			mvcc.WithCommitHook(func(ctx context.Context, versionID uint64, changes map[string]int, deletes []string) error {
+				hookCtx, hookVersion = ctx, versionID
+				if changes["k"] > 100 {
+					return errQuota
+				}
+				return nil
+			}),
+		)...)
+		defer m.Close()
+
+		ok := m.BeginTx(ctx)
+		_ = ok.Put("k", 1)
+		if err := ok.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		versions := m.VersionCount()
+
+		bad := m.BeginTx(ctx)
+		_ = bad.Put("k", 1000)
+		err := bad.Commit()
+		if !errors.Is(err, mvcc.ErrCommitHookFailed) || !errors.Is(err, errQuota) {
+			t.Fatalf("Commit: got %v, want ErrCommitHookFailed wrapping the hook error", err)
+		}
+		if hookCtx.Err() == nil {
+			t.Error("hook context must be canceled once the transaction is finalized")
+		}
+
+		if n := m.VersionCount(); n != versions {
+			t.Errorf("rejected commit changed VersionCount: %d → %d", versions, n)
+		}
+		check := m.BeginTx(ctx)
+		defer check.Rollback()
+		if v, _ := check.Get("k"); v != 1 {
+			t.Errorf("k = %d after rejected commit, want 1", v)
+		}
+
+		// Следующий успешный коммит получает ID, который предлагался отклонённому.
+		rejectedVersion := hookVersion
+		next := m.BeginTx(ctx)
+		_ = next.Put("k", 2)
+		if err := next.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if hookVersion != rejectedVersion {
+			t.Errorf("version IDs have a gap: rejected %d, next %d", rejectedVersion, hookVersion)
+		}
+	})
+}
+
+// TestCommitValidator_EnforcesQuota проверяет, что валидатор отклоняет
+// коммит, записывающий больше ключей, чем позволяет квота, с
+// ErrCommitRejected, и пропускает коммит в пределах квоты.
+func TestCommitValidator_EnforcesQuota(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		errQuota := errors.New("quota exceeded")
+		const quota = 2
+
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithCommitValidator(func(changes map[string]int, deletes []string) error {
+				if len(changes) > quota {
+					return errQuota
+				}
+				return nil
+			}),
+		)...)
+		defer m.Close()
+
+		ok := m.BeginTx(ctx)
+		_ = ok.Put("a", 1)
+		_ = ok.Put("b", 2)
+		if err := ok.Commit(); err != nil {
+			t.Fatalf("commit within quota: %v", err)
+		}
+
+		bad := m.BeginTx(ctx)
+		_ = bad.Put("c", 3)
+		_ = bad.Put("d", 4)
+		_ = bad.Put("e", 5)
+		err := bad.Commit()
+		if !errors.Is(err, mvcc.ErrCommitRejected) || !errors.Is(err, errQuota) {
+			t.Fatalf("Commit: got %v, want ErrCommitRejected wrapping the validator error", err)
+		}
+		check := m.BeginTx(ctx)
+		defer check.Rollback()
+		if _, found := check.Get("c"); found {
+			t.Error("rejected commit's write is visible")
+		}
+	})
+}
+
+// TestCommitHook_ErrorLoggedByDefault проверяет, что без
+// WithCommitHookFailsCommit ошибка хука не отклоняет коммит.
+func TestCommitHook_ErrorLoggedByDefault(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithLogger(slog.New(slog.DiscardHandler)),
+			mvcc.WithCommitHook(func(context.Context, uint64, map[string]int, []string) error {
+				return errors.New("sink unavailable")
+			}),
+		)...)
+		defer m.Close()
+
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("k", 1)
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("hook error must not fail the commit by default: %v", err)
+		}
+	})
+}
+
+// TestSwap проверяет, что Swap возвращает предыдущее значение
+// при перезаписи и existed=false для нового ключа.
+func TestSwap(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx := context.Background()
+
+		setup := m.BeginTx(ctx)
+		_ = setup.Put("k", 1)
+		if err := setup.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		tx := m.BeginTx(ctx)
+		old, existed, err := tx.Swap("k", 2)
+		if err != nil || !existed || old != 1 {
+			t.Errorf("Swap(existing) = %d, %v, %v; want 1, true, nil", old, existed, err)
+		}
+		old, existed, err = tx.Swap("k", 3)
+		if err != nil || !existed || old != 2 {
+			t.Errorf("second Swap = %d, %v, %v; want own write 2, true, nil", old, existed, err)
+		}
+		old, existed, err = tx.Swap("new", 10)
+		if err != nil || existed || old != 0 {
+			t.Errorf("Swap(new) = %d, %v, %v; want 0, false, nil", old, existed, err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		check := m.BeginTx(ctx)
+		defer check.Rollback()
+		if v, _ := check.Get("k"); v != 3 {
+			t.Errorf("k = %d, want 3", v)
+		}
+		if v, _ := check.Get("new"); v != 10 {
+			t.Errorf("new = %d, want 10", v)
+		}
+	})
+}
+
+// TestCommittable проверяет, что транзакция с отменённым контекстом
+// и завершённая транзакция сообщают причину, по которой их не закоммитить.
+func TestCommittable(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		tx := m.BeginTx(ctx)
+		if ok, err := tx.Committable(); !ok || err != nil {
+			t.Fatalf("fresh tx: Committable() = %v, %v; want true, nil", ok, err)
+		}
+
+		cancel()
+		if ok, err := tx.Committable(); ok || !errors.Is(err, mvcc.ErrTxCanceled) {
+			t.Errorf("canceled tx: Committable() = %v, %v; want false, ErrTxCanceled", ok, err)
+		}
+
+		tx.Rollback()
+		if ok, err := tx.Committable(); ok || !errors.Is(err, mvcc.ErrTxDone) {
+			t.Errorf("rolled back tx: Committable() = %v, %v; want false, ErrTxDone", ok, err)
+		}
+	})
+}
+
+// TestGetStrict проверяет, что GetStrict возвращает ErrKeyNotFound
+// для отсутствующего ключа и значение для присутствующего, а строгий
+// режим сообщает о Get отсутствующего ключа — без самого ключа в логе —
+// и молчит про GetOr, GetAllInto и GetCtx, которые обрабатывают отсутствие.
+func TestGetStrict(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		var logs strings.Builder
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithStrictReads(true),
+			mvcc.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		)...)
+		defer m.Close()
+
+		setup := m.BeginTx(ctx)
+		_ = setup.Put("present", 7)
+		if err := setup.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		tx := m.BeginTx(ctx)
+		defer tx.Rollback()
+
+		if v, err := tx.GetStrict("present"); err != nil || v != 7 {
+			t.Errorf("GetStrict(present) = %d, %v; want 7, nil", v, err)
+		}
+		if _, err := tx.GetStrict("absent"); !errors.Is(err, mvcc.ErrKeyNotFound) {
+			t.Errorf("GetStrict(absent): got %v, want ErrKeyNotFound", err)
+		}
+
+		_ = tx.GetOr("absent-or", 0)
+		tx.GetAllInto([]string{"absent-into"}, map[string]int{}, true)
+		_, _, _ = tx.GetCtx(ctx, "absent-ctx")
+		if logs.Len() != 0 {
+			t.Errorf("strict mode reported a read that handles absence, logs: %q", logs.String())
+		}
+
+		_, _ = tx.Get("secret-key")
+		if !strings.Contains(logs.String(), "GetStrict") {
+			t.Errorf("strict mode did not report Get of an absent key, logs: %q", logs.String())
+		}
+		if strings.Contains(logs.String(), "secret-key") {
+			t.Errorf("strict mode logged the raw key, logs: %q", logs.String())
+		}
+	})
+}
+
+// TestTxReset проверяет, что Reset отклоняется для активной транзакции,
+// а после завершения даёт свежий снапшот и пустые буферы.
+func TestTxReset(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		ctx := context.Background()
+
+		tx := m.BeginTx(ctx)
+		if err := tx.Reset(ctx); !errors.Is(err, mvcc.ErrTxActive) {
+			t.Fatalf("Reset of an active tx: got %v, want ErrTxActive", err)
+		}
+		_ = tx.Put("k", 1)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tx.Reset(ctx); err != nil {
+			t.Fatalf("Reset after Commit: %v", err)
+		}
+		if keys := tx.WriteSetKeys(); len(keys) != 0 {
+			t.Errorf("write buffer not cleared: %v", keys)
+		}
+		if v, ok := tx.Get("k"); !ok || v != 1 {
+			t.Errorf("reset tx: Get(k) = %d, %v; want committed 1, true", v, ok)
+		}
+		_ = tx.Put("k", 2)
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit after Reset: %v", err)
+		}
+	})
+}
+
+// BenchmarkTxReuse сравнивает аллокации на операцию при создании
+// новой транзакции и при переиспользовании одной через Reset.
+func BenchmarkTxReuse(b *testing.B) {
+	ctx := context.Background()
+
+	b.Run("new", func(b *testing.B) {
+		m := mvcc.NewMVCCMap[string, int](ctx)
+		defer m.Close()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tx := m.BeginTx(ctx)
+			_, _ = tx.Get("k")
+			tx.Rollback()
+		}
+	})
+
+	b.Run("reset", func(b *testing.B) {
+		m := mvcc.NewMVCCMap[string, int](ctx)
+		defer m.Close()
+		tx := m.BeginTx(ctx)
+		tx.Rollback()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = tx.Reset(ctx)
+			_, _ = tx.Get("k")
+			tx.Rollback()
+		}
+	})
+}
+
+// TestPutPairs_LastWriteWins проверяет, что дубликаты в пакете
+// по умолчанию разрешаются в пользу последней пары.
+func TestPutPairs_LastWriteWins(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m, _ := newTestMap(t, withStore[string, int](backend)...)
+		tx := m.BeginTx(context.Background())
+		defer tx.Rollback()
+
+		err := tx.PutPairs([]mvcc.Pair[string, int]{
+			{"a", 1}, {"b", 2}, {"a", 3},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := tx.Get("a"); v != 3 {
+			t.Errorf("a = %d, want 3 (last write wins)", v)
+		}
+		if v, _ := tx.Get("b"); v != 2 {
+			t.Errorf("b = %d, want 2", v)
+		}
+	})
+}
+
+// TestPutPairs_StrictRejectsDuplicates проверяет, что с WithStrictBatch
+// пакет с дубликатами отклоняется целиком.
+func TestPutPairs_StrictRejectsDuplicates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithStrictBatch(true))...)
+		defer m.Close()
+
+		tx := m.BeginTx(ctx)
+		defer tx.Rollback()
+
+		err := tx.PutPairs([]mvcc.Pair[string, int]{
+			{"a", 1}, {"b", 2}, {"a", 3},
+		})
+		if !errors.Is(err, mvcc.ErrDuplicateKey) {
+			t.Fatalf("got %v, want ErrDuplicateKey", err)
+		}
+		if keys := tx.WriteSetKeys(); len(keys) != 0 {
+			t.Errorf("rejected batch staged writes: %v", keys)
+		}
+
+		if err := tx.PutPairs([]mvcc.Pair[string, int]{{"a", 1}, {"b", 2}}); err != nil {
+			t.Errorf("batch without duplicates: %v", err)
+		}
+	})
+}
+
+// TestOnConflict_ReceivesConflictDetails проверяет, что WithOnConflict
+// получает проигравшую транзакцию, ключ и версии для сценарного конфликта.
+func TestOnConflict_ReceivesConflictDetails(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+
+		type conflict struct {
+			txID, mine, theirs uint64
+			key                string
+		}
+		var got []conflict
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithOnConflict(func(txID uint64, key string, mine, theirs uint64) {
+				got = append(got, conflict{txID, mine, theirs, key})
+			}),
+		)...)
+		defer m.Close()
+
+		winner := m.BeginTx(ctx)
+		loser := m.BeginTx(ctx)
+		_ = winner.Put("k", 1)
+		_ = loser.Put("k", 2)
+
+		if err := winner.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Fatalf("callback fired on a successful commit: %+v", got)
+		}
+		if err := loser.Commit(); !errors.Is(err, mvcc.ErrConflict) {
+			t.Fatalf("expected ErrConflict, got %v", err)
+		}
+
+		want := conflict{txID: loser.ID(), key: "k", mine: 0, theirs: 1}
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("callback got %+v, want [%+v]", got, want)
+		}
+	})
+}
+
+// TestSetReadOnly проверяет понижение транзакции до read-only:
+// коммит не создаёт версию, записи запрещены, а при наличии записей
+// понижение отклоняется.
+func TestSetReadOnly(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithGCInterval(time.Hour))...)
+		defer m.Close()
+
+		writer := m.BeginTx(ctx)
+		_ = writer.Put("k", 1)
+		if err := writer.SetReadOnly(); !errors.Is(err, mvcc.ErrTxHasWrites) {
+			t.Errorf("SetReadOnly with staged writes: got %v, want ErrTxHasWrites", err)
+		}
+		if err := writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		versions := m.VersionCount()
+
+		tx := m.BeginTx(ctx)
+		_, _ = tx.Get("k")
+		if err := tx.SetReadOnly(); err != nil {
+			t.Fatalf("SetReadOnly: %v", err)
+		}
+		if err := tx.Put("k", 2); !errors.Is(err, mvcc.ErrReadOnlyTx) {
+			t.Errorf("Put after SetReadOnly: got %v, want ErrReadOnlyTx", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("read-only commit: %v", err)
+		}
+		if n := m.VersionCount(); n != versions {
+			t.Errorf("read-only commit created a version: %d → %d", versions, n)
+		}
+	})
+}
+
+// TestValueHasher_SkipsNoOpWrites проверяет, что запись того же значения
+// пропускается и не конфликтует с конкурентной транзакцией, а реальное
+// изменение по-прежнему применяется.
+func TestValueHasher_SkipsNoOpWrites(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithGCInterval(time.Hour),
+			mvcc.WithValueHasher(func(v int) uint64 { return uint64(v) }),
+		)...)
+		defer m.Close()
+
+		seed := m.BeginTx(ctx)
+		_ = seed.Put("k", 1)
+		if err := seed.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		tx := m.BeginTx(ctx)
+		noop := m.BeginTx(ctx)
+		_ = noop.Put("k", 1)
+		if err := noop.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		_ = tx.Put("k", 2)
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit after no-op write: got %v, want nil", err)
+		}
+
+		tx = m.BeginTx(ctx)
+		change := m.BeginTx(ctx)
+		_ = change.Put("k", 3)
+		if err := change.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		_ = tx.Put("k", 4)
+		if err := tx.Commit(); !errors.Is(err, mvcc.ErrConflict) {
+			t.Errorf("commit after real change: got %v, want ErrConflict", err)
+		}
+
+		check := m.BeginTx(ctx)
+		defer check.Rollback()
+		if v, _ := check.Get("k"); v != 3 {
+			t.Errorf("Get(k) = %d, want 3", v)
+		}
+	})
+}
+
+// TestCommitDetailed_ReportsAllConflicts проверяет, что с
+// WithFullConflictReport outcome перечисляет все конфликтующие ключи,
+// а успешный коммит сообщает установленную версию.
+func TestCommitDetailed_ReportsAllConflicts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithGCInterval(time.Hour),
+			mvcc.WithFullConflictReport(true),
+		)...)
+		defer m.Close()
+
+		tx := m.BeginTx(ctx)
+		other := m.BeginTx(ctx)
+		for _, k := range []string{"a", "b", "c"} {
+			_ = other.Put(k, 1)
+		}
+		out, err := other.CommitDetailed()
+		if err != nil || !out.Succeeded {
+			t.Fatalf("CommitDetailed: %v, %+v", err, out)
+		}
+		snap := m.CurrentSnapshot()
+		if out.VersionID != snap.ID() {
+			t.Errorf("VersionID = %d, want current version %d", out.VersionID, snap.ID())
+		}
+		snap.Release()
+
+		for _, k := range []string{"a", "c", "d"} {
+			_ = tx.Put(k, 2)
+		}
+		out, err = tx.CommitDetailed()
+		if !errors.Is(err, mvcc.ErrConflict) || out.Succeeded {
+			t.Fatalf("CommitDetailed: got %v, %+v, want ErrConflict", err, out)
+		}
+		var keys []string
+		for _, c := range out.Conflicts {
+			keys = append(keys, c.Key)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, []string{"a", "c"}) {
+			t.Errorf("conflicting keys = %v, want [a c]", keys)
+		}
+	})
+}
+
+// TestTxTrace_RecordsReadsAndConflictDecisions проверяет, что трасса
+// транзакции фиксирует чтение собственной записи из write buffer и
+// решения проверки конфликтов при коммите.
+func TestTxTrace_RecordsReadsAndConflictDecisions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithGCInterval(time.Hour))...)
+		defer m.Close()
+
+		tx := m.BeginTxWith(ctx, mvcc.TxOptions{Trace: true})
+		commitPut(t, m, "a", 1)
+
+		_ = tx.Put("a", 2)
+		_ = tx.Put("b", 3)
+		if v, ok := tx.Get("a"); !ok || v != 2 {
+			t.Fatalf("Get(a) = %d, %v, want 2, true", v, ok)
+		}
+		if err := tx.Commit(); !errors.Is(err, mvcc.ErrConflict) {
+			t.Fatalf("Commit = %v, want ErrConflict", err)
+		}
+
+		var sawRead, sawConflict bool
+		for _, ev := range tx.Trace() {
+			switch {
+			case ev.Kind == mvcc.TraceRead && ev.Key == "a":
+				sawRead = true
+				if ev.Source != mvcc.ReadFromBuffer || !ev.Found || ev.Value != 2 || !ev.InReadSet {
+					t.Errorf("read event = %+v, want buffered value 2 recorded in read set", ev)
+				}
+			case ev.Kind == mvcc.TraceWriteCheck && ev.Key == "a":
+				sawConflict = true
+				if !ev.Conflict || ev.Reason == "" {
+					t.Errorf("write check of a = %+v, want a conflict with a reason", ev)
+				}
+			case ev.Kind == mvcc.TraceWriteCheck && ev.Key == "b":
+				if ev.Conflict {
+					t.Errorf("write check of b = %+v, want no conflict", ev)
+				}
+			}
+		}
+		if !sawRead || !sawConflict {
+			t.Errorf("trace = %+v, want a buffered read of a and its conflict decision", tx.Trace())
+		}
+
+		plain := m.BeginTx(ctx)
+		defer plain.Rollback()
+		plain.Get("a")
+		if got := plain.Trace(); len(got) != 0 {
+			t.Errorf("Trace without TxOptions.Trace = %+v, want empty", got)
+		}
+	})
+}
+
+// BenchmarkCommitLogger сравнивает пропускную способность коммитов
+// с логгером по умолчанию и с WithNoLogger.
+func BenchmarkCommitLogger(b *testing.B) {
+	ctx := context.Background()
+	for _, bc := range []struct {
+		name string
+		opts []mvcc.Option
+	}{
+		{"default", nil},
+		{"nologger", []mvcc.Option{mvcc.WithNoLogger()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			m := mvcc.NewMVCCMap[int, int](ctx, bc.opts...)
+			defer m.Close()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tx := m.BeginTx(ctx)
+				_ = tx.Put(i%64, i)
+				_ = tx.Commit()
+			}
+		})
+	}
+}
+
+// TestHas_DoesNotDecodeValue проверяет, что Has учитывает tombstone'ы
+// и не вызывает decode, в отличие от Get.
+func TestHas_DoesNotDecodeValue(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		var decodes atomic.Int64
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithGCInterval(time.Hour),
+			mvcc.WithValueCodec(
+				func(v int) int { return v },
+				func(v int) int { decodes.Add(1); return v },
+			),
+		)...)
+		defer m.Close()
+
+		seed := m.BeginTx(ctx)
+		_ = seed.Put("a", 1)
+		_ = seed.Put("b", 2)
+		if err := seed.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		tx := m.BeginTx(ctx)
+		defer tx.Rollback()
+		_ = tx.Delete("b")
+		if !tx.Has("a") || tx.Has("b") || tx.Has("missing") {
+			t.Errorf("Has: a=%v b=%v missing=%v, want true false false",
+				tx.Has("a"), tx.Has("b"), tx.Has("missing"))
+		}
+		if n := decodes.Load(); n != 0 {
+			t.Errorf("Has invoked decode %d times", n)
+		}
+
+		_, _ = tx.Get("a")
+		if n := decodes.Load(); n != 1 {
+			t.Errorf("Get invoked decode %d times, want 1", n)
+		}
+	})
+}
+
+// TestStats_CommitMutexContended проверяет, что конкурентные коммиты
+// увеличивают счётчик ожиданий m.mu, а последовательные — нет.
+func TestStats_CommitMutexContended(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		// Хук выполняется под m.mu: задержка в нём гарантирует, что
+		// конкурентные коммиты застанут мьютекс занятым даже на одном CPU.
+		m := mvcc.NewMVCCMap[int, int](ctx, withStore[int, int](backend,
+			mvcc.WithGCInterval(time.Hour),
+			mvcc.WithCommitHook(func(context.Context, uint64, map[int]int, []int) error {
+				time.Sleep(100 * time.Microsecond)
+				return nil
+			}),
+		)...)
+		defer m.Close()
+
+		for i := range 50 {
+			tx := m.BeginTx(ctx)
+			_ = tx.Put(i, i)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := m.Stats().CommitMutexContended; n != 0 {
+			t.Errorf("sequential commits: CommitMutexContended = %d, want 0", n)
+		}
+
+		var wg sync.WaitGroup
+		for w := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 20 {
+					tx := m.BeginTx(ctx)
+					_ = tx.Put(1000*(w+1)+i, i)
+					_ = tx.Commit()
+				}
+			}()
+		}
+		wg.Wait()
+		if n := m.Stats().CommitMutexContended; n == 0 {
+			t.Error("concurrent commits: CommitMutexContended = 0, want > 0")
+		}
+	})
+}
+
+// TestColdestKeys_RanksByLastRead проверяет, что недавно прочитанные
+// ключи считаются тёплыми, а нетронутые — холодными.
+func TestColdestKeys_RanksByLastRead(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithGCInterval(time.Hour),
+			mvcc.WithAccessTracking(true),
+		)...)
+		defer m.Close()
+
+		seed := m.BeginTx(ctx)
+		for i, k := range []string{"a", "b", "c", "d"} {
+			_ = seed.Put(k, i)
+		}
+		if err := seed.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		tx := m.BeginTx(ctx)
+		_, _ = tx.Get("c")
+		_, _ = tx.Get("a")
+		_, _ = tx.Get("c")
+		tx.Rollback()
+
+		cold := m.ColdestKeys(2)
+		slices.Sort(cold)
+		if !slices.Equal(cold, []string{"b", "d"}) {
+			t.Errorf("ColdestKeys(2) = %v, want untouched [b d]", cold)
+		}
+		if all := m.ColdestKeys(10); len(all) != 4 || all[2] != "a" || all[3] != "c" {
+			t.Errorf("ColdestKeys(10) = %v, want a then c as the warmest", all)
+		}
+	})
+}
+
+// TestColdestKeys_CountsGetStrict проверяет, что чтение через GetStrict
+// согревает ключ так же, как Get.
+func TestColdestKeys_CountsGetStrict(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithGCInterval(time.Hour),
+			mvcc.WithAccessTracking(true),
+		)...)
+		defer m.Close()
+
+		seed := m.BeginTx(ctx)
+		_ = seed.Put("a", 1)
+		_ = seed.Put("b", 2)
+		if err := seed.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		tx := m.BeginTx(ctx)
+		if _, err := tx.GetStrict("a"); err != nil {
+			t.Fatal(err)
+		}
+		tx.Rollback()
+
+		if cold := m.ColdestKeys(1); !slices.Equal(cold, []string{"b"}) {
+			t.Errorf("ColdestKeys(1) = %v, want [b] after GetStrict(a)", cold)
+		}
+	})
+}
+
+// TestDeleteIf проверяет условное удаление: при истинном предикате ключ
+// удаляется, при ложном и для отсутствующего ключа ничего не меняется.
+func TestDeleteIf(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithGCInterval(time.Hour))...)
+		defer m.Close()
+
+		seed := m.BeginTx(ctx)
+		_ = seed.Put("even", 2)
+		_ = seed.Put("odd", 3)
+		if err := seed.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		isEven := func(v int) bool { return v%2 == 0 }
+		tx := m.BeginTx(ctx)
+		for _, tc := range []struct {
+			key  string
+			want bool
+		}{
+			{"even", true},
+			{"odd", false},
+			{"missing", false},
+		} {
+			deleted, err := tx.DeleteIf(tc.key, isEven)
+			if err != nil || deleted != tc.want {
+				t.Errorf("DeleteIf(%q) = %v, %v; want %v, nil", tc.key, deleted, err, tc.want)
+			}
+		}
+		if keys := tx.WriteSetKeys(); !slices.Equal(keys, []string{"even"}) {
+			t.Errorf("write set = %v, want only [even]", keys)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		check := m.BeginTx(ctx)
+		defer check.Rollback()
+		if check.Has("even") {
+			t.Error("even: still present after DeleteIf with a true predicate")
+		}
+		if v, ok := check.Get("odd"); !ok || v != 3 {
+			t.Errorf("odd: Get = %d, %v; want 3, true", v, ok)
+		}
+	})
+}
+
+// TestNewStatement_AdvancesSnapshotBetweenStatements проверяет, что чтения
+// стабильны внутри оператора и видят свежие коммиты после NewStatement,
+// а собственные записи переживают смену снапшота.
+func TestNewStatement_AdvancesSnapshotBetweenStatements(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithGCInterval(time.Hour))...)
+		defer m.Close()
+
+		commit := func(key string, v int) {
+			t.Helper()
+			tx := m.BeginTx(ctx)
+			_ = tx.Put(key, v)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		commit("k", 1)
+
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("own", 10)
+		commit("k", 2)
+		if v, _ := tx.Get("k"); v != 1 {
+			t.Errorf("within statement: Get(k) = %d, want 1", v)
+		}
+
+		if err := tx.NewStatement(); err != nil {
+			t.Fatalf("NewStatement: %v", err)
+		}
+		if v, _ := tx.Get("k"); v != 2 {
+			t.Errorf("after NewStatement: Get(k) = %d, want 2", v)
+		}
+		if v, _ := tx.Get("own"); v != 10 {
+			t.Errorf("own write lost: Get(own) = %d, want 10", v)
+		}
+
+		commit("own", 20)
+		if err := tx.NewStatement(); !errors.Is(err, mvcc.ErrConflict) {
+			t.Errorf("NewStatement after a concurrent write to a buffered key: got %v, want ErrConflict", err)
+		}
+		tx.Rollback()
+	})
+}
+
+// TestWatchKeys_DeliversOnlyWatchedKeys проверяет, что подписка получает
+// закоммиченные изменения только наблюдаемых ключей, включая удаления,
+// и не получает откаченных.
+func TestWatchKeys_DeliversOnlyWatchedKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithGCInterval(time.Hour))...)
+		defer m.Close()
+
+		events := m.WatchKeys(ctx, "a", "b")
+
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("a", 1)
+		_ = tx.Put("x", 100)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		rolledBack := m.BeginTx(ctx)
+		_ = rolledBack.Put("a", 999)
+		rolledBack.Rollback()
+		tx = m.BeginTx(ctx)
+		_ = tx.Put("y", 200)
+		_ = tx.Delete("a")
+		_ = tx.Put("b", 2)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		cancel()
+		var got []mvcc.KeyChange[string, int]
+		for ev := range events {
+			got = append(got, ev)
+		}
+
+		if len(got) != 3 {
+			t.Fatalf("got %d events %+v, want 3", len(got), got)
+		}
+		if ev := got[0]; ev.Key != "a" || ev.Value != 1 || ev.Deleted {
+			t.Errorf("first event = %+v, want a=1", ev)
+		}
+		second := map[string]mvcc.KeyChange[string, int]{got[1].Key: got[1], got[2].Key: got[2]}
+		if ev, ok := second["a"]; !ok || !ev.Deleted {
+			t.Errorf("missing delete of a in %+v", got[1:])
+		}
+		if ev, ok := second["b"]; !ok || ev.Value != 2 || ev.VersionID != got[1].VersionID || ev.VersionID <= got[0].VersionID {
+			t.Errorf("missing b=2 in the second commit: %+v", got)
+		}
+	})
+}
+
+// TestAsyncCommitHook_PreservesVersionOrder проверяет, что асинхронный хук
+// получает все коммиты конкурентных писателей строго по возрастанию версий.
+func TestAsyncCommitHook_PreservesVersionOrder(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		var seen []uint64 // пишет только горутина хука; читаем после Close
+		m := mvcc.NewMVCCMap[int, int](ctx, withStore[int, int](backend,
+			mvcc.WithGCInterval(time.Hour),
+			mvcc.WithAsyncQueue(4, mvcc.OverflowBlock),
+			mvcc.WithAsyncCommitHook(func(vid uint64, changes map[int]int, _ []int) {
+				if len(changes) != 1 {
+					t.Errorf("version %d: got %d changes, want 1", vid, len(changes))
+				}
+				seen = append(seen, vid)
+			}),
+		)...)
+
+		const workers, perWorker = 8, 50
+		var wg sync.WaitGroup
+		var commits atomic.Int64
+		for w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range perWorker {
+					tx := m.BeginTx(ctx)
+					_ = tx.Put(w*perWorker+i, i)
+					if tx.Commit() == nil {
+						commits.Add(1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		m.Close() // дожидается обработки очереди
+
+		if len(seen) != int(commits.Load()) {
+			t.Fatalf("hook saw %d commits, want %d", len(seen), commits.Load())
+		}
+		for i := 1; i < len(seen); i++ {
+			if seen[i] <= seen[i-1] {
+				t.Fatalf("versions out of order at %d: %d after %d", i, seen[i], seen[i-1])
+			}
+		}
+	})
+}
+
+// TestUpdate_ConcurrentIncrementsAreSerialized проверяет, что Update
+// повторяет конфликтующие попытки и итог учитывает каждое применение.
+func TestUpdate_ConcurrentIncrementsAreSerialized(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithGCInterval(10*time.Millisecond))...)
+		defer m.Close()
+
+		const workers, perWorker = 8, 100
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range perWorker {
+					err := m.Update(ctx, "counter", func(old int, _ bool) (int, bool) {
+						return old + 1, true
+					})
+					if err != nil {
+						t.Errorf("Update: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if err := m.Update(ctx, "gone", func(int, bool) (int, bool) { return 0, false }); err != nil {
+			t.Fatalf("Update with delete: %v", err)
+		}
+		tx := m.BeginTx(ctx)
+		defer tx.Rollback()
+		if v, _ := tx.Get("counter"); v != workers*perWorker {
+			t.Errorf("counter = %d, want %d", v, workers*perWorker)
+		}
+		if tx.Has("gone") {
+			t.Error("Update returning keep=false must delete the key")
+		}
+	})
+}
+
+// TestUpdate_DeleteOfAbsentKeyWritesNothing проверяет, что Update с
+// keep == false для отсутствующего ключа не создаёт версии и не вызывает
+// хук коммита.
+func TestUpdate_DeleteOfAbsentKeyWritesNothing(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		var hooks atomic.Int64
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithCommitHook(func(context.Context, uint64, map[string]int, []string) error {
+				hooks.Add(1)
+				return nil
+			}),
+		)...)
+		defer m.Close()
+
+		before := m.CurrentSnapshot()
+		defer before.Release()
+		if err := m.Update(ctx, "missing", func(int, bool) (int, bool) { return 0, false }); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		after := m.CurrentSnapshot()
+		defer after.Release()
+		if after.ID() != before.ID() {
+			t.Errorf("version moved from %d to %d", before.ID(), after.ID())
+		}
+		if n := hooks.Load(); n != 0 {
+			t.Errorf("commit hook called %d times, want 0", n)
+		}
+	})
+}
+
+// TestCounter_ConcurrentIncrementsNeverConflict проверяет, что Increment
+// одного счётчика из конкурентных транзакций не даёт ErrConflict, а итог
+// точен — в том числе с group commit, где инкременты сливаются в группе.
+func TestCounter_ConcurrentIncrementsNeverConflict(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		for _, tc := range []struct {
+			name string
+			opts []mvcc.Option
+		}{
+			{"locked", nil},
+			{"grouped", []mvcc.Option{mvcc.WithGroupCommit(time.Millisecond)}},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				ctx := context.Background()
+				m := mvcc.NewMVCCMap[string, mvcc.Counter](ctx, withStore[string, mvcc.Counter](backend, tc.opts...)...)
+				defer m.Close()
+
+				const workers, perWorker = 16, 50
+				var wg sync.WaitGroup
+				start := make(chan struct{})
+				for range workers {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						<-start
+						for range perWorker {
+							tx := m.BeginTx(ctx)
+							_ = mvcc.Increment(tx, "hits", 1)
+							_ = mvcc.Increment(tx, "hits", 1)
+							if err := tx.Commit(); err != nil {
+								t.Errorf("Commit: %v", err)
+								return
+							}
+						}
+					}()
+				}
+				close(start)
+				wg.Wait()
+
+				tx := m.BeginTx(ctx)
+				defer tx.Rollback()
+				if v, _ := tx.Get("hits"); v != 2*workers*perWorker {
+					t.Errorf("hits = %d, want %d", v, 2*workers*perWorker)
+				}
+			})
+		}
+	})
+}
+
+// TestCommitDetailed_ListsMergedKeys проверяет, что CommitOutcome.Merged
+// перечисляет ключи Merge, изменённые чужим коммитом после снапшота, и
+// не включает ключи, которые никто не трогал.
+func TestCommitDetailed_ListsMergedKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, mvcc.Counter](ctx, withStore[string, mvcc.Counter](backend)...)
+		defer m.Close()
+
+		tx := m.BeginTx(ctx)
+		_ = mvcc.Increment(tx, "hits", 1)
+		_ = mvcc.Increment(tx, "misses", 1)
+
+		other := m.BeginTx(ctx)
+		_ = mvcc.Increment(other, "hits", 10)
+		if err := other.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := tx.CommitDetailed()
+		if err != nil {
+			t.Fatalf("CommitDetailed: %v, %+v", err, out)
+		}
+		if !slices.Equal(out.Merged, []string{"hits"}) {
+			t.Errorf("Merged = %v, want [hits]", out.Merged)
+		}
+		if len(out.Conflicts) != 0 {
+			t.Errorf("Conflicts = %v, want none", out.Conflicts)
+		}
+	})
+}
+
+// TestMinActiveSnapshot_TracksOldestReader проверяет, что MinActiveSnapshot
+// возвращает версию снапшота самого старого активного читателя и false,
+// когда активных транзакций не осталось.
+func TestMinActiveSnapshot_TracksOldestReader(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithManualGC())...)
+		defer m.Close()
+
+		if _, ok := m.MinActiveSnapshot(); ok {
+			t.Fatal("MinActiveSnapshot reported a watermark without active transactions")
+		}
+
+		currentID := func() uint64 {
+			snap := m.CurrentSnapshot()
+			defer snap.Release()
+			return snap.ID()
+		}
+
+		reader := m.BeginTx(ctx)
+		want := currentID()
+		for i := range 5 {
+			tx := m.BeginTx(ctx)
+			_ = tx.Put("k", i)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fresh := m.BeginTx(ctx)
+
+		if got, ok := m.MinActiveSnapshot(); !ok || got != want {
+			t.Errorf("MinActiveSnapshot = (%d, %v), want (%d, true)", got, ok, want)
+		}
+
+		reader.Rollback()
+		if got, ok := m.MinActiveSnapshot(); !ok || got != currentID() {
+			t.Errorf("after old reader closed: MinActiveSnapshot = (%d, %v), want (%d, true)", got, ok, currentID())
+		}
+
+		fresh.Rollback()
+		if got, ok := m.MinActiveSnapshot(); ok {
+			t.Errorf("MinActiveSnapshot = (%d, true) after all transactions closed, want false", got)
+		}
+	})
+}
+
+// TestDefaultTxContext_MergesDeadlineAndValues проверяет, что транзакция
+// видит значения и базового контекста, и контекста вызова, а дедлайн
+// базового контекста прерывает её, хотя у контекста вызова дедлайна нет.
+func TestDefaultTxContext_MergesDeadlineAndValues(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		type ctxKey string
+
+		base := context.WithValue(context.Background(), ctxKey("tenant"), "acme")
+		base, cancel := context.WithTimeout(base, 50*time.Millisecond)
+		defer cancel()
+
+		var tenant, request any
+		hook := func(ctx context.Context, _ uint64, _ map[string]int, _ []string) error {
+			tenant, request = ctx.Value(ctxKey("tenant")), ctx.Value(ctxKey("request"))
+			return nil
+		}
+		m := mvcc.NewMVCCMap[string, int](context.Background(), withStore[string, int](backend,
+			mvcc.WithDefaultTxContext(base),
+			mvcc.WithCommitHook(mvcc.CommitHook[string, int](hook)),
+		)...)
+		defer m.Close()
+
+		call := context.WithValue(context.Background(), ctxKey("request"), "r-1")
+		tx := m.BeginTx(call)
+		_ = tx.Put("k", 1)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if tenant != "acme" || request != "r-1" {
+			t.Errorf("hook saw tenant=%v request=%v, want acme and r-1", tenant, request)
+		}
+
+		slow := m.BeginTx(call)
+		defer slow.Rollback()
+		<-base.Done()
+		// Отмена базового контекста доходит до транзакции асинхронно.
+		deadline := time.Now().Add(time.Second)
+		err := slow.Put("k", 2)
+		for err == nil && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+			err = slow.Put("k", 2)
+		}
+		if !errors.Is(err, mvcc.ErrTxCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Put after default deadline = %v, want ErrTxCanceled wrapping DeadlineExceeded", err)
+		}
+	})
+}
+
+// TestValueEquals_SnapshotValueWriteDoesNotConflict проверяет, что запись
+// значения, уже лежащего в снапшоте, с WithValueEquals не участвует
+// в проверке конфликтов, а без опции конфликтует с конкурентным писателем.
+func TestValueEquals_SnapshotValueWriteDoesNotConflict(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		run := func(opts ...mvcc.Option) (int, error) {
+			m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, opts...)...)
+			defer m.Close()
+			commitPut(t, m, "k", 1)
+
+			tx := m.BeginTx(ctx)
+			_ = tx.Put("k", 1)
+			_ = tx.Put("other", 1)
+			commitPut(t, m, "k", 2)
+
+			err := tx.Commit()
+			check := m.BeginTx(ctx)
+			defer check.Rollback()
+			v, _ := check.Get("k")
+			return v, err
+		}
+
+		if _, err := run(); !errors.Is(err, mvcc.ErrConflict) {
+			t.Fatalf("without WithValueEquals: Commit = %v, want ErrConflict", err)
+		}
+		v, err := run(mvcc.WithValueEquals(func(a, b int) bool { return a == b }))
+		if err != nil {
+			t.Fatalf("with WithValueEquals: Commit = %v, want nil", err)
+		}
+		if v != 2 {
+			t.Errorf("k = %d, want the concurrent writer's 2", v)
+		}
+	})
+}
+
+// TestKeyNormalizer_CaseInsensitiveKeysCollapse проверяет, что с
+// WithKeyNormalizer ключи "Foo" и "foo" — одна запись: транзакция видит
+// их как один ключ, а конкурентные записи в разном регистре конфликтуют.
+func TestKeyNormalizer_CaseInsensitiveKeysCollapse(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithKeyNormalizer(strings.ToLower))...)
+		defer m.Close()
+
+		tx := m.BeginTx(ctx)
+		other := m.BeginTx(ctx)
+		_ = tx.Put("Foo", 1)
+		if v, ok := tx.Get("foo"); !ok || v != 1 {
+			t.Errorf("Get(foo) after Put(Foo) = %d, %v, want 1, true", v, ok)
+		}
+		_ = other.Put("foo", 2)
+		if err := other.Commit(); err != nil {
+			t.Fatalf("Commit(foo): %v", err)
+		}
+		if err := tx.Commit(); !errors.Is(err, mvcc.ErrConflict) {
+			t.Fatalf("Commit(Foo) = %v, want ErrConflict", err)
+		}
+
+		snap := m.CurrentSnapshot()
+		defer snap.Release()
+		for _, k := range []string{"foo", "Foo", "FOO"} {
+			if v, ok := snap.Get(k); !ok || v != 2 {
+				t.Errorf("Get(%s) = %d, %v, want 2, true", k, v, ok)
+			}
+		}
+		if n := mvcc.Reduce(snap, 0, func(n int, _ string, _ int) int { return n + 1 }); n != 1 {
+			t.Errorf("map holds %d entries, want 1", n)
+		}
+	})
+}
+
+// TestKeyNormalizer_AppliesToSetPairsAndReplica проверяет, что
+// WithKeyNormalizer действует и вне Put/Get: MVCCSet.Contains, проверка
+// дубликатов строгого PutPairs и ApplyCommit реплики видят
+// нормализованные ключи.
+func TestKeyNormalizer_AppliesToSetPairsAndReplica(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+
+		set := mvcc.NewMVCCSet[string](ctx, withStore[string, struct{}](backend, mvcc.WithKeyNormalizer(strings.ToLower))...)
+		defer set.Close()
+		if err := set.Add(ctx, "foo"); err != nil {
+			t.Fatal(err)
+		}
+		if !set.Contains("Foo") {
+			t.Error("Contains(Foo) = false after Add(foo)")
+		}
+
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithKeyNormalizer(strings.ToLower),
+			mvcc.WithStrictBatch(true),
+		)...)
+		defer m.Close()
+		tx := m.BeginTx(ctx)
+		defer tx.Rollback()
+		err := tx.PutPairs([]mvcc.Pair[string, int]{{Key: "Foo", Value: 1}, {Key: "foo", Value: 2}})
+		if !errors.Is(err, mvcc.ErrDuplicateKey) {
+			t.Errorf("strict PutPairs(Foo, foo) = %v, want ErrDuplicateKey", err)
+		}
+
+		replica := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithKeyNormalizer(strings.ToLower))...)
+		defer replica.Close()
+		if err := replica.ApplyCommit(1, map[string]int{"Bar": 3}, nil); err != nil {
+			t.Fatal(err)
+		}
+		r := replica.BeginTx(ctx)
+		defer r.Rollback()
+		if v, ok := r.Get("bar"); !ok || v != 3 {
+			t.Errorf("replica Get(bar) = %d, %v, want 3, true", v, ok)
+		}
+		if err := replica.ApplyCommit(2, nil, []string{"BAR"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := contents(replica); len(got) != 0 {
+			t.Errorf("replica after delete of BAR = %v, want empty", got)
+		}
+	})
+}
+
+// TestAbortOlderThan_AbortsOnlyOldTransactions проверяет, что
+// AbortOlderThan прерывает транзакции старше порога с ErrTxTooOld,
+// а более молодые продолжают работу.
+func TestAbortOlderThan_AbortsOnlyOldTransactions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithNoLogger())...)
+		defer m.Close()
+
+		old1 := m.BeginTx(ctx)
+		defer old1.Rollback()
+		old2 := m.BeginTx(ctx)
+		defer old2.Rollback()
+		time.Sleep(60 * time.Millisecond)
+		young := m.BeginTx(ctx)
+		defer young.Rollback()
+
+		if n := m.AbortOlderThan(30 * time.Millisecond); n != 2 {
+			t.Fatalf("AbortOlderThan = %d, want 2", n)
+		}
+		for i, tx := range []*mvcc.Tx[string, int]{old1, old2} {
+			if err := tx.Put("k", i); !errors.Is(err, mvcc.ErrTxTooOld) || !errors.Is(err, mvcc.ErrTxCanceled) {
+				t.Errorf("old tx %d: Put = %v, want ErrTxCanceled wrapping ErrTxTooOld", i, err)
+			}
+		}
+		_ = young.Put("k", 1)
+		if err := young.Commit(); err != nil {
+			t.Errorf("young tx: Commit = %v, want nil", err)
+		}
+	})
+}
+
+// TestCompareAndReplace_RejectsStaleVersion проверяет, что замена по
+// устаревшей версии отклоняется с ErrConflict, а по текущей — заменяет
+// всё содержимое map, удаляя отсутствующие в новых данных ключи.
+func TestCompareAndReplace_RejectsStaleVersion(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend)...)
+		defer m.Close()
+		commitPut(t, m, "a", 1)
+
+		snap := m.CurrentSnapshot()
+		stale := snap.ID()
+		snap.Release()
+		commitPut(t, m, "b", 2)
+
+		if _, err := m.CompareAndReplace(stale, map[string]int{"c": 3}); !errors.Is(err, mvcc.ErrConflict) {
+			t.Fatalf("stale CompareAndReplace = %v, want ErrConflict", err)
+		}
+
+		snap = m.CurrentSnapshot()
+		fresh := snap.ID()
+		snap.Release()
+		vid, err := m.CompareAndReplace(fresh, map[string]int{"a": 10, "c": 3})
+		if err != nil {
+			t.Fatalf("fresh CompareAndReplace: %v", err)
+		}
+		if vid != fresh+1 {
+			t.Errorf("installed version %d, want %d", vid, fresh+1)
+		}
+
+		snap = m.CurrentSnapshot()
+		defer snap.Release()
+		got := mvcc.Reduce(snap, map[string]int{}, func(acc map[string]int, k string, v int) map[string]int {
+			acc[k] = v
+			return acc
+		})
+		if want := map[string]int{"a": 10, "c": 3}; !maps.Equal(got, want) {
+			t.Errorf("contents = %v, want %v", got, want)
+		}
+	})
+}
+
+// TestReadSetAudit_FlagsBlindWrites проверяет, что аудит read set'а
+// сообщает о записи без чтения и молчит о записи после чтения.
+func TestReadSetAudit_FlagsBlindWrites(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		var logs strings.Builder
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithIsolationLevel(mvcc.Serializable),
+			mvcc.WithReadSetAudit(true),
+			mvcc.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		)...)
+		defer m.Close()
+
+		tx := m.BeginTx(ctx)
+		v, _ := tx.Get("checked")
+		_ = tx.Put("checked", v+1)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(logs.String(), "read-set audit") {
+			t.Fatalf("audit flagged a read-then-write: %q", logs.String())
+		}
+
+		tx = m.BeginTx(ctx)
+		_ = tx.Put("blind", 1)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		out := logs.String()
+		if !strings.Contains(out, "read-set audit") || !strings.Contains(out, "blind") {
+			t.Errorf("audit did not flag the blind write, logs: %q", out)
+		}
+	})
+}
+
+// TestPipeline_FlushCommitsBatchAsOneVersion проверяет, что Flush
+// применяет накопленные записи одной версией, атомарно для читателей,
+// и что конвейер создаёт меньше версий, чем коммит на каждую операцию.
+func TestPipeline_FlushCommitsBatchAsOneVersion(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[int, int](ctx, withStore[int, int](backend, mvcc.WithManualGC())...)
+		defer m.Close()
+		commitPut(t, m, -1, 0)
+
+		const ops = 100
+		p := m.Pipeline()
+		before := m.VersionCount()
+		for i := range ops {
+			p.Put(i, i)
+		}
+		p.Delete(-1)
+
+		reader := m.BeginTx(ctx)
+		defer reader.Rollback()
+		if err := p.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if p.Len() != 0 {
+			t.Errorf("Len() = %d after Flush, want 0", p.Len())
+		}
+		if n := m.VersionCount() - before; n != 1 {
+			t.Errorf("Flush created %d versions, want 1", n)
+		}
+		if _, ok := reader.Get(0); ok {
+			t.Error("reader begun before Flush sees pipelined writes")
+		}
+
+		tx := m.BeginTx(ctx)
+		defer tx.Rollback()
+		for i := range ops {
+			if v, ok := tx.Get(i); !ok || v != i {
+				t.Fatalf("Get(%d) = %d, %v after Flush", i, v, ok)
+			}
+		}
+		if tx.Has(-1) {
+			t.Error("pipelined Delete was not applied")
+		}
+
+		before = m.VersionCount()
+		for i := range ops {
+			commitPut(t, m, i, -i)
+		}
+		if perOp := m.VersionCount() - before; perOp != ops {
+			t.Errorf("per-operation commits created %d versions, want %d", perOp, ops)
+		}
+	})
+}
+
+// TestPipeline_FlushHonorsContext проверяет, что Flush с отменённым
+// контекстом ничего не коммитит и сохраняет буфер для повтора.
+func TestPipeline_FlushHonorsContext(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m := mvcc.NewMVCCMap[int, int](context.Background(), withStore[int, int](backend)...)
+		defer m.Close()
+
+		p := m.Pipeline()
+		p.Put(1, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := p.Flush(ctx); !errors.Is(err, mvcc.ErrTxCanceled) {
+			t.Fatalf("Flush with a canceled ctx = %v, want ErrTxCanceled", err)
+		}
+		if p.Len() != 1 {
+			t.Errorf("Len() = %d after a canceled Flush, want 1", p.Len())
+		}
+		if err := p.Flush(context.Background()); err != nil {
+			t.Fatalf("retried Flush: %v", err)
+		}
+		tx := m.BeginTx(context.Background())
+		defer tx.Rollback()
+		if v, ok := tx.Get(1); !ok || v != 1 {
+			t.Errorf("Get(1) = %d, %v after the retried Flush, want 1, true", v, ok)
+		}
+	})
+}
+
+// TestTxDecodeCache_DecodesEachKeyOnce проверяет, что повторные Get
+// одного ключа в транзакции вызывают decode не более одного раза,
+// а собственная запись затеняет закэшированное значение.
+func TestTxDecodeCache_DecodesEachKeyOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		var decodes atomic.Int64
+		m := mvcc.NewMVCCMap[string, []byte](ctx, withStore[string, []byte](backend,
+			mvcc.WithValueCodec(
+				func(v []byte) []byte { return slices.Clone(v) },
+				func(v []byte) []byte {
+					decodes.Add(1)
+					return slices.Clone(v)
+				},
+			),
+		)...)
+		defer m.Close()
+		commitPut(t, m, "a", []byte("alpha"))
+		commitPut(t, m, "b", []byte("beta"))
+
+		tx := m.BeginTx(ctx)
+		defer tx.Rollback()
+		for range 10 {
+			if v, _ := tx.Get("a"); string(v) != "alpha" {
+				t.Fatalf("Get(a) = %q", v)
+			}
+			_, _ = tx.Get("b")
+		}
+		if n := decodes.Load(); n != 2 {
+			t.Errorf("decode called %d times for 2 keys read 10 times each, want 2", n)
+		}
+
+		_ = tx.Put("a", []byte("own"))
+		if v, _ := tx.Get("a"); string(v) != "own" {
+			t.Errorf("Get(a) after Put = %q, want own write", v)
+		}
+		_ = tx.Delete("a")
+		if _, ok := tx.Get("a"); ok {
+			t.Error("Get(a) after Delete returned a cached value")
+		}
+	})
+}
+
+// TestTxLatencyStats_ReflectsKnownDurations проверяет, что квантили
+// длительности транзакций попадают в ожидаемые диапазоны: 90% быстрых
+// транзакций и 10% длительностью около 20 мс.
+func TestTxLatencyStats_ReflectsKnownDurations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithLatencyTracking(true))...)
+		defer m.Close()
+
+		const slow = 20 * time.Millisecond
+		for i := range 100 {
+			tx := m.BeginTx(ctx)
+			if i%10 == 0 {
+				time.Sleep(slow)
+			}
+			_ = tx.Put("k", i)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		s := m.TxLatencyStats()
+		if s.Count != 100 {
+			t.Errorf("Count = %d, want 100", s.Count)
+		}
+		if s.P50 >= slow/2 {
+			t.Errorf("P50 = %v, want well below %v", s.P50, slow)
+		}
+		if s.P95 < slow || s.P99 < slow {
+			t.Errorf("P95 = %v, P99 = %v; want >= %v", s.P95, s.P99, slow)
+		}
+		if s.P99 > s.Max || s.Max < slow || s.Max > 10*slow {
+			t.Errorf("Max = %v (P99 %v), want around %v and not below P99", s.Max, s.P99, slow)
+		}
+
+		plain := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend)...)
+		defer plain.Close()
+		plain.BeginTx(ctx).Rollback()
+		if s := plain.TxLatencyStats(); s != (mvcc.LatencyStats{}) {
+			t.Errorf("without tracking: TxLatencyStats = %+v, want zero", s)
+		}
+	})
+}
+
+// TestTransformAll_DoublesValuesAndConflicts проверяет, что TransformAll
+// преобразует все видимые ключи (с учётом собственных записей), удаляет
+// ключи с keep == false и конфликтует с конкурентной записью любого из них.
+func TestTransformAll_DoublesValuesAndConflicts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend)...)
+		defer m.Close()
+		for k, v := range map[string]int{"a": 1, "b": 2, "drop": 3} {
+			commitPut(t, m, k, v)
+		}
+
+		tx := m.BeginTx(ctx)
+		_ = tx.Put("own", 10)
+		err := tx.TransformAll(func(k string, v int) (int, bool) {
+			return v * 2, k != "drop"
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		snap := m.CurrentSnapshot()
+		got := mvcc.Reduce(snap, map[string]int{}, func(acc map[string]int, k string, v int) map[string]int {
+			acc[k] = v
+			return acc
+		})
+		snap.Release()
+		if want := map[string]int{"a": 2, "b": 4, "own": 20}; !maps.Equal(got, want) {
+			t.Errorf("after TransformAll: %v, want %v", got, want)
+		}
+
+		tx = m.BeginTx(ctx)
+		_ = tx.TransformAll(func(_ string, v int) (int, bool) { return v * 2, true })
+		commitPut(t, m, "b", 100)
+		if err := tx.Commit(); !errors.Is(err, mvcc.ErrConflict) {
+			t.Errorf("Commit after concurrent write of a transformed key = %v, want ErrConflict", err)
+		}
+	})
+}
+
+// TestReadOnlyMethods_SafeAfterClose проверяет, что VersionCount, Stats
+// и GCStats после Close (в том числе повторного) возвращают последнее
+// состояние и сообщают, что map закрыта.
+func TestReadOnlyMethods_SafeAfterClose(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithGCInterval(time.Millisecond))...)
+		reader := m.BeginTx(ctx)
+		for i := range 5 {
+			commitPut(t, m, "k", i)
+		}
+		if m.Stats().Closed {
+			t.Fatal("Stats().Closed = true before Close")
+		}
+
+		m.Close()
+		reader.Rollback()
+		versions, stats, gc := m.VersionCount(), m.Stats(), m.GCStats()
+		if !stats.Closed {
+			t.Error("Stats().Closed = false after Close")
+		}
+		if stats.Commits != 5 {
+			t.Errorf("Commits = %d after Close, want 5", stats.Commits)
+		}
+		if versions < 2 {
+			t.Errorf("VersionCount() = %d, want versions pinned before Close to remain", versions)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		m.Close()
+		if n := m.VersionCount(); n != versions {
+			t.Errorf("VersionCount() changed after Close: %d -> %d", versions, n)
+		}
+		if s := m.Stats(); s != stats {
+			t.Errorf("Stats() changed after Close: %+v -> %+v", stats, s)
+		}
+		if s := m.GCStats(); s != gc {
+			t.Errorf("GCStats() changed after Close: %+v -> %+v", gc, s)
+		}
+	})
+}
+
+// TestRename проверяет перенос значения на свободный и занятый ключ
+// (с overwrite и без), отсутствие источника и конфликт при конкурентном
+// изменении любого из двух ключей.
+func TestRename(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend)...)
+		defer m.Close()
+		commitPut(t, m, "src", 1)
+		commitPut(t, m, "taken", 2)
+
+		contents := func() map[string]int {
+			snap := m.CurrentSnapshot()
+			defer snap.Release()
+			return mvcc.Reduce(snap, map[string]int{}, func(acc map[string]int, k string, v int) map[string]int {
+				acc[k] = v
+				return acc
+			})
+		}
+
+		tx := m.BeginTx(ctx)
+		if err := tx.Rename("missing", "x", false); !errors.Is(err, mvcc.ErrKeyNotFound) {
+			t.Errorf("Rename of a missing key = %v, want ErrKeyNotFound", err)
+		}
+		if err := tx.Rename("src", "taken", false); !errors.Is(err, mvcc.ErrKeyExists) {
+			t.Errorf("Rename onto an existing key = %v, want ErrKeyExists", err)
+		}
+		if err := tx.Rename("src", "dst", false); err != nil {
+			t.Fatalf("Rename: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := contents(), map[string]int{"dst": 1, "taken": 2}; !maps.Equal(got, want) {
+			t.Errorf("after Rename: %v, want %v", got, want)
+		}
+
+		tx = m.BeginTx(ctx)
+		if err := tx.Rename("dst", "taken", true); err != nil {
+			t.Fatalf("Rename with overwrite: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := contents(), map[string]int{"taken": 1}; !maps.Equal(got, want) {
+			t.Errorf("after overwriting Rename: %v, want %v", got, want)
+		}
+
+		for _, changed := range []string{"taken", "next"} {
+			tx := m.BeginTx(ctx)
+			if err := tx.Rename("taken", "next", true); err != nil {
+				t.Fatal(err)
+			}
+			commitPut(t, m, changed, 100)
+			if err := tx.Commit(); !errors.Is(err, mvcc.ErrConflict) {
+				t.Errorf("Rename with %q changed concurrently: Commit = %v, want ErrConflict", changed, err)
+			}
+		}
+	})
+}
+
+// TestGetCtx_CancelsScanWithoutAbortingTx проверяет, что отмена контекста
+// вызова прерывает сканирование через GetCtx, но транзакция остаётся
+// активной, а отмена контекста транзакции её завершает.
+func TestGetCtx_CancelsScanWithoutAbortingTx(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[int, int](ctx, withStore[int, int](backend)...)
+		defer m.Close()
+		setup := m.BeginTx(ctx)
+		for i := range 100 {
+			_ = setup.Put(i, i)
+		}
+		if err := setup.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		tx := m.BeginTx(ctx)
+		scanCtx, cancelScan := context.WithCancel(ctx)
+		defer cancelScan()
+		scanned := 0
+		var scanErr error
+		for i := range 100 {
+			if i == 40 {
+				cancelScan()
+			}
+			if _, _, scanErr = tx.GetCtx(scanCtx, i); scanErr != nil {
+				break
+			}
+			scanned++
+		}
+		if scanned != 40 || !errors.Is(scanErr, mvcc.ErrTxCanceled) || !errors.Is(scanErr, context.Canceled) {
+			t.Errorf("scan stopped after %d keys with %v, want 40 and ErrTxCanceled wrapping Canceled", scanned, scanErr)
+		}
+		if v, ok, err := tx.GetCtx(ctx, 99); err != nil || !ok || v != 99 {
+			t.Errorf("GetCtx with a live context after the scan = %d, %v, %v", v, ok, err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Errorf("Commit after a canceled scan = %v, want nil", err)
+		}
+
+		txCtx, cancelTx := context.WithCancel(ctx)
+		tx = m.BeginTx(txCtx)
+		cancelTx()
+		if _, _, err := tx.GetCtx(ctx, 1); !errors.Is(err, mvcc.ErrTxCanceled) {
+			t.Errorf("GetCtx in a canceled transaction = %v, want ErrTxCanceled", err)
+		}
+		if err := tx.Commit(); !errors.Is(err, mvcc.ErrTxDone) {
+			t.Errorf("Commit after transaction context canceled = %v, want ErrTxDone", err)
+		}
+	})
+}
+
+// TestWaitForVersion_WakesOnInstall проверяет, что WaitForVersion ждёт
+// установки нужной версии, просыпается от коммита и прерывается отменой
+// ctx и Close.
+func TestWaitForVersion_WakesOnInstall(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend)...)
+
+		s := m.NewSession()
+		w, err := s.BeginTx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = w.Put("k", 1)
+		if err := s.Commit(w); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.WaitForVersion(ctx, s.LastVersion()); err != nil {
+			t.Fatalf("WaitForVersion(current) = %v", err)
+		}
+
+		next := s.LastVersion() + 1
+		done := make(chan error, 1)
+		go func() { done <- m.WaitForVersion(ctx, next) }()
+		select {
+		case err := <-done:
+			t.Fatalf("WaitForVersion returned %v before version %d was installed", err, next)
+		case <-time.After(20 * time.Millisecond):
+		}
+		commitPut(t, m, "k", 2)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("WaitForVersion = %v after the install", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("WaitForVersion was not woken by the commit")
+		}
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := m.WaitForVersion(canceled, next+100); !errors.Is(err, context.Canceled) {
+			t.Errorf("canceled ctx: WaitForVersion = %v, want context.Canceled", err)
+		}
+
+		go func() { done <- m.WaitForVersion(ctx, next+100) }()
+		m.Close()
+		if err := <-done; !errors.Is(err, mvcc.ErrClosed) {
+			t.Errorf("after Close: WaitForVersion = %v, want ErrClosed", err)
+		}
+	})
+}
+
+// TestSession_ReaderSeesPriorWriterCommit проверяет, что транзакция
+// сессии всегда видит коммит предыдущей транзакции той же сессии,
+// даже когда параллельно коммитят посторонние писатели.
+func TestSession_ReaderSeesPriorWriterCommit(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend)...)
+		defer m.Close()
+
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				tx := m.BeginTx(ctx)
+				_ = tx.Put("noise", i)
+				_ = tx.Commit()
+			}
+		}()
+		defer func() {
+			close(stop)
+			wg.Wait()
+		}()
+
+		s := m.NewSession()
+		for i := range 100 {
+			w, err := s.BeginTx(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = w.Put("k", i)
+			if err := s.Commit(w); err != nil {
+				t.Fatalf("Commit: %v", err)
+			}
+			written := s.LastVersion()
+
+			r, err := s.BeginTx(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, ok := r.Get("k"); !ok || v != i {
+				t.Fatalf("iteration %d: reader saw %d, %v; want its session's write %d", i, v, ok, i)
+			}
+			if err := s.Commit(r); err != nil {
+				t.Fatal(err)
+			}
+			if s.LastVersion() < written {
+				t.Fatalf("LastVersion went back from %d to %d", written, s.LastVersion())
+			}
+		}
+	})
+}
+
+// TestSnapshotLag_CountsInterveningCommits проверяет, что SnapshotLag
+// читателя равен числу коммитов после его BeginTx и сбрасывается
+// NewStatement.
+func TestSnapshotLag_CountsInterveningCommits(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend)...)
+		defer m.Close()
+
+		reader := m.BeginTx(ctx)
+		defer reader.Rollback()
+		if lag := reader.SnapshotLag(); lag != 0 {
+			t.Fatalf("fresh reader lag = %d, want 0", lag)
+		}
+
+		const commits = 5
+		for i := range commits {
+			tx := m.BeginTx(ctx)
+			_ = tx.Put("k", i)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if lag := reader.SnapshotLag(); lag != commits {
+			t.Errorf("lag = %d after %d commits, want %d", lag, commits, commits)
+		}
+
+		if err := reader.NewStatement(); err != nil {
+			t.Fatal(err)
+		}
+		if lag := reader.SnapshotLag(); lag != 0 {
+			t.Errorf("lag = %d after NewStatement, want 0", lag)
+		}
+	})
+}
+
+// TestLinearizableTx_SeesCommitsCompletedBeforeBegin проверяет, что
+// транзакция с TxOptions.Linearizable видит каждый коммит, завершившийся
+// до её BeginTx, при параллельных писателях.
+func TestLinearizableTx_SeesCommitsCompletedBeforeBegin(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend)...)
+		defer m.Close()
+
+		const writers = 4
+		var (
+			mu    sync.Mutex
+			acked = make(map[string]int, writers)
+		)
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		for w := range writers {
+			key := fmt.Sprintf("w%d", w)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 1; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					tx := m.BeginTx(ctx)
+					_ = tx.Put(key, i)
+					if err := tx.Commit(); err != nil {
+						t.Errorf("Commit: %v", err)
+						return
+					}
+					// Публикуем только после того, как Commit вернул nil.
+					mu.Lock()
+					acked[key] = i
+					mu.Unlock()
+				}
+			}()
+		}
+		defer func() {
+			close(stop)
+			wg.Wait()
+		}()
+
+		for range 500 {
+			mu.Lock()
+			before := maps.Clone(acked)
+			mu.Unlock()
+
+			tx := m.BeginTxWith(ctx, mvcc.TxOptions{Linearizable: true})
+			for key, want := range before {
+				if got, _ := tx.Get(key); got < want {
+					t.Fatalf("%s = %d, but %d was acknowledged before BeginTx", key, got, want)
+				}
+			}
+			tx.Rollback()
+		}
+	})
+}
+
+// TestFairCommitScheduling_QuietGroupProgresses проверяет, что с
+// WithFairCommitScheduling группа с одним писателем получает долю
+// коммитов, сравнимую с группой, заваливающей map из многих горутин.
+func TestFairCommitScheduling_QuietGroupProgresses(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		// Медленный хук держит очередь занятой: тихий писатель почти всё
+		// время ждёт в ней, и его доля определяется только планированием.
+		slowHook := func(context.Context, uint64, map[string]int, []string) error {
+			time.Sleep(200 * time.Microsecond)
+			return nil
+		}
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithFairCommitScheduling(),
+			mvcc.WithCommitHook(mvcc.CommitHook[string, int](slowHook)),
+		)...)
+		defer m.Close()
+
+		var flood, quiet atomic.Int64
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		writer := func(group, key string, counter *atomic.Int64) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				tx := m.BeginTxWith(ctx, mvcc.TxOptions{Group: group})
+				_ = tx.Put(key, i)
+				if tx.Commit() == nil {
+					counter.Add(1)
+				}
+			}
+		}
+		const flooders = 8
+		for i := range flooders {
+			wg.Add(1)
+			go writer("flood", fmt.Sprintf("flood-%d", i), &flood)
+		}
+		wg.Add(1)
+		go writer("quiet", "quiet", &quiet)
+
+		time.Sleep(200 * time.Millisecond)
+		close(stop)
+		wg.Wait()
+
+		// Без справедливой очереди тихой группе досталась бы ~1/9 коммитов,
+		// по кругу — около половины.
+		f, q := flood.Load(), quiet.Load()
+		if q*2 < f {
+			t.Errorf("quiet group committed %d vs flood %d: want at least half of flood's count", q, f)
+		}
+	})
+}
+
+// TestOptimisticValidation_NoLostUpdates проверяет, что с проверкой
+// конфликтов вне мьютекса конкурентные инкременты одного счётчика не
+// теряются: каждый успешный коммит виден в итоговом значении.
+func TestOptimisticValidation_NoLostUpdates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithOptimisticValidation())...)
+		defer m.Close()
+
+		const workers, perWorker = 8, 200
+		var committed atomic.Int64
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range perWorker {
+					tx := m.BeginTx(ctx)
+					v, _ := tx.Get("counter")
+					_ = tx.Put("counter", v+1)
+					if tx.Commit() == nil {
+						committed.Add(1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		tx := m.BeginTx(ctx)
+		defer tx.Rollback()
+		if v, _ := tx.Get("counter"); int64(v) != committed.Load() {
+			t.Errorf("counter = %d, want %d successful increments", v, committed.Load())
+		}
+	})
+}
+
+// TestOptimisticValidation_ReportsConflictOnce проверяет, что
+// предварительная проверка без мьютекса не оставляет следов: если между
+// ней и захватом мьютекса успел закоммитить конкурент, конфликт попадает
+// в трассу, CommitDetailed и WithOnConflict ровно один раз.
+func TestOptimisticValidation_ReportsConflictOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		var callbacks atomic.Int64
+		hold := make(chan struct{})
+		var blocking atomic.Bool
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend,
+			mvcc.WithOptimisticValidation(),
+			mvcc.WithOnConflict(func(uint64, string, uint64, uint64) { callbacks.Add(1) }),
+			mvcc.WithCommitHook(func(context.Context, uint64, map[string]int, []string) error {
+				if blocking.CompareAndSwap(true, false) {
+					<-hold // держит мьютекс коммита
+				}
+				return nil
+			}),
+		)...)
+		defer m.Close()
+
+		tx := m.BeginTxWith(ctx, mvcc.TxOptions{Trace: true})
+		_ = tx.Put("a", 2)
+
+		// Конкурент занимает мьютекс и коммитит "a" уже после того, как tx
+		// прошла предварительную проверку.
+		blocking.Store(true)
+		winner := make(chan error, 1)
+		go func() {
+			w := m.BeginTx(ctx)
+			_ = w.Put("a", 1)
+			winner <- w.Commit()
+		}()
+		for blocking.Load() {
+			time.Sleep(time.Millisecond)
+		}
+		contended := m.Stats().CommitMutexContended
+		type result struct {
+			out *mvcc.CommitOutcome[string, int]
+			err error
+		}
+		done := make(chan result, 1)
+		go func() {
+			out, err := tx.CommitDetailed()
+			done <- result{out, err}
+		}()
+		for m.Stats().CommitMutexContended == contended {
+			time.Sleep(time.Millisecond)
+		}
+		close(hold)
+		if err := <-winner; err != nil {
+			t.Fatal(err)
+		}
+		res := <-done
+		if !errors.Is(res.err, mvcc.ErrConflict) {
+			t.Fatalf("CommitDetailed = %v, want ErrConflict", res.err)
+		}
+
+		if n := callbacks.Load(); n != 1 {
+			t.Errorf("WithOnConflict called %d times, want 1", n)
+		}
+		if len(res.out.Conflicts) != 1 {
+			t.Errorf("Conflicts = %v, want one", res.out.Conflicts)
+		}
+		var checks int
+		for _, ev := range tx.Trace() {
+			if ev.Kind == mvcc.TraceWriteCheck && ev.Key == "a" {
+				checks++
+			}
+		}
+		if checks != 1 {
+			t.Errorf("trace has %d write checks of a, want 1", checks)
+		}
+	})
+}
+
+// BenchmarkOptimisticValidation сравнивает среднее время удержания
+// мьютекса коммита с проверкой конфликтов под мьютексом и вне его
+// для транзакций с большим write set'ом.
+func BenchmarkOptimisticValidation(b *testing.B) {
+	ctx := context.Background()
+	for _, bc := range []struct {
+		name string
+		opts []mvcc.Option
+	}{
+		{"locked", nil},
+		{"optimistic", []mvcc.Option{mvcc.WithOptimisticValidation()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			m := mvcc.NewMVCCMap[int, int](ctx, bc.opts...)
+			defer m.Close()
+			for i := 0; i < b.N; i++ {
+				tx := m.BeginTx(ctx)
+				for k := range 1024 {
+					_ = tx.Put(k, i)
+				}
+				_ = tx.Commit()
+			}
+			b.ReportMetric(float64(m.Stats().AvgCommitLockHeldNanos), "lock-ns/commit")
+		})
+	}
+}
+
+// lazyReader отдаёт данные r и отмечает, что чтение началось.
+type lazyReader struct {
+	r       io.Reader
+	touched bool
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	l.touched = true
+	return l.r.Read(p)
+}
+
+// TestPutStream_MaterializesAtCommit проверяет, что потоки PutStream не
+// читаются до Commit (в том числе из Has), большие значения доступны после
+// него, а ошибка чтения проваливает коммит.
+func TestPutStream_MaterializesAtCommit(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, []byte](ctx, withStore[string, []byte](backend)...)
+		defer m.Close()
+
+		blobs := map[string][]byte{}
+		readers := map[string]*lazyReader{}
+		tx := m.BeginTx(ctx)
+		for i := range 4 {
+			key := fmt.Sprintf("blob-%d", i)
+			blobs[key] = bytes.Repeat([]byte{byte('a' + i)}, 1<<20)
+			readers[key] = &lazyReader{r: bytes.NewReader(blobs[key])}
+			if err := mvcc.PutStream(tx, key, readers[key]); err != nil {
+				t.Fatalf("PutStream(%s): %v", key, err)
+			}
+		}
+		for key := range readers {
+			if !tx.Has(key) {
+				t.Errorf("Has(%s) = false for a staged stream", key)
+			}
+		}
+		for key, r := range readers {
+			if r.touched {
+				t.Errorf("stream %s read before Commit", key)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+
+		check := m.BeginTx(ctx)
+		defer check.Rollback()
+		for key, want := range blobs {
+			if got, ok := check.Get(key); !ok || !bytes.Equal(got, want) {
+				t.Errorf("Get(%s): %d bytes, %v; want the streamed %d bytes", key, len(got), ok, len(want))
+			}
+		}
+
+		broken := m.BeginTx(ctx)
+		readErr := errors.New("disk on fire")
+		_ = mvcc.PutStream(broken, "blob-0", io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(readErr)))
+		if err := broken.Commit(); !errors.Is(err, readErr) {
+			t.Fatalf("Commit with a failing stream = %v, want %v", err, readErr)
+		}
+		if got, _ := check.Get("blob-0"); !bytes.Equal(got, blobs["blob-0"]) {
+			t.Error("failed stream commit changed the stored value")
+		}
+	})
+}
+
+// TestPutStream_ReadAfterCancelDoesNotTouchRolledBackTx проверяет, что
+// чтение, материализующее PutStream в транзакции с отменённым контекстом,
+// не работает с уже откаченной транзакцией: Get возвращает (zero, false),
+// GetStrict — ошибку отката, а не ErrKeyNotFound.
+func TestPutStream_ReadAfterCancelDoesNotTouchRolledBackTx(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		m := mvcc.NewMVCCMap[string, []byte](context.Background(), withStore[string, []byte](backend)...)
+		defer m.Close()
+
+		for _, read := range []string{"Get", "GetStrict"} {
+			ctx, cancel := context.WithCancel(context.Background())
+			tx := m.BeginTx(ctx)
+			if err := mvcc.PutStream(tx, "blob", strings.NewReader("payload")); err != nil {
+				t.Fatalf("PutStream: %v", err)
+			}
+			cancel()
+
+			switch read {
+			case "Get":
+				if v, ok := tx.Get("blob"); ok {
+					t.Errorf("Get after cancel = %q, true; want zero, false", v)
+				}
+			case "GetStrict":
+				_, err := tx.GetStrict("blob")
+				if err == nil || errors.Is(err, mvcc.ErrKeyNotFound) {
+					t.Errorf("GetStrict after cancel = %v, want the rollback error", err)
+				}
+			}
+			if err := tx.Commit(); err == nil {
+				t.Errorf("%s: Commit of a rolled back tx succeeded", read)
+			}
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package mvcc
+
+// MergeFunc вычисляет новое значение ключа из закоммиченного на момент
+// коммита (exists == false, если ключа нет или он удалён).
+type MergeFunc[V any] func(cur V, exists bool) V
+
+// Merge откладывает изменение ключа до коммита: fn применяется к значению,
+// которое окажется текущим в момент Commit, а не к снапшоту транзакции.
+// Поэтому конкурентные Merge одного ключа не конфликтуют, а сливаются —
+// это корректно, только если fn коммутируют (инкременты счётчика,
+// добавление в множество и т. п.).
+//
+// Повторные Merge одного ключа композируются. Если ключ уже записан Put
+// или Delete этой транзакции, fn применяется к буферизованному значению
+// сразу, и ключ участвует в conflict detection как обычная запись;
+// Put/Delete после Merge отменяют отложенное изменение. Get видит
+// fn, применённую к значению из снапшота.
+func (tx *Tx[K, V]) Merge(key K, fn MergeFunc[V]) error {
+	if err := tx.checkActive(); err != nil {
+		return err
+	}
+	if err := tx.ctxErr(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	key = tx.db.normalizeKey(key)
+	tx.materializeStream(key)
+	if err := tx.checkActive(); err != nil {
+		return err
+	}
+
+	if vv, ok := tx.writes[key]; ok {
+		vv.value = fn(vv.value, !vv.deleted)
+		vv.deleted = false
+		tx.writes[key] = vv
+		return nil
+	}
+	if prev, ok := tx.merges[key]; ok {
+		tx.merges[key] = func(cur V, exists bool) V { return fn(prev(cur, exists), true) }
+		return nil
+	}
+	if tx.merges == nil {
+		tx.merges = make(map[K]MergeFunc[V])
+	}
+	tx.merges[key] = fn
+	return nil
+}
+
+// resolveMerges превращает отложенные Merge в обычные записи, применяя их
+// к значениям base. Вызывается после validate (которая пропускает ключи
+// Merge) и до хука: base — версия, поверх которой будут применены записи
+// (current под m.mu или закреплённая база ChunkedCommit). Ключи, которые
+// base изменила после снапшота, запоминаются для CommitOutcome.Merged.
+func (m *MVCCMap[K, V]) resolveMerges(tx *Tx[K, V], base *version[K, V]) {
+	tx.merged = tx.merged[:0]
+	for k, fn := range tx.merges {
+		if base.id > tx.snapshot.id && base.changedSince(tx.snapshot, k) {
+			tx.merged = append(tx.merged, k)
+		}
+		var cur V
+		vv, exists := base.data.Get(k)
+		exists = exists && !vv.deleted
+		if exists {
+			cur = m.decodeValue(vv.value)
+		}
+		tx.writes[k] = versionedValue[V]{value: fn(cur, exists), writerTxID: tx.id}
+	}
+}
+
+// Counter — значение бесконфликтного счётчика (CRDT G/PN-counter):
+// Increment из конкурентных транзакций складываются при коммите, а не
+// конфликтуют.
+type Counter int64
+
+// Increment прибавляет delta к счётчику key через Merge. Конкурентные
+// Increment одного ключа не вызывают ErrConflict, итог точен.
+func Increment[K comparable](tx *Tx[K, Counter], key K, delta int64) error {
+	return tx.Merge(key, func(cur Counter, _ bool) Counter { return cur + Counter(delta) })
+}
@@ -1,6 +1,8 @@
 package mvcc
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"time"
@@ -10,6 +12,57 @@ type config struct {
 	gcInterval            time.Duration
 	deadlockCheckInterval time.Duration
 	logger                *slog.Logger
+	maxVersions           int
+	admissionControl      bool
+	clock                 func() uint64
+	clockSource           Clock
+	isolation             IsolationLevel
+	inlineGCEvery         int
+	maxConcurrentTx       int
+	groupCommitWindow     time.Duration
+
+	strictReads             bool
+	strictBatch             bool
+	readSetAudit            bool
+	manualGC                bool
+	manualDeadlockDetection bool
+
+	// Опции, зависящие от K/V, хранятся как any: Option не параметризован.
+	// NewMVCCMap приводит их к типам конкретной map через typedOption.
+	valueEncode  any
+	valueDecode  any
+	commitHook   any
+	validator    any
+	onConflict   any
+	valueHasher  any
+	valueEquals  any
+	keyNormalize any
+	storeFactory any
+
+	commitHookFailsCommit bool
+	fullConflictReport    bool
+	gcTimeBudget          time.Duration
+	victimStrategy        VictimStrategy
+	maxDetectionTxs       int
+	victimGrace           time.Duration
+	idWrapHeadroom        uint64
+	fairCommits           bool
+	optimisticValidation  bool
+	idWrapAlert           func(counter string, id uint64)
+	accessTracking        bool
+	healthStallAfter      time.Duration
+	healthMaxVersions     int
+	versionFinalizer      func(id uint64, refCount int64)
+	watchBuffer           int
+	asyncHook             any
+	asyncQueueSize        int
+	asyncOverflow         OverflowPolicy
+	scheduler             Scheduler
+	dataMapPool           bool
+	latencyTracking       bool
+	leakMaxVersions       int
+	leakHandler           func(live, limit int)
+	defaultTxCtx          context.Context
 }
 
 func defaultConfig() config {
@@ -17,6 +70,9 @@ func defaultConfig() config {
 		gcInterval:            5 * time.Second,
 		deadlockCheckInterval: 100 * time.Millisecond,
 		logger:                slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+		clockSource:           realClock{},
+		watchBuffer:           defaultWatchBuffer,
+		asyncQueueSize:        defaultAsyncQueueSize,
 	}
 }
 
@@ -24,16 +80,447 @@ func defaultConfig() config {
 type Option func(*config)
 
 // WithGCInterval устанавливает интервал сборки старых версий.
+// d <= 0 отключает фоновую GC-горутину (например, вместе с WithInlineGC).
 func WithGCInterval(d time.Duration) Option {
 	return func(c *config) { c.gcInterval = d }
 }
 
+// WithGCTimeBudget ограничивает длительность одного прохода GC: исчерпав
+// бюджет, проход останавливается, а следующий продолжает с того же места.
+// Меняет полноту отдельного прохода на ограниченную паузу при огромном
+// числе версий. d <= 0 — без ограничения.
+func WithGCTimeBudget(d time.Duration) Option {
+	return func(c *config) { c.gcTimeBudget = d }
+}
+
 // WithDeadlockCheckInterval устанавливает интервал проверки дедлоков.
+// d <= 0 отключает фоновую горутину детектора.
 func WithDeadlockCheckInterval(d time.Duration) Option {
 	return func(c *config) { c.deadlockCheckInterval = d }
 }
 
+// WithAccessTracking включает учёт последнего чтения каждого ключа через
+// Get для ColdestKeys. Стоит одного атомарного Store на чтение; отметки
+// хранятся вне версий и не влияют на их неизменяемость.
+func WithAccessTracking(enabled bool) Option {
+	return func(c *config) { c.accessTracking = enabled }
+}
+
+// WithLatencyTracking включает учёт длительности транзакций от BeginTx
+// до Commit/Rollback для TxLatencyStats. Стоит чтения часов и нескольких
+// атомарных операций на транзакцию; память фиксирована.
+func WithLatencyTracking(enabled bool) Option {
+	return func(c *config) { c.latencyTracking = enabled }
+}
+
+// WithHealthThresholds задаёт пороги HealthCheck: фоновый цикл считается
+// зависшим, если не тикал дольше stallAfter (<= 0 — три его интервала),
+// а map — нездоровой, если хранит больше maxVersions версий (<= 0 — без
+// проверки).
+func WithHealthThresholds(stallAfter time.Duration, maxVersions int) Option {
+	return func(c *config) {
+		c.healthStallAfter = stallAfter
+		c.healthMaxVersions = maxVersions
+	}
+}
+
+// WithVersionFinalizer вызывает fn для каждой версии, удалённой GC, с её
+// итоговым refCount. Предназначена для тестов: корректный учёт ссылок
+// всегда даёт 0, отрицательное значение выдаёт лишний декремент
+// (двойное освобождение снапшота). fn вызывается из прохода GC после
+// освобождения внутренних мьютексов.
+func WithVersionFinalizer(fn func(id uint64, refCount int64)) Option {
+	return func(c *config) { c.versionFinalizer = fn }
+}
+
+// WithWatchBuffer задаёт ёмкость канала каждого подписчика WatchKeys
+// (по умолчанию defaultWatchBuffer). Политика переполнения — отбрасывать
+// новые события: коммит никогда не ждёт подписчика.
+func WithWatchBuffer(n int) Option {
+	return func(c *config) { c.watchBuffer = max(n, 0) }
+}
+
+// WithVictimStrategy задаёт выбор жертвы при разрешении дедлока
+// (по умолчанию YoungestVictim).
+func WithVictimStrategy(s VictimStrategy) Option {
+	return func(c *config) { c.victimStrategy = s }
+}
+
+// WithStore задаёт бэкенд хранения данных версий: factory создаёт пустой
+// Store для нулевой версии, следующие версии получаются его Clone.
+// По умолчанию — встроенная map (mapStore). WithDataMapPool действует
+// только для встроенной map. Типы должны совпадать с K/V map, иначе
+// NewMVCCMap паникует.
+func WithStore[K comparable, V any](factory func() Store[K, V]) Option {
+	return func(c *config) { c.storeFactory = factory }
+}
+
+// WithDataMapPool включает переиспользование карт собранных версий: GC
+// очищает карту версии, на которую нет ссылок, и возвращает её в пул, а
+// коммит копирует данные в карту из пула вместо новой аллокации. Снижает
+// нагрузку на сборщик мусора при высокой частоте коммитов ценой очистки
+// карты в проходе GC.
+//
+// Безопасность держится на refCount: все чтения данных версии вне
+// транзакции (Snapshot, Contains, eager-проверка, ChunkedCommit) закрепляют
+// версию на время чтения. Версии, разделяющие данные (CommitBump), в пул
+// не попадают.
+func WithDataMapPool(enabled bool) Option {
+	return func(c *config) { c.dataMapPool = enabled }
+}
+
+// WithScheduler устанавливает тестовый планировщик чередований (см.
+// Scheduler). В рабочем коде не нужен: nil — без планировщика.
+func WithScheduler(s Scheduler) Option {
+	return func(c *config) { c.scheduler = s }
+}
+
+// WithClockSource заменяет time.Now и time.NewTicker в фоновых циклах GC
+// и детектора дедлоков и в их heartbeat для HealthCheck. С фейковыми
+// часами тесты управляют проходами и порогом зависания детерминированно,
+// не отключая сами циклы. nil оставляет реальные часы.
+func WithClockSource(c Clock) Option {
+	return func(cfg *config) {
+		if c != nil {
+			cfg.clockSource = c
+		}
+	}
+}
+
+// WithDefaultTxContext задаёт базовый контекст всех транзакций map.
+// Контекст каждой транзакции объединяет ctx вызова BeginTx с ctx:
+//   - отмена любого из них прерывает транзакцию;
+//   - действует более ранний из двух дедлайнов;
+//   - значение ключа берётся из ctx вызова, а если его там нет — из ctx.
+//
+// Отмена ctx доходит до уже активных транзакций асинхронно (через
+// context.AfterFunc), отмена ctx вызова — сразу. ctx должен жить не меньше
+// map: после его отмены BeginTx возвращает отменённые транзакции.
+func WithDefaultTxContext(ctx context.Context) Option {
+	return func(c *config) { c.defaultTxCtx = ctx }
+}
+
 // WithLogger устанавливает кастомный slog.Logger.
 func WithLogger(l *slog.Logger) Option {
 	return func(c *config) { c.logger = l }
 }
+
+// WithNoLogger отключает логирование полностью: handler — slog.DiscardHandler,
+// чей Enabled всегда false, поэтому вызовы логгера на горячем пути сводятся
+// к одной проверке. Для бенчмарков и библиотек, которым не нужны логи map.
+func WithNoLogger() Option {
+	return func(c *config) { c.logger = slog.New(slog.DiscardHandler) }
+}
+
+// WithVersionLeakDetector превращает тихую утечку версий (ошибку GC или
+// учёта refCount) в немедленный отказ: если после установки новой версии
+// живых версий больше limit, вызывается handler(live, limit), а при
+// handler == nil — паника. Проверка — сравнение длины списка версий,
+// уже вычисленной на пути коммита. Предназначена для тестов и CI;
+// limit <= 0 отключает детектор.
+func WithVersionLeakDetector(limit int, handler func(live, limit int)) Option {
+	return func(c *config) {
+		c.leakMaxVersions = limit
+		c.leakHandler = handler
+	}
+}
+
+// WithMaxVersions устанавливает потолок числа хранимых версий (0 — без ограничения).
+// Сам по себе потолок не блокирует коммиты — он задаёт порог для WithAdmissionControl.
+func WithMaxVersions(n int) Option {
+	return func(c *config) { c.maxVersions = n }
+}
+
+// WithAdmissionControl включает admission control: при достижении потолка
+// WithMaxVersions BeginTx блокируется (с учётом ctx), пока GC не освободит место.
+// Это не даёт лавине транзакций дойти до коммита, когда хранилище уже переполнено.
+func WithAdmissionControl(enabled bool) Option {
+	return func(c *config) { c.admissionControl = enabled }
+}
+
+// WithCommitTimestamps включает штамповку коммитов временем из now
+// и учёт этого времени при conflict detection: запись, закоммиченная
+// не позже времени начала транзакции, конфликтом не считается
+// ("игнорировать конфликты старше моего чтения").
+//
+// Опасность: корректность целиком зависит от часов. Перекос или
+// немонотонность wall-clock приводят к потерянным обновлениям, а даже
+// логические часы не упорядочены с захватом снапшота атомарно — коммит,
+// получивший метку раньше нашего BeginTx, может стать видимым позже.
+// Предпочитайте логические (монотонные) часы и используйте режим, только
+// если такая семантика действительно нужна.
+func WithCommitTimestamps(now func() uint64) Option {
+	return func(c *config) { c.clock = now }
+}
+
+// WithIsolationLevel устанавливает уровень изоляции транзакций (по умолчанию SnapshotIsolation).
+func WithIsolationLevel(l IsolationLevel) Option {
+	return func(c *config) { c.isolation = l }
+}
+
+// WithInlineGC включает амортизированный GC на пути коммита: каждый n-й
+// успешный Commit выполняет проход collectVersions сразу после освобождения
+// мьютекса коммита. Рост числа версий становится строго ограниченным без
+// джиттера от отдельной горутины. n <= 0 отключает inline GC.
+func WithInlineGC(everyN int) Option {
+	return func(c *config) { c.inlineGCEvery = everyN }
+}
+
+// WithMaxConcurrentTx ограничивает число одновременно активных транзакций.
+// При достижении лимита BeginTx блокируется (с учётом ctx), пока одна из
+// активных транзакций не завершится. n <= 0 — без ограничения.
+func WithMaxConcurrentTx(n int) Option {
+	return func(c *config) { c.maxConcurrentTx = n }
+}
+
+// WithValueCodec задаёт преобразование значений при хранении: encode
+// применяется в commit перед записью в версию, decode — при чтении
+// из снапшота (Get, Snapshot, ForEachVersion). Транзакция декодирует
+// каждый ключ один раз и кэширует результат, поэтому повторные Get одного
+// ключа возвращают тот же декодированный экземпляр. Собственные
+// незакоммиченные записи транзакции хранятся в исходном виде и не декодируются.
+//
+// Оба направления V→V, поэтому подходит для самоописывающих преобразований
+// или V = []byte (сжатие, шифрование). Типы должны совпадать с V map,
+// иначе NewMVCCMap паникует.
+func WithValueCodec[V any](encode, decode func(V) V) Option {
+	return func(c *config) {
+		c.valueEncode = encode
+		c.valueDecode = decode
+	}
+}
+
+// WithStrictReads включает строгий режим чтения для новых транзакций:
+// Get отсутствующего ключа логируется как Warn с подсказкой перейти на
+// GetStrict. Помогает найти код, игнорирующий булев результат Get.
+// GetOr, GetAllInto и GetCtx, явно обрабатывающие отсутствие, не логируются;
+// сам ключ в лог не попадает — только его тип.
+func WithStrictReads(enabled bool) Option {
+	return func(c *config) { c.strictReads = enabled }
+}
+
+// WithReadSetAudit включает отладочный аудит read set'а под Serializable:
+// Commit логирует (Warn) записанные ключи, прежнее значение которых
+// транзакция не читала. Такая "слепая" запись не защищена read-валидацией
+// и часто означает пропущенную зависимость. Только диагностика: на
+// результат коммита не влияет.
+func WithReadSetAudit(enabled bool) Option {
+	return func(c *config) { c.readSetAudit = enabled }
+}
+
+// WithGroupCommit включает group commit: коммиты, пришедшие в течение
+// window, объединяются в одну группу с общей проверкой конфликтов и одной
+// заменой версии. Стоимость clone амортизируется на всю группу ценой
+// задержки до window на каждый коммит. window <= 0 отключает режим.
+func WithGroupCommit(window time.Duration) Option {
+	return func(c *config) { c.groupCommitWindow = window }
+}
+
+// WithStrictBatch заставляет PutPairs отклонять пакеты с повторяющимися
+// ключами (ErrDuplicateKey) вместо last-write-wins.
+func WithStrictBatch(enabled bool) Option {
+	return func(c *config) { c.strictBatch = enabled }
+}
+
+// CommitHook вызывается при каждом коммите с изменениями транзакции:
+// changes — записанные значения, deletes — удалённые ключи.
+type CommitHook[K comparable, V any] func(ctx context.Context, versionID uint64, changes map[K]V, deletes []K) error
+
+// WithCommitHook устанавливает хук коммита. Хук выполняется под мьютексом
+// коммита до установки новой версии и получает контекст транзакции.
+func WithCommitHook[K comparable, V any](fn CommitHook[K, V]) Option {
+	return func(c *config) { c.commitHook = fn }
+}
+
+// WithCommitValidator устанавливает проверку набора изменений коммита —
+// квоты, схемы и прочие политики. fn вызывается под мьютексом коммита
+// после проверки конфликтов и до установки версии; ненулевая ошибка
+// отклоняет коммит с ErrCommitRejected, оборачивающей её. Выполняется
+// в критической секции, поэтому должна быть быстрой и без I/O.
+// Типы должны совпадать с K/V map, иначе NewMVCCMap паникует.
+func WithCommitValidator[K comparable, V any](fn func(changes map[K]V, deletes []K) error) Option {
+	return func(c *config) { c.validator = fn }
+}
+
+// WithCommitHookFailsCommit задаёт, отклоняет ли ошибка хука коммит.
+// По умолчанию ошибка только логируется.
+func WithCommitHookFailsCommit(enabled bool) Option {
+	return func(c *config) { c.commitHookFailsCommit = enabled }
+}
+
+// WithAsyncCommitHook устанавливает хук, вызываемый после каждого коммита
+// из отдельной горутины строго в порядке версий — для побочных эффектов,
+// которые не должны удлинять критическую секцию. Коммиты ставят изменения
+// в ограниченную очередь (WithAsyncQueue); Close дожидается, пока хук
+// обработает всё поставленное. Типы должны совпадать с K/V map, иначе
+// NewMVCCMap паникует.
+func WithAsyncCommitHook[K comparable, V any](fn AsyncCommitHook[K, V]) Option {
+	return func(c *config) { c.asyncHook = fn }
+}
+
+// WithAsyncQueue задаёт ёмкость очереди WithAsyncCommitHook (по умолчанию
+// defaultAsyncQueueSize) и политику при её переполнении.
+func WithAsyncQueue(size int, policy OverflowPolicy) Option {
+	return func(c *config) {
+		c.asyncQueueSize = max(size, 0)
+		c.asyncOverflow = policy
+	}
+}
+
+// ConflictCallback получает каждый обнаруженный конфликт: ID транзакции,
+// ключ, версию её снапшота (mine) и версию, с которой она столкнулась (theirs).
+type ConflictCallback[K comparable] func(txID uint64, key K, mineVersion, theirsVersion uint64)
+
+// WithOnConflict устанавливает единую точку наблюдения за конфликтами
+// для метрик и алертинга. Вызывается из commit под мьютексом перед
+// возвратом ErrConflict, поэтому должен быть быстрым.
+func WithOnConflict[K comparable](fn ConflictCallback[K]) Option {
+	return func(c *config) { c.onConflict = fn }
+}
+
+// WithValueHasher включает пропуск no-op записей: при коммите хеш нового
+// значения сравнивается с хешем, сохранённым рядом с текущим значением
+// ключа, и при совпадении запись не применяется — ключ сохраняет прежнего
+// writer'а и не вызывает конфликтов у конкурентных транзакций.
+//
+// Совпадение хешей проверяется точным сравнением (reflect.DeepEqual),
+// поэтому коллизия лишь стоит лишнего сравнения и никогда не теряет
+// реального изменения. Тип V должен совпадать с V map, иначе NewMVCCMap
+// паникует.
+func WithValueHasher[V any](fn func(V) uint64) Option {
+	return func(c *config) { c.valueHasher = fn }
+}
+
+// WithValueEquals включает отбрасывание no-op записей в транзакции:
+// повторный Put равного значения игнорируется, а при Commit записи,
+// равные значению ключа в снапшоте транзакции, удаляются из write buffer
+// до проверки конфликтов. Такая запись не конфликтует с конкурентными
+// писателями того же ключа, а транзакция из одних таких записей
+// коммитится без новой версии.
+//
+// В отличие от WithValueHasher, сравнение идёт со снапшотом транзакции,
+// а не с текущей версией, и на стороне транзакции. Тип V должен совпадать
+// с V map, иначе NewMVCCMap паникует.
+func WithValueEquals[V any](eq func(a, b V) bool) Option {
+	return func(c *config) { c.valueEquals = eq }
+}
+
+// WithKeyNormalizer приводит каждый ключ к каноническому виду перед
+// Put, Get, Delete и остальными операциями с ключами: логически равные,
+// но не равные по == ключи (строки в разном регистре, ненормализованный
+// Unicode) схлопываются в одну запись. Проверка конфликтов, WatchKeys
+// и хуки видят только нормализованные ключи.
+//
+// fn должна быть идемпотентной: fn(fn(k)) == fn(k). Тип K должен
+// совпадать с K map, иначе NewMVCCMap паникует.
+func WithKeyNormalizer[K comparable](fn func(K) K) Option {
+	return func(c *config) { c.keyNormalize = fn }
+}
+
+// WithFullConflictReport заставляет проверку конфликтов при коммите не
+// останавливаться на первом ключе, а собрать все конфликтующие ключи —
+// их перечисляет CommitOutcome.Conflicts. Удлиняет только путь отказа;
+// WithOnConflict вызывается для каждого найденного ключа.
+func WithFullConflictReport(enabled bool) Option {
+	return func(c *config) { c.fullConflictReport = enabled }
+}
+
+// typedOption приводит значение generic-опции к типу конкретной map.
+// Несовпадение типов — ошибка программиста, поэтому паника.
+func typedOption[T any](v any, name string) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	t, ok := v.(T)
+	if !ok {
+		panic(fmt.Sprintf("mvcc: %s: option type %T does not match map type %T", name, v, zero))
+	}
+	return t
+}
+
+// WithDeadlockVictimGrace включает принудительный откат жертвы дедлока,
+// которая не завершилась за d после отмены: например, её горутина
+// заблокирована в пользовательском коде и не видит отмены контекста,
+// так что снапшот и запись в графе ожидания держатся бесконечно.
+// Первый проход детектора после истечения d откатывает такую жертву и
+// пишет об этом в лог. 0 — без принудительного отката (по умолчанию).
+//
+// Сам Tx детектор не трогает: он снимает транзакцию из activeTxs,
+// освобождает её снапшот (карта не возвращается в WithDataMapPool, пока
+// владелец может её читать) и слот WithMaxConcurrentTx. Очнувшись,
+// владелец получит ErrDeadlock от следующей операции. Жертву, уже
+// вошедшую в Commit (например, зависшую в хуке коммита), детектор не
+// трогает: её ресурсы освобождает сам Commit.
+func WithDeadlockVictimGrace(d time.Duration) Option {
+	return func(c *config) { c.victimGrace = max(d, 0) }
+}
+
+// WithOptimisticValidation переносит проверку конфликтов за пределы
+// мьютекса коммита: транзакция сначала проверяется против текущей версии
+// без блокировки, а под мьютексом проверка повторяется, только если за
+// это время успел закоммитить кто-то ещё или предварительная проверка
+// нашла конфликт (о нём сообщает только проверка под мьютексом).
+// При низкой конкуренции мьютекс удерживается лишь на clone и установку
+// версии; при высокой повторная проверка делает коммит немного дороже.
+// Результат под мьютексом авторитетен. Действует на обычный путь коммита,
+// не на ChunkedCommit и WithGroupCommit.
+func WithOptimisticValidation() Option {
+	return func(c *config) { c.optimisticValidation = true }
+}
+
+// WithFairCommitScheduling включает справедливую очередь коммитов по
+// группам TxOptions.Group (например, арендаторам): коммиты разных групп
+// обслуживаются по кругу, так что группа, заваливающая map записями, не
+// вытесняет остальные у мьютекса коммита. Внутри группы порядок FIFO.
+//
+// Через очередь проходят и CommitBump (в группе транзакции), и
+// CompareAndReplace с ApplyCommit (в группе по умолчанию "").
+//
+// Цена — очередь перед мьютексом на каждом коммите с записями. С
+// WithGroupCommit не действует: группу коммитит один лидер.
+func WithFairCommitScheduling() Option {
+	return func(c *config) { c.fairCommits = true }
+}
+
+// WithIDWrapGuard предупреждает о приближении счётчиков ID транзакций
+// и версий к math.MaxUint64: когда до переполнения остаётся не больше
+// headroom значений, alert вызывается один раз для каждого счётчика
+// ("tx" или "version") с выданным ID. nil alert — запись в лог уровня Error.
+//
+// Само переполнение обнаруживается и без опции: conflict detection
+// сравнивает ID как числа, поэтому вместо молчаливого перехода через
+// ноль map паникует. При миллиарде коммитов в секунду до него ~584 года.
+func WithIDWrapGuard(headroom uint64, alert func(counter string, id uint64)) Option {
+	return func(c *config) {
+		c.idWrapHeadroom = headroom
+		c.idWrapAlert = alert
+	}
+}
+
+// WithMaxActiveTxsForDetection ограничивает цену прохода deadlock
+// detector'а при перегрузке: если активных транзакций больше n, граф
+// ожидания строится лишь по n из них (выборка меняется от прохода
+// к проходу), и при входе в такой режим логируется предупреждение.
+// n <= 0 — без ограничения (по умолчанию).
+//
+// Компромисс: цикл находится, только когда все его участники попали
+// в одну выборку, поэтому под перегрузкой дедлоки обнаруживаются
+// позже — через несколько проходов, а длинные циклы могут не найтись
+// вовсе, пока нагрузка не спадёт. Зато проход не держит activeTxsMu
+// на время обхода миллионов записей и не тормозит BeginTx и Commit.
+func WithMaxActiveTxsForDetection(n int) Option {
+	return func(c *config) { c.maxDetectionTxs = max(n, 0) }
+}
+
+// WithManualGC отключает фоновый тикер GC: проходы выполняются только
+// через RunGCNow (и WithInlineGC). Нужен для детерминированных тестов.
+func WithManualGC() Option {
+	return func(c *config) { c.manualGC = true }
+}
+
+// WithManualDeadlockDetection отключает фоновый тикер deadlock detector'а:
+// проверка выполняется только через DetectDeadlocksNow.
+func WithManualDeadlockDetection() Option {
+	return func(c *config) { c.manualDeadlockDetection = true }
+}
@@ -0,0 +1,67 @@
+package mvcc
+
+import "context"
+
+// Pipeline накапливает записи одной горутины и коммитит их пачкой на
+// Flush: вместо BeginTx/Commit на каждую операцию — одна транзакция и
+// одна версия на сброс. В отличие от одной большой транзакции, каждый
+// Flush — отдельный коммит со своей проверкой конфликтов, а между
+// сбросами другие транзакции видят уже сброшенные изменения.
+//
+// Записи слепые: Flush начинает транзакцию на свежем снапшоте, поэтому
+// конфликт возможен только с коммитом, успевшим между её началом и
+// коммитом. Pipeline не потокобезопасен.
+type Pipeline[K comparable, V any] struct {
+	db      *MVCCMap[K, V]
+	pending map[K]versionedValue[V]
+}
+
+// Pipeline создаёт пустой конвейер записей.
+func (m *MVCCMap[K, V]) Pipeline() *Pipeline[K, V] {
+	return &Pipeline[K, V]{db: m, pending: make(map[K]versionedValue[V])}
+}
+
+// Put буферизует запись до Flush. Повторная запись ключа заменяет прежнюю.
+func (p *Pipeline[K, V]) Put(key K, value V) {
+	p.pending[p.db.normalizeKey(key)] = versionedValue[V]{value: value}
+}
+
+// Delete буферизует удаление до Flush.
+func (p *Pipeline[K, V]) Delete(key K) {
+	p.pending[p.db.normalizeKey(key)] = versionedValue[V]{deleted: true}
+}
+
+// Len возвращает число ключей, ожидающих Flush.
+func (p *Pipeline[K, V]) Len() int {
+	return len(p.pending)
+}
+
+// Flush атомарно коммитит накопленные записи одной транзакцией с
+// контекстом ctx: её отмена прерывает сброс с ErrTxCanceled. Пустой
+// буфер — no-op. При ошибке (ErrConflict, ErrClosed, ErrTxCanceled, ...)
+// буфер сохраняется, и Flush можно повторить.
+func (p *Pipeline[K, V]) Flush(ctx context.Context) error {
+	if len(p.pending) == 0 {
+		return nil
+	}
+	tx, err := p.db.BeginTxContext(ctx)
+	if err != nil {
+		return err
+	}
+	for k, vv := range p.pending {
+		if vv.deleted {
+			err = tx.Delete(k)
+		} else {
+			err = tx.Put(k, vv.value)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	clear(p.pending)
+	return nil
+}
@@ -0,0 +1,80 @@
+package mvcc
+
+import "fmt"
+
+// ApplyCommit устанавливает на реплике версию, произведённую primary:
+// changes и deletes применяются как есть, без проверки конфликтов — primary
+// их уже разрешил. Источником служит WithCommitHook primary, который
+// получает ровно эти аргументы.
+//
+// versionID должен быть следующим после текущей версии реплики, иначе
+// возвращается ErrOutOfOrderApply: пропуск или повтор означал бы
+// расхождение с primary. Поэтому реплика не должна коммитить собственные
+// транзакции — их версии сдвинули бы нумерацию. WithCommitHook реплики
+// не вызывается. С WithKeyNormalizer ключи нормализуются, как и при
+// локальной записи.
+func (m *MVCCMap[K, V]) ApplyCommit(versionID uint64, changes map[K]V, deletes []K) error {
+	if m.closed.Load() {
+		return ErrClosed
+	}
+	changes, deletes = m.normalizeChanges(changes, deletes)
+
+	defer m.enterFairQueue("")()
+	unlock := m.lockCommit()
+	defer unlock()
+
+	current := m.current.Load()
+	if versionID != current.id+1 {
+		return fmt.Errorf("%w: got version %d, want %d", ErrOutOfOrderApply, versionID, current.id+1)
+	}
+
+	// Собственный writer ID: локальные транзакции реплики должны видеть
+	// применённые ключи изменёнными при conflict detection.
+	writer := m.newTxID()
+	var commitTS uint64
+	if m.cfg.clock != nil {
+		commitTS = m.cfg.clock()
+	}
+
+	// Ключ, попавший и в changes, и в deletes, удаляется.
+	writes := make(map[K]versionedValue[V], len(changes)+len(deletes))
+	for k, v := range changes {
+		writes[k] = versionedValue[V]{value: v, writerTxID: writer, commitTS: commitTS}
+	}
+	for _, k := range deletes {
+		writes[k] = versionedValue[V]{writerTxID: writer, deleted: true, commitTS: commitTS}
+	}
+
+	data := m.cloneData(current)
+	size := current.size
+	for k, vv := range writes {
+		size = m.applyWrite(data, size, k, vv)
+	}
+
+	m.purgeTombstones(data)
+	m.installVersion(versionID, data, size)
+	m.versionCommitted(writes, versionID)
+	m.logger.Debug("applied replicated commit",
+		"versionID", versionID,
+		"changes", len(changes),
+		"deletes", len(deletes),
+	)
+	return nil
+}
+
+// normalizeChanges приводит ключи реплицированного коммита к виду
+// WithKeyNormalizer. Без нормализатора возвращает аргументы как есть.
+func (m *MVCCMap[K, V]) normalizeChanges(changes map[K]V, deletes []K) (map[K]V, []K) {
+	if m.normalize == nil {
+		return changes, deletes
+	}
+	normChanges := make(map[K]V, len(changes))
+	for k, v := range changes {
+		normChanges[m.normalize(k)] = v
+	}
+	normDeletes := make([]K, len(deletes))
+	for i, k := range deletes {
+		normDeletes[i] = m.normalize(k)
+	}
+	return normChanges, normDeletes
+}
@@ -0,0 +1,67 @@
+package mvcc_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"mvcc-map/mvcc"
+	"testing"
+	"time"
+)
+
+// contents возвращает содержимое текущей версии map.
+func contents[K comparable, V any](m *mvcc.MVCCMap[K, V]) map[K]V {
+	snap := m.CurrentSnapshot()
+	defer snap.Release()
+	return mvcc.Reduce(snap, map[K]V{}, func(acc map[K]V, k K, v V) map[K]V {
+		acc[k] = v
+		return acc
+	})
+}
+
+// TestApplyCommit_ReplicaConverges проверяет, что реплика, получающая
+// коммиты primary через commit hook, сходится с ним по данным и версии,
+// а коммит не по порядку отклоняется.
+func TestApplyCommit_ReplicaConverges(t *testing.T) {
+	ctx := context.Background()
+	replica := mvcc.NewMVCCMap[string, int](ctx, mvcc.WithGCInterval(time.Hour))
+	defer replica.Close()
+
+	primary := mvcc.NewMVCCMap[string, int](ctx,
+		mvcc.WithGCInterval(time.Hour),
+		mvcc.WithCommitHookFailsCommit(true),
+		mvcc.WithCommitHook(func(_ context.Context, vid uint64, changes map[string]int, deletes []string) error {
+			return replica.ApplyCommit(vid, changes, deletes)
+		}),
+	)
+	defer primary.Close()
+
+	for i := range 20 {
+		tx := primary.BeginTx(ctx)
+		_ = tx.Put("counter", i)
+		_ = tx.Put(string(rune('a'+i%5)), i)
+		if i%3 == 0 {
+			_ = tx.Delete(string(rune('a' + (i+2)%5)))
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit %d: %v", i, err)
+		}
+	}
+
+	if got, want := contents(replica), contents(primary); !maps.Equal(got, want) {
+		t.Errorf("replica diverged:\n got %v\nwant %v", got, want)
+	}
+	rs, ps := replica.CurrentSnapshot(), primary.CurrentSnapshot()
+	if rs.ID() != ps.ID() {
+		t.Errorf("replica version %d, primary version %d", rs.ID(), ps.ID())
+	}
+	rs.Release()
+	ps.Release()
+
+	if err := replica.ApplyCommit(1, map[string]int{"x": 1}, nil); !errors.Is(err, mvcc.ErrOutOfOrderApply) {
+		t.Errorf("stale ApplyCommit: got %v, want ErrOutOfOrderApply", err)
+	}
+	if err := replica.ApplyCommit(100, map[string]int{"x": 1}, nil); !errors.Is(err, mvcc.ErrOutOfOrderApply) {
+		t.Errorf("ApplyCommit with a gap: got %v, want ErrOutOfOrderApply", err)
+	}
+}
@@ -0,0 +1,31 @@
+package mvcc
+
+// SchedulePoint — точка, в которой map передаёт управление Scheduler.
+type SchedulePoint int
+
+const (
+	// PointCommit — коммит транзакции перед захватом мьютекса коммита.
+	PointCommit SchedulePoint = iota
+	// PointGC — начало прохода GC.
+	PointGC
+	// PointDeadlockCheck — начало проверки графа ожидания.
+	PointDeadlockCheck
+)
+
+// Scheduler позволяет тесту управлять чередованием: Yield вызывается
+// в каждой SchedulePoint и может заблокироваться, выполнить RunGCNow /
+// DetectDeadlocksNow или выбрать порядок конкурентных коммитов по seed.
+// txID — транзакция для PointCommit, 0 для остальных точек.
+//
+// Yield вызывается без внутренних мьютексов map. Предназначен для
+// детерминированных и property-based тестов изоляции.
+type Scheduler interface {
+	Yield(p SchedulePoint, txID uint64)
+}
+
+// yield передаёт управление WithScheduler, если он задан.
+func (m *MVCCMap[K, V]) yield(p SchedulePoint, txID uint64) {
+	if s := m.cfg.scheduler; s != nil {
+		s.Yield(p, txID)
+	}
+}
@@ -0,0 +1,247 @@
+package mvcc_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"mvcc-map/mvcc"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// seededScheduler детерминированно по seed вставляет проходы GC и детектора
+// перед коммитами.
+type seededScheduler struct {
+	rng *rand.Rand
+	m   *mvcc.MVCCMap[string, int]
+}
+
+func (s *seededScheduler) Yield(p mvcc.SchedulePoint, _ uint64) {
+	if p != mvcc.PointCommit {
+		return
+	}
+	switch s.rng.Intn(4) {
+	case 0:
+		s.m.RunGCNow()
+	case 1:
+		s.m.DetectDeadlocksNow()
+	}
+}
+
+// FuzzSerializable_NoSkew чередует операции нескольких транзакций
+// в порядке, заданном seed, и проверяет инварианты Serializable:
+// повторное чтение стабильно (нет read skew), а классическая аномалия
+// "дежурных врачей" не оставляет ни одного дежурного (нет write skew).
+func FuzzSerializable_NoSkew(f *testing.F) {
+	for _, seed := range []int64{1, 2, 42, 1337} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		ctx := context.Background()
+		rng := rand.New(rand.NewSource(seed))
+		sched := &seededScheduler{rng: rand.New(rand.NewSource(seed + 1))}
+		m := mvcc.NewMVCCMap[string, int](ctx,
+			mvcc.WithIsolationLevel(mvcc.Serializable),
+			mvcc.WithManualGC(),
+			mvcc.WithManualDeadlockDetection(),
+			mvcc.WithScheduler(sched),
+		)
+		defer m.Close()
+		sched.m = m
+
+		seedTx := m.BeginTx(ctx)
+		_ = seedTx.Put("alice", 1)
+		_ = seedTx.Put("bob", 1)
+		if err := seedTx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Программа каждой транзакции: прочитать обоих, перечитать
+		// первого, снять с дежурства "своего" врача, если дежурят оба.
+		type program struct {
+			tx    *mvcc.Tx[string, int]
+			own   string
+			pc    int
+			first int
+			sum   int
+		}
+		doctors := []string{"alice", "bob"}
+		progs := make([]*program, 2+rng.Intn(3))
+		for i := range progs {
+			progs[i] = &program{own: doctors[rng.Intn(2)]}
+		}
+
+		running := len(progs)
+		for running > 0 {
+			p := progs[rng.Intn(len(progs))]
+			switch p.pc {
+			case 0:
+				p.tx = m.BeginTx(ctx)
+			case 1:
+				p.first, _ = p.tx.Get("alice")
+				p.sum = p.first
+			case 2:
+				v, _ := p.tx.Get("bob")
+				p.sum += v
+			case 3:
+				if again, _ := p.tx.Get("alice"); again != p.first {
+					t.Fatalf("read skew: alice was %d, reread %d", p.first, again)
+				}
+			case 4:
+				if p.sum >= 2 {
+					_ = p.tx.Put(p.own, 0)
+				}
+			case 5:
+				_ = p.tx.Commit()
+				running--
+			default:
+				continue
+			}
+			p.pc++
+		}
+
+		check := m.BeginTx(ctx)
+		defer check.Rollback()
+		alice, _ := check.Get("alice")
+		bob, _ := check.Get("bob")
+		if alice+bob < 1 {
+			t.Fatalf("write skew: nobody on call (alice=%d bob=%d)", alice, bob)
+		}
+	})
+}
+
+// hotKeyWriter перед каждым коммитом успевает закоммитить запись ключа
+// hot, так что любая транзакция, пишущая hot, получает ErrConflict.
+type hotKeyWriter struct {
+	m      *mvcc.MVCCMap[string, int]
+	hot    string
+	inside bool
+}
+
+func (h *hotKeyWriter) Yield(p mvcc.SchedulePoint, _ uint64) {
+	if p != mvcc.PointCommit || h.inside {
+		return
+	}
+	h.inside = true
+	defer func() { h.inside = false }()
+	tx := h.m.BeginTx(context.Background())
+	_ = tx.Put(h.hot, -1)
+	_ = tx.Commit()
+}
+
+// TestCommitIndependent_ConflictingKeyDoesNotBlockOthers проверяет, что
+// ключ, конфликтующий при каждой попытке, попадает в conflicts, а
+// остальные ключи пакета всё равно применяются.
+func TestCommitIndependent_ConflictingKeyDoesNotBlockOthers(t *testing.T) {
+	ctx := context.Background()
+	sched := &hotKeyWriter{hot: "hot"}
+	m := mvcc.NewMVCCMap[string, int](ctx, mvcc.WithScheduler(sched))
+	defer m.Close()
+	sched.m = m
+
+	entries := map[string]int{"a": 1, "b": 2, "hot": 3, "c": 4}
+	applied, conflicts := m.CommitIndependent(ctx, entries)
+	if applied != 3 {
+		t.Errorf("applied = %d, want 3", applied)
+	}
+	if len(conflicts) != 1 || conflicts[0] != "hot" {
+		t.Errorf("conflicts = %v, want [hot]", conflicts)
+	}
+
+	tx := m.BeginTx(ctx)
+	defer tx.Rollback()
+	for _, k := range []string{"a", "b", "c"} {
+		if v, _ := tx.Get(k); v != entries[k] {
+			t.Errorf("Get(%s) = %d, want %d", k, v, entries[k])
+		}
+	}
+	if v, _ := tx.Get("hot"); v != -1 {
+		t.Errorf("Get(hot) = %d, want the competing writer's -1", v)
+	}
+}
+
+// batchInterferer перед первым коммитом успевает закоммитить записи
+// ключей keys — только один раз.
+type batchInterferer struct {
+	m    *mvcc.MVCCMap[string, int]
+	keys []string
+	done bool
+}
+
+func (b *batchInterferer) Yield(p mvcc.SchedulePoint, _ uint64) {
+	if p != mvcc.PointCommit || b.done {
+		return
+	}
+	b.done = true
+	tx := b.m.BeginTx(context.Background())
+	for _, k := range b.keys {
+		_ = tx.Put(k, -1)
+	}
+	_ = tx.Commit()
+}
+
+// TestPutBatch_RetriesOnlyConflictingKeys проверяет, что при конфликте
+// трёх ключей из пакета в 1000 повторяются только эти три, а в итоге
+// все ключи содержат значения пакета.
+func TestPutBatch_RetriesOnlyConflictingKeys(t *testing.T) {
+	ctx := context.Background()
+	hot := []string{"k7", "k500", "k999"}
+	sched := &batchInterferer{keys: hot}
+	m := mvcc.NewMVCCMap[string, int](ctx, mvcc.WithScheduler(sched))
+	defer m.Close()
+	sched.m = m
+
+	entries := make(map[string]int, 1000)
+	for i := range 1000 {
+		entries[fmt.Sprintf("k%d", i)] = i
+	}
+	retried, err := m.PutBatch(ctx, entries)
+	if err != nil {
+		t.Fatalf("PutBatch: %v", err)
+	}
+	slices.Sort(retried)
+	want := slices.Clone(hot)
+	slices.Sort(want)
+	if !slices.Equal(retried, want) {
+		t.Errorf("retried = %v, want %v", retried, want)
+	}
+
+	tx := m.BeginTx(ctx)
+	defer tx.Rollback()
+	for k, v := range entries {
+		if got, _ := tx.Get(k); got != v {
+			t.Fatalf("Get(%s) = %d, want %d", k, got, v)
+		}
+	}
+}
+
+// TestPutBatch_NormalizedKeyConflictKeepsValue проверяет, что с
+// WithKeyNormalizer конфликт по нормализованному ключу повторяет запись
+// со значением из пакета, а не с нулевым.
+func TestPutBatch_NormalizedKeyConflictKeepsValue(t *testing.T) {
+	ctx := context.Background()
+	sched := &batchInterferer{keys: []string{"foo"}}
+	m := mvcc.NewMVCCMap[string, int](ctx,
+		mvcc.WithScheduler(sched),
+		mvcc.WithKeyNormalizer(strings.ToLower),
+	)
+	defer m.Close()
+	sched.m = m
+
+	retried, err := m.PutBatch(ctx, map[string]int{"Foo": 42, "Bar": 7})
+	if err != nil {
+		t.Fatalf("PutBatch: %v", err)
+	}
+	if !slices.Equal(retried, []string{"foo"}) {
+		t.Errorf("retried = %v, want [foo]", retried)
+	}
+
+	tx := m.BeginTx(ctx)
+	defer tx.Rollback()
+	for k, want := range map[string]int{"foo": 42, "FOO": 42, "bar": 7} {
+		if got, _ := tx.Get(k); got != want {
+			t.Errorf("Get(%s) = %d, want %d", k, got, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package mvcc
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Session — последовательность транзакций одного клиента с гарантиями
+// read-your-writes и монотонного чтения: каждая транзакция сессии видит
+// версию не старше той, которую закоммитила или прочитала предыдущая.
+//
+// В пределах одной map это следует уже из того, что Commit возвращается
+// после установки версии; Session делает гарантию явной и сохраняет её
+// там, где транзакции сессии начинаются в других местах кода. Session
+// можно разделять между горутинами.
+type Session[K comparable, V any] struct {
+	db   *MVCCMap[K, V]
+	last atomic.Uint64 // нижняя граница снапшота следующей транзакции
+}
+
+// NewSession создаёт сессию без прошлых коммитов.
+func (m *MVCCMap[K, V]) NewSession() *Session[K, V] {
+	return &Session[K, V]{db: m}
+}
+
+// LastVersion возвращает версию, не старше которой будет снапшот
+// следующей транзакции сессии.
+func (s *Session[K, V]) LastVersion() uint64 {
+	return s.last.Load()
+}
+
+// BeginTx начинает транзакцию со снапшотом не старше LastVersion: если
+// текущая версия ещё не дошла до неё, снапшот обновляется, пока не дойдёт
+// или не отменится ctx. Ошибки — как у BeginTxContext.
+func (s *Session[K, V]) BeginTx(ctx context.Context) (*Tx[K, V], error) {
+	tx, err := s.db.BeginTxContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.awaitSnapshot(s.last.Load()); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// awaitSnapshot обновляет снапшот транзакции, когда текущая версия map
+// дойдёт до want, или возвращает ошибку при отмене контекста транзакции
+// и Close. Вызывается до первых записей: NewStatement с пустым write
+// buffer не конфликтует.
+func (tx *Tx[K, V]) awaitSnapshot(want uint64) error {
+	for tx.snapshot.id < want {
+		if err := tx.db.WaitForVersion(tx.ctx, want); err != nil {
+			if ctxErr := tx.ctxErr(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+		if err := tx.NewStatement(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitForVersion блокируется, пока текущая версия map не станет не меньше
+// vid. Ожидание не опрашивает map: его будит установка каждой новой
+// версии. Возвращает ошибку ctx при его отмене и ErrClosed после Close.
+func (m *MVCCMap[K, V]) WaitForVersion(ctx context.Context, vid uint64) error {
+	for {
+		m.versionsMu.Lock()
+		if m.currentVersionID() >= vid {
+			m.versionsMu.Unlock()
+			return nil
+		}
+		if m.closed.Load() {
+			m.versionsMu.Unlock()
+			return ErrClosed
+		}
+		if m.versionInstalled == nil {
+			m.versionInstalled = make(chan struct{})
+		}
+		installed := m.versionInstalled
+		m.versionsMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-installed:
+		}
+	}
+}
+
+// Commit коммитит транзакцию сессии и поднимает LastVersion до
+// установленной версии, а для read-only транзакции — до версии её
+// снапшота, чтобы следующие чтения не ушли назад.
+func (s *Session[K, V]) Commit(tx *Tx[K, V]) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.observe(max(tx.commitVID, tx.snapshot.id))
+	return nil
+}
+
+// observe поднимает нижнюю границу до vid; граница только растёт.
+func (s *Session[K, V]) observe(vid uint64) {
+	for {
+		cur := s.last.Load()
+		if vid <= cur || s.last.CompareAndSwap(cur, vid) {
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package mvcc
+
+import "context"
+
+// MVCCSet — множество поверх MVCCMap[K, struct{}].
+//
+// struct{} не занимает памяти, поэтому множество не платит за фиктивное
+// значение. Вся MVCC-механика (snapshot isolation, conflict detection, GC)
+// переиспользуется без изменений: Add — это Put, Remove — это Delete.
+type MVCCSet[K comparable] struct {
+	m *MVCCMap[K, struct{}]
+}
+
+// NewMVCCSet создаёт новое множество. Опции те же, что у NewMVCCMap.
+//
+// Вызывающий должен вызвать Close() для корректного завершения.
+func NewMVCCSet[K comparable](ctx context.Context, opts ...Option) *MVCCSet[K] {
+	return &MVCCSet[K]{m: NewMVCCMap[K, struct{}](ctx, opts...)}
+}
+
+// Close останавливает фоновые горутины нижележащей map.
+func (s *MVCCSet[K]) Close() {
+	s.m.Close()
+}
+
+// Add добавляет элемент в отдельной транзакции.
+func (s *MVCCSet[K]) Add(ctx context.Context, key K) error {
+	tx := s.BeginTx(ctx)
+	defer tx.Rollback()
+	if err := tx.Add(key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Remove удаляет элемент в отдельной транзакции.
+func (s *MVCCSet[K]) Remove(ctx context.Context, key K) error {
+	tx := s.BeginTx(ctx)
+	defer tx.Rollback()
+	if err := tx.Remove(key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Contains сообщает, есть ли элемент в текущей версии.
+// Читает без блокировок, как и BeginTx: версия закрепляется на время
+// чтения, чтобы её данные не ушли в WithDataMapPool.
+func (s *MVCCSet[K]) Contains(key K) bool {
+	v := s.m.acquireCurrent()
+	defer v.refCount.Add(-1)
+	vv, ok := v.data.Get(s.m.normalizeKey(key))
+	return ok && !vv.deleted
+}
+
+// Len возвращает число элементов в текущей версии.
+func (s *MVCCSet[K]) Len() int {
+	return s.m.current.Load().size
+}
+
+// BeginTx начинает транзакцию над множеством.
+func (s *MVCCSet[K]) BeginTx(ctx context.Context) *SetTx[K] {
+	return &SetTx[K]{tx: s.m.BeginTx(ctx)}
+}
+
+// SetTx — транзакция над MVCCSet. Семантика та же, что у Tx.
+type SetTx[K comparable] struct {
+	tx *Tx[K, struct{}]
+}
+
+// Add добавляет элемент в write buffer транзакции.
+func (t *SetTx[K]) Add(key K) error {
+	return t.tx.Put(key, struct{}{})
+}
+
+// Remove помечает элемент удалённым в write buffer транзакции.
+func (t *SetTx[K]) Remove(key K) error {
+	return t.tx.Delete(key)
+}
+
+// Contains сообщает, есть ли элемент в снапшоте транзакции
+// с учётом её собственных изменений.
+func (t *SetTx[K]) Contains(key K) bool {
+	_, ok := t.tx.get(key)
+	return ok
+}
+
+// Commit применяет изменения. Возвращает ErrConflict, если другая
+// транзакция изменила те же элементы после нашего снапшота.
+func (t *SetTx[K]) Commit() error {
+	return t.tx.Commit()
+}
+
+// Rollback отменяет транзакцию. Идемпотентна.
+func (t *SetTx[K]) Rollback() {
+	t.tx.Rollback()
+}
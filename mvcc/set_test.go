@@ -0,0 +1,92 @@
+package mvcc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mvcc-map/mvcc"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSet(t *testing.T) *mvcc.MVCCSet[string] {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := mvcc.NewMVCCSet[string](ctx, mvcc.WithGCInterval(50*time.Millisecond))
+	t.Cleanup(func() {
+		cancel()
+		s.Close()
+	})
+	return s
+}
+
+// TestSet_AddRemoveContains проверяет базовую семантику множества.
+func TestSet_AddRemoveContains(t *testing.T) {
+	s := newTestSet(t)
+	ctx := context.Background()
+
+	if err := s.Add(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Contains("a") || !s.Contains("b") || s.Len() != 2 {
+		t.Fatalf("after Add: Contains(a)=%v Contains(b)=%v Len=%d", s.Contains("a"), s.Contains("b"), s.Len())
+	}
+
+	if err := s.Remove(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Contains("a") || s.Len() != 1 {
+		t.Errorf("after Remove: Contains(a)=%v Len=%d", s.Contains("a"), s.Len())
+	}
+}
+
+// TestSet_ConcurrentAddRemoveConflict проверяет, что Add и Remove одного
+// элемента в конкурентных транзакциях порождают конфликт.
+func TestSet_ConcurrentAddRemoveConflict(t *testing.T) {
+	s := newTestSet(t)
+	ctx := context.Background()
+
+	adder := s.BeginTx(ctx)
+	remover := s.BeginTx(ctx)
+
+	_ = adder.Add("x")
+	_ = remover.Remove("x")
+
+	if err := adder.Commit(); err != nil {
+		t.Fatalf("adder commit failed: %v", err)
+	}
+	if err := remover.Commit(); !errors.Is(err, mvcc.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if !s.Contains("x") {
+		t.Error("element added by the first committer is missing")
+	}
+}
+
+// TestSet_ConcurrentAdds проверяет, что параллельные Add разных
+// элементов не конфликтуют и все попадают в множество.
+func TestSet_ConcurrentAdds(t *testing.T) {
+	s := newTestSet(t)
+	ctx := context.Background()
+
+	const n = 100
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Add(ctx, fmt.Sprintf("e%d", i)); err != nil {
+				t.Errorf("Add(e%d): %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if s.Len() != n {
+		t.Errorf("Len = %d, want %d", s.Len(), n)
+	}
+}
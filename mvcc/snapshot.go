@@ -0,0 +1,269 @@
+package mvcc
+
+import (
+	"fmt"
+	"iter"
+	"sync/atomic"
+)
+
+// Snapshot — закреплённая (pinned) версия для внешних потребителей,
+// например репликации, которой нужна стабильная версия независимо
+// от активных транзакций. Пока Snapshot не освобождён, GC версию не удалит.
+//
+// В отличие от Tx, Snapshot только читает и может использоваться
+// из нескольких горутин одновременно: версия неизменяема. Release,
+// конкурентный с чтением, безопасен: закрепление снимается, когда
+// завершится последнее начатое чтение.
+type Snapshot[K comparable, V any] struct {
+	v        *version[K, V]
+	db       *MVCCMap[K, V]
+	released atomic.Bool
+
+	// holds — собственное закрепление Snapshot (1 до Release) плюс число
+	// чтений в процессе. Когда holds падает до нуля, снимается refCount
+	// версии, и только после этого GC может вернуть её карту в пул.
+	holds atomic.Int64
+}
+
+func newSnapshot[K comparable, V any](m *MVCCMap[K, V], v *version[K, V]) *Snapshot[K, V] {
+	s := &Snapshot[K, V]{v: v, db: m}
+	s.holds.Store(1)
+	return s
+}
+
+// enter начинает чтение данных версии. false — Snapshot уже освобождён.
+func (s *Snapshot[K, V]) enter() bool {
+	for {
+		n := s.holds.Load()
+		if n == 0 || s.released.Load() {
+			return false
+		}
+		if s.holds.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// leave завершает чтение (или снимает собственное закрепление в Release).
+func (s *Snapshot[K, V]) leave() {
+	if s.holds.Add(-1) == 0 {
+		s.v.refCount.Add(-1)
+	}
+}
+
+// Pin закрепляет версию с указанным ID, увеличивая её refCount.
+// Возвращает ErrVersionCollected, если версия уже удалена GC.
+//
+// Вызывающий должен вызвать Release, иначе версия никогда не будет собрана.
+func (m *MVCCMap[K, V]) Pin(versionID uint64) (*Snapshot[K, V], error) {
+	// Инкремент под versionsMu: GC проверяет refCount под тем же мьютексом,
+	// поэтому найденная версия не может быть удалена между поиском и Add(1).
+	m.versionsMu.Lock()
+	defer m.versionsMu.Unlock()
+
+	for _, v := range m.versions {
+		if v.id == versionID {
+			v.refCount.Add(1)
+			return newSnapshot(m, v), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: version %d", ErrVersionCollected, versionID)
+}
+
+// CurrentSnapshot закрепляет текущую версию. Как и BeginTx, не берёт
+// мьютексов; в отличие от транзакции, не регистрируется в activeTxs.
+//
+// Вызывающий должен вызвать Release.
+func (m *MVCCMap[K, V]) CurrentSnapshot() *Snapshot[K, V] {
+	return newSnapshot(m, m.acquireCurrent())
+}
+
+// GetMultiAt читает keys из одной версии и возвращает присутствующие
+// ключи вместе с ID этой версии — токеном согласованности для кэша
+// клиента. Без транзакции: версия закрепляется только на время чтения.
+func (m *MVCCMap[K, V]) GetMultiAt(keys []K) (map[K]V, uint64) {
+	v := m.acquireCurrent()
+	defer v.refCount.Add(-1)
+
+	out := make(map[K]V, len(keys))
+	for _, key := range keys {
+		key = m.normalizeKey(key)
+		if vv, ok := v.data.Get(key); ok && !vv.deleted {
+			out[key] = m.decodeValue(vv.value)
+		}
+	}
+	return out, v.id
+}
+
+// DeltaSince возвращает изменения между версией version и текущей:
+// изменённые и добавленные значения, удалённые ключи и ID текущей версии,
+// от которой строить следующую дельту. Pull-дополнение к WatchKeys и
+// асинхронному хуку: клиент обновляет кэш, не перечитывая всё.
+//
+// Базовая версия должна быть ещё жива — закреплена Pin, Tag или активной
+// транзакцией; иначе возвращается ErrHistoryGap, и клиенту нужна полная
+// перезагрузка. Ключ, записанный заново тем же значением, тоже попадает
+// в дельту: сравниваются писатели, а не значения.
+func (m *MVCCMap[K, V]) DeltaSince(version uint64) (map[K]V, []K, uint64, error) {
+	base, err := m.Pin(version)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%w: version %d", ErrHistoryGap, version)
+	}
+	defer base.Release()
+	cur := m.acquireCurrent()
+	defer cur.refCount.Add(-1)
+
+	changed := make(map[K]V)
+	var deleted []K
+	for k, vv := range cur.data.Iterate {
+		bv, inBase := base.v.data.Get(k)
+		if inBase && bv.writerTxID == vv.writerTxID && bv.deleted == vv.deleted {
+			continue
+		}
+		switch {
+		case !vv.deleted:
+			changed[k] = m.decodeValue(vv.value)
+		case inBase && !bv.deleted:
+			deleted = append(deleted, k)
+		}
+	}
+	// Tombstone'ы, вычищенные purgeTombstones, в текущей версии отсутствуют.
+	for k, bv := range base.v.data.Iterate {
+		if _, ok := cur.data.Get(k); !ok && !bv.deleted {
+			deleted = append(deleted, k)
+		}
+	}
+	return changed, deleted, cur.id, nil
+}
+
+// ID возвращает ID закреплённой версии.
+func (s *Snapshot[K, V]) ID() uint64 {
+	return s.v.id
+}
+
+// Get возвращает значение ключа в закреплённой версии.
+// После Release всегда возвращает (zero, false).
+func (s *Snapshot[K, V]) Get(key K) (V, bool) {
+	var zero V
+	// Чтение идёт под enter/leave: освобождённую версию GC может собрать,
+	// а с WithDataMapPool — переиспользовать её карту.
+	if !s.enter() {
+		return zero, false
+	}
+	defer s.leave()
+	vv, ok := s.v.data.Get(s.db.normalizeKey(key))
+	if !ok || vv.deleted {
+		return zero, false
+	}
+	return s.db.decodeValue(vv.value), true
+}
+
+// Release снимает закрепление. Идемпотентна.
+func (s *Snapshot[K, V]) Release() {
+	if s.released.CompareAndSwap(false, true) {
+		s.leave()
+	}
+}
+
+// Reduce сворачивает все пары ключ-значение закреплённой версии за один
+// проход: Sum/Count/Min/Max и прочая аналитика над согласованным снимком
+// без доступа к внутренней карте. Порядок обхода не определён.
+// После Release возвращает init; Release во время обхода его не обрывает.
+func Reduce[K comparable, V, A any](snap *Snapshot[K, V], init A, fn func(A, K, V) A) A {
+	if !snap.enter() {
+		return init
+	}
+	defer snap.leave()
+	acc := init
+	for k, vv := range snap.v.data.Iterate {
+		if !vv.deleted {
+			acc = fn(acc, k, snap.db.decodeValue(vv.value))
+		}
+	}
+	return acc
+}
+
+// ValueMeta — значение ключа вместе с его происхождением.
+type ValueMeta[V any] struct {
+	Value V
+	// WriterTxID — ID транзакции, закоммитившей это значение.
+	WriterTxID uint64
+}
+
+// AllWithMeta обходит пары закреплённой версии вместе с ID транзакции,
+// записавшей каждое значение. Версия неизменяема, поэтому обход согласован
+// и без блокировок; порядок не определён. После Release ничего не выдаёт;
+// Release во время обхода (в том числе из тела цикла) его не обрывает —
+// закрепление снимается по окончании обхода.
+//
+// Выдаются только присутствующие ключи: удалённые (tombstone) пропускаются,
+// а ключ, явно записанный нулевым значением V, выдаётся. Поэтому экспорт
+// через AllWithMeta и импорт через CompareAndReplace сохраняют различие
+// между "ключа нет" и "значение равно zero".
+func (s *Snapshot[K, V]) AllWithMeta() iter.Seq2[K, ValueMeta[V]] {
+	return func(yield func(K, ValueMeta[V]) bool) {
+		if !s.enter() {
+			return
+		}
+		defer s.leave()
+		for k, vv := range s.v.data.Iterate {
+			if vv.deleted {
+				continue
+			}
+			if !yield(k, ValueMeta[V]{Value: s.db.decodeValue(vv.value), WriterTxID: vv.writerTxID}) {
+				return
+			}
+		}
+	}
+}
+
+// Tag закрепляет текущую версию под именем name и возвращает её ID —
+// именованная точка сохранения на уровне map. Версия переживает GC,
+// пока тег не удалён DropTag. Повторный Tag с тем же именем
+// перевешивает тег на текущую версию, освобождая прежнюю.
+func (m *MVCCMap[K, V]) Tag(name string) uint64 {
+	snap := m.CurrentSnapshot()
+
+	m.tagsMu.Lock()
+	if m.tags == nil {
+		m.tags = make(map[string]*Snapshot[K, V])
+	}
+	prev := m.tags[name]
+	m.tags[name] = snap
+	m.tagsMu.Unlock()
+
+	if prev != nil {
+		prev.Release()
+	}
+	return snap.ID()
+}
+
+// ReadTag возвращает значение ключа в версии, закреплённой тегом name.
+// Возвращает ErrTagNotFound, если такого тега нет.
+func (m *MVCCMap[K, V]) ReadTag(name string, key K) (V, bool, error) {
+	// Читаем под tagsMu: иначе конкурентный DropTag мог бы освободить
+	// версию посреди чтения.
+	m.tagsMu.Lock()
+	defer m.tagsMu.Unlock()
+	snap, ok := m.tags[name]
+	if !ok {
+		var zero V
+		return zero, false, fmt.Errorf("%w: %q", ErrTagNotFound, name)
+	}
+	v, found := snap.Get(key)
+	return v, found, nil
+}
+
+// DropTag удаляет тег и снимает закрепление его версии, после чего её
+// может собрать GC. Возвращает ErrTagNotFound, если такого тега нет.
+func (m *MVCCMap[K, V]) DropTag(name string) error {
+	m.tagsMu.Lock()
+	snap, ok := m.tags[name]
+	delete(m.tags, name)
+	m.tagsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrTagNotFound, name)
+	}
+	snap.Release()
+	return nil
+}
@@ -0,0 +1,402 @@
+package mvcc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"mvcc-map/mvcc"
+	"testing"
+	"time"
+)
+
+// commitPut коммитит одну запись отдельной транзакцией.
+func commitPut[K comparable, V any](t *testing.T, m *mvcc.MVCCMap[K, V], key K, value V) {
+	t.Helper()
+	tx := m.BeginTx(context.Background())
+	_ = tx.Put(key, value)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// isRetained сообщает, хранится ли ещё версия, не оставляя закрепления.
+func isRetained[K comparable, V any](m *mvcc.MVCCMap[K, V], versionID uint64) bool {
+	snap, err := m.Pin(versionID)
+	if err != nil {
+		return false
+	}
+	snap.Release()
+	return true
+}
+
+// TestPin_RetainsVersionUntilRelease проверяет, что закреплённая версия
+// переживает множество коммитов и проходов GC, пока её не освободят.
+func TestPin_RetainsVersionUntilRelease(t *testing.T) {
+	m, _ := newTestMap(t)
+
+	commitPut(t, m, "k", 1) // версия 1
+
+	snap, err := m.Pin(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 50 {
+		commitPut(t, m, "k", 100+i)
+	}
+	time.Sleep(150 * time.Millisecond) // несколько проходов GC
+
+	if v, ok := snap.Get("k"); !ok || v != 1 {
+		t.Fatalf("pinned snapshot: Get(k) = %d, %v; want 1, true", v, ok)
+	}
+	if !isRetained(m, 1) {
+		t.Fatal("pinned version was collected")
+	}
+
+	snap.Release()
+	snap.Release() // идемпотентно
+
+	deadline := time.Now().Add(time.Second)
+	for isRetained(m, 1) {
+		if time.Now().After(deadline) {
+			t.Fatal("released version was not collected")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err := m.Pin(1); !errors.Is(err, mvcc.ErrVersionCollected) {
+		t.Errorf("Pin of a collected version: got %v, want ErrVersionCollected", err)
+	}
+}
+
+// TestReduce_ConsistentUnderConcurrentCommits проверяет, что сумма по
+// закреплённому снапшоту согласована, пока конкурентные переводы
+// меняют отдельные значения (общая сумма переводами сохраняется).
+func TestReduce_ConsistentUnderConcurrentCommits(t *testing.T) {
+	m, _ := newTestMap(t)
+	ctx := context.Background()
+
+	const accounts, balance = 100, 1000
+	setup := m.BeginTx(ctx)
+	for i := range accounts {
+		_ = setup.Put(fmt.Sprintf("acc-%d", i), balance)
+	}
+	if err := setup.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			from, to := fmt.Sprintf("acc-%d", i%accounts), fmt.Sprintf("acc-%d", (i+1)%accounts)
+			tx := m.BeginTx(ctx)
+			a, _ := tx.Get(from)
+			b, _ := tx.Get(to)
+			_ = tx.Put(from, a-7)
+			_ = tx.Put(to, b+7)
+			_ = tx.Commit()
+		}
+	}()
+
+	sum := func(acc int, _ string, v int) int { return acc + v }
+	for range 50 {
+		snap := m.CurrentSnapshot()
+		if total := mvcc.Reduce(snap, 0, sum); total != accounts*balance {
+			t.Errorf("snapshot %d: sum = %d, want %d", snap.ID(), total, accounts*balance)
+		}
+		count := mvcc.Reduce(snap, 0, func(n int, _ string, _ int) int { return n + 1 })
+		if count != accounts {
+			t.Errorf("snapshot %d: count = %d, want %d", snap.ID(), count, accounts)
+		}
+		snap.Release()
+	}
+	close(stop)
+	<-done
+}
+
+// TestGetMultiAt_ValuesFromSingleVersion проверяет, что GetMultiAt
+// возвращает значения одной версии, пока конкурентный писатель коммитит
+// все ключи сразу, а отсутствующие ключи в результат не попадают.
+func TestGetMultiAt_ValuesFromSingleVersion(t *testing.T) {
+	m, _ := newTestMap(t)
+	ctx := context.Background()
+
+	keys := []string{"a", "b", "c", "d"}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			tx := m.BeginTx(ctx)
+			for _, k := range keys {
+				_ = tx.Put(k, i)
+			}
+			_ = tx.Commit()
+		}
+	}()
+
+	var prevVersion uint64
+	for range 200 {
+		vals, version := m.GetMultiAt(append(keys, "missing"))
+		if version < prevVersion {
+			t.Fatalf("version went back from %d to %d", prevVersion, version)
+		}
+		prevVersion = version
+		if _, ok := vals["missing"]; ok {
+			t.Fatal("absent key in the result")
+		}
+		if len(vals) == 0 {
+			continue // писатель ещё не закоммитил
+		}
+		if len(vals) != len(keys) {
+			t.Fatalf("version %d: got %v, want all of %v", version, vals, keys)
+		}
+		for _, k := range keys {
+			if vals[k] != vals[keys[0]] {
+				t.Fatalf("version %d: mixed values %v", version, vals)
+			}
+		}
+	}
+	close(stop)
+	<-done
+}
+
+// TestTag_ReadsTaggedVersionUntilDropped проверяет, что тег сохраняет
+// старые значения после новых коммитов и GC, а DropTag освобождает версию.
+func TestTag_ReadsTaggedVersionUntilDropped(t *testing.T) {
+	ctx := context.Background()
+	m := mvcc.NewMVCCMap[string, int](ctx, mvcc.WithManualGC())
+	defer m.Close()
+
+	commitPut(t, m, "k", 1)
+	tagged := m.Tag("report")
+	commitPut(t, m, "k", 2)
+	commitPut(t, m, "k", 3)
+	m.RunGCNow()
+
+	if v, ok, err := m.ReadTag("report", "k"); err != nil || !ok || v != 1 {
+		t.Errorf("ReadTag(report, k) = %d, %v, %v; want 1, true, nil", v, ok, err)
+	}
+	if !isRetained(m, tagged) {
+		t.Error("tagged version was collected by GC")
+	}
+
+	if err := m.DropTag("report"); err != nil {
+		t.Fatalf("DropTag: %v", err)
+	}
+	if _, _, err := m.ReadTag("report", "k"); !errors.Is(err, mvcc.ErrTagNotFound) {
+		t.Errorf("ReadTag after DropTag: got %v, want ErrTagNotFound", err)
+	}
+	m.RunGCNow()
+	if isRetained(m, tagged) {
+		t.Error("version still retained after DropTag")
+	}
+}
+
+// TestAllWithMeta_YieldsWriterOfEachKey проверяет, что AllWithMeta выдаёт
+// для каждого ключа закреплённой версии ID закоммитившей его транзакции
+// и не видит удалённых ключей и более поздних коммитов.
+func TestAllWithMeta_YieldsWriterOfEachKey(t *testing.T) {
+	ctx := context.Background()
+	m := mvcc.NewMVCCMap[string, int](ctx, mvcc.WithManualGC())
+	defer m.Close()
+
+	writers := make(map[string]uint64)
+	for i, key := range []string{"a", "b", "c", "a", "gone"} {
+		tx := m.BeginTx(ctx)
+		_ = tx.Put(key, i)
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		writers[key] = tx.ID()
+	}
+	tx := m.BeginTx(ctx)
+	_ = tx.Delete("gone")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	delete(writers, "gone")
+
+	snap := m.CurrentSnapshot()
+	defer snap.Release()
+	commitPut(t, m, "b", 100) // после снапшота: не должен быть виден
+
+	seen := make(map[string]uint64)
+	for k, meta := range snap.AllWithMeta() {
+		seen[k] = meta.WriterTxID
+		if want, _ := snap.Get(k); meta.Value != want {
+			t.Errorf("%s: Value = %d, want %d", k, meta.Value, want)
+		}
+	}
+	if !maps.Equal(seen, writers) {
+		t.Errorf("writers = %v, want %v", seen, writers)
+	}
+}
+
+// TestDeltaSince_ListsSubsequentChanges проверяет, что дельта от
+// промежуточной версии содержит ровно последующие изменения и удаления,
+// а от собранной GC версии возвращается ErrHistoryGap.
+func TestDeltaSince_ListsSubsequentChanges(t *testing.T) {
+	m, _ := newTestMap(t)
+	ctx := context.Background()
+
+	commitPut(t, m, "a", 1)
+	early := m.CurrentSnapshot()
+	collected := early.ID()
+	early.Release() // не держим: GC соберёт эту версию
+	commitPut(t, m, "b", 1)
+	commitPut(t, m, "c", 1)
+	base := m.CurrentSnapshot()
+	defer base.Release()
+
+	commitPut(t, m, "a", 2)
+	tx := m.BeginTx(ctx)
+	_ = tx.Delete("b")
+	_ = tx.Put("d", 4)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	commitPut(t, m, "a", 3)
+
+	changed, deleted, version, err := m.DeltaSince(base.ID())
+	if err != nil {
+		t.Fatalf("DeltaSince: %v", err)
+	}
+	if want := map[string]int{"a": 3, "d": 4}; !maps.Equal(changed, want) {
+		t.Errorf("changed = %v, want %v", changed, want)
+	}
+	if len(deleted) != 1 || deleted[0] != "b" {
+		t.Errorf("deleted = %v, want [b]", deleted)
+	}
+	cur := m.CurrentSnapshot()
+	if version != cur.ID() {
+		t.Errorf("version = %d, want current %d", version, cur.ID())
+	}
+	cur.Release()
+
+	if changed, deleted, _, err := m.DeltaSince(version); err != nil || len(changed) != 0 || len(deleted) != 0 {
+		t.Errorf("DeltaSince(current) = %v, %v, %v; want empty", changed, deleted, err)
+	}
+
+	m.RunGCNow()
+	if _, _, _, err := m.DeltaSince(collected); !errors.Is(err, mvcc.ErrHistoryGap) {
+		t.Errorf("DeltaSince(collected) = %v, want ErrHistoryGap", err)
+	}
+}
+
+// TestExportImport_ZeroValueDistinctFromAbsent проверяет, что ключ,
+// явно записанный нулевым значением, переживает экспорт через AllWithMeta
+// и импорт через CompareAndReplace, а отсутствующий и удалённый ключи
+// не появляются в экспорте нулевыми записями.
+func TestExportImport_ZeroValueDistinctFromAbsent(t *testing.T) {
+	ctx := context.Background()
+	src := mvcc.NewMVCCMap[string, int](ctx)
+	defer src.Close()
+
+	commitPut(t, src, "zero", 0)
+	commitPut(t, src, "one", 1)
+	commitPut(t, src, "gone", 5)
+	tx := src.BeginTx(ctx)
+	_ = tx.Delete("gone")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := src.CurrentSnapshot()
+	exported := make(map[string]int)
+	for k, meta := range snap.AllWithMeta() {
+		exported[k] = meta.Value
+	}
+	snap.Release()
+	if want := map[string]int{"zero": 0, "one": 1}; !maps.Equal(exported, want) {
+		t.Fatalf("exported %v, want %v", exported, want)
+	}
+	if got, _ := src.GetMultiAt([]string{"zero", "absent", "gone"}); !maps.Equal(got, map[string]int{"zero": 0}) {
+		t.Errorf("GetMultiAt = %v, want only the zero-valued key", got)
+	}
+
+	dst := mvcc.NewMVCCMap[string, int](ctx)
+	defer dst.Close()
+	base := dst.CurrentSnapshot()
+	baseID := base.ID()
+	base.Release()
+	if _, err := dst.CompareAndReplace(baseID, exported); err != nil {
+		t.Fatal(err)
+	}
+
+	check := dst.BeginTx(ctx)
+	defer check.Rollback()
+	if v, ok := check.Get("zero"); !ok || v != 0 {
+		t.Errorf("imported zero = %d, %v; want 0, true", v, ok)
+	}
+	for _, k := range []string{"absent", "gone"} {
+		if check.Has(k) {
+			t.Errorf("imported map has %q, which was not present in the source", k)
+		}
+	}
+}
+
+// TestSnapshot_ReleaseConcurrentWithReads проверяет, что Release из другой
+// горутины посреди обхода вместе с GC и WithDataMapPool не подменяет данные
+// под читателем: обход дочитывает версию, а Get после Release видит либо её
+// значение, либо (zero, false). Гонку ловит -race.
+func TestSnapshot_ReleaseConcurrentWithReads(t *testing.T) {
+	ctx := context.Background()
+	m := mvcc.NewMVCCMap[string, int](ctx, mvcc.WithManualGC(), mvcc.WithDataMapPool(true))
+	defer m.Close()
+
+	// Крупная карта растягивает обход, чтобы сборка приходилась на его середину.
+	seed := m.BeginTx(ctx)
+	for i := range 2000 {
+		_ = seed.Put(fmt.Sprintf("seed-%d", i), i)
+	}
+	if err := seed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 50 {
+		commitPut(t, m, "k", i)
+		snap := m.CurrentSnapshot()
+
+		// Release приходится на середину обхода: читатель сигналит
+		// с первого элемента и продолжает читать.
+		started := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			first := true
+			for k, meta := range snap.AllWithMeta() {
+				if first {
+					first = false
+					close(started)
+				}
+				if k == "k" && meta.Value != i {
+					t.Errorf("iteration %d: AllWithMeta returned %d from a recycled map", i, meta.Value)
+				}
+			}
+			if first {
+				close(started)
+			}
+			if v, ok := snap.Get("k"); ok && v != i {
+				t.Errorf("iteration %d: Get returned %d from a recycled map", i, v)
+			}
+		}()
+		<-started
+		snap.Release()
+		commitPut(t, m, "k", -1)
+		m.RunGCNow()
+		<-done
+	}
+}
@@ -0,0 +1,89 @@
+package mvcc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats — счётчики MVCCMap для метрик и тюнинга.
+type Stats struct {
+	// Commits — число коммитов, прошедших через критическую секцию m.mu.
+	Commits uint64
+
+	// MaxCommitLockHeldNanos и AvgCommitLockHeldNanos — время удержания
+	// m.mu одним коммитом. При больших write set'ах его доминирует clone.
+	MaxCommitLockHeldNanos int64
+	AvgCommitLockHeldNanos int64
+
+	// CommitMutexContended — сколько раз коммиту пришлось ждать m.mu,
+	// потому что его держал другой коммит. Высокая доля от Commits
+	// говорит о том, что писатели упираются в единый мьютекс.
+	CommitMutexContended uint64
+
+	// WatchDropped — события WatchKeys, отброшенные из-за переполнения
+	// буфера медленного подписчика.
+	WatchDropped uint64
+
+	// AsyncHookDropped — коммиты, не доставленные WithAsyncCommitHook
+	// из-за переполнения очереди при OverflowDrop.
+	AsyncHookDropped uint64
+
+	// BackgroundPanics — паники, перехваченные в проходах GC и детектора
+	// дедлоков, фоновых и ручных (RunGCNow, GCAggressive, WithInlineGC,
+	// DetectDeadlocksNow). Цикл после паники продолжает работу, но
+	// ненулевое значение означает ошибку, которую стоит искать в логах.
+	BackgroundPanics uint64
+
+	// TombstonesPurged — tombstone'ы, удалённые из новых версий после
+	// того, как их перестали требовать снапшоты активных читателей.
+	TombstonesPurged uint64
+
+	// Closed — map закрыта: фоновый GC остановлен, и счётчики больше
+	// не меняются сами по себе.
+	Closed bool
+}
+
+// mapStats хранит сырые счётчики. Атомики — чтобы Stats() не брал m.mu
+// и не добавлял contention коммитам, которые он измеряет.
+type mapStats struct {
+	commits       atomic.Uint64
+	lockHeldTotal atomic.Int64
+	lockHeldMaxNs atomic.Int64
+	contended     atomic.Uint64
+	watchDropped  atomic.Uint64
+	asyncDropped  atomic.Uint64
+
+	backgroundPanics atomic.Uint64
+	tombstonesPurged atomic.Uint64
+}
+
+// recordCommitLock вызывается под m.mu, поэтому обновление максимума
+// не требует CAS-цикла: конкурентных писателей нет.
+func (s *mapStats) recordCommitLock(d time.Duration) {
+	ns := d.Nanoseconds()
+	s.commits.Add(1)
+	s.lockHeldTotal.Add(ns)
+	if ns > s.lockHeldMaxNs.Load() {
+		s.lockHeldMaxNs.Store(ns)
+	}
+}
+
+// Stats возвращает снимок счётчиков. Поля читаются независимо,
+// поэтому между ними возможна небольшая рассинхронизация.
+// Безопасна после Close: возвращает последнее состояние с Closed == true.
+func (m *MVCCMap[K, V]) Stats() Stats {
+	s := Stats{
+		Commits:                m.stats.commits.Load(),
+		MaxCommitLockHeldNanos: m.stats.lockHeldMaxNs.Load(),
+		CommitMutexContended:   m.stats.contended.Load(),
+		WatchDropped:           m.stats.watchDropped.Load(),
+		AsyncHookDropped:       m.stats.asyncDropped.Load(),
+		BackgroundPanics:       m.stats.backgroundPanics.Load(),
+		TombstonesPurged:       m.stats.tombstonesPurged.Load(),
+		Closed:                 m.closed.Load(),
+	}
+	if s.Commits > 0 {
+		s.AvgCommitLockHeldNanos = m.stats.lockHeldTotal.Load() / int64(s.Commits)
+	}
+	return s
+}
@@ -0,0 +1,61 @@
+package mvcc
+
+import "maps"
+
+// Entry — запись версии в Store: значение вместе с метаданными MVCC
+// (писатель, tombstone, время коммита). Поля не экспортируются: бэкенд
+// только хранит записи и не интерпретирует их.
+type Entry[V any] = versionedValue[V]
+
+// Store — представление данных одной версии. Позволяет подменить
+// встроенную хеш-карту упорядоченным деревом, персистентной структурой
+// и т. п. без форка (WithStore).
+//
+// Контракт:
+//   - Set и Delete вызываются только на свежем Clone, ещё не
+//     опубликованном как версия, и из одной горутины;
+//   - после публикации Store только читается (Get, Len, Iterate),
+//     в том числе из многих горутин одновременно;
+//   - Clone возвращает независимую копию: её изменения не видны
+//     в исходном Store. Персистентные бэкенды могут разделять структуру.
+type Store[K comparable, V any] interface {
+	Get(key K) (Entry[V], bool)
+	Set(key K, e Entry[V])
+	Delete(key K)
+	Clone() Store[K, V]
+	// Len возвращает число записей, включая tombstone'ы.
+	Len() int
+	// Iterate обходит записи в порядке бэкенда, пока fn возвращает true.
+	// Совместим с range-over-func: for k, e := range s.Iterate.
+	Iterate(fn func(key K, e Entry[V]) bool)
+}
+
+// mapStore — Store по умолчанию поверх встроенной map.
+type mapStore[K comparable, V any] map[K]versionedValue[V]
+
+func newMapStore[K comparable, V any]() Store[K, V] {
+	return make(mapStore[K, V])
+}
+
+func (s mapStore[K, V]) Get(key K) (Entry[V], bool) {
+	e, ok := s[key]
+	return e, ok
+}
+
+func (s mapStore[K, V]) Set(key K, e Entry[V]) { s[key] = e }
+
+func (s mapStore[K, V]) Delete(key K) { delete(s, key) }
+
+// Clone — shallow copy: V трактуется как value type (или неизменяемый
+// указатель), см. version.clone.
+func (s mapStore[K, V]) Clone() Store[K, V] { return maps.Clone(s) }
+
+func (s mapStore[K, V]) Len() int { return len(s) }
+
+func (s mapStore[K, V]) Iterate(fn func(key K, e Entry[V]) bool) {
+	for k, e := range s {
+		if !fn(k, e) {
+			return
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package mvcc_test
+
+import (
+	"cmp"
+	"context"
+	"math/rand/v2"
+	"mvcc-map/mvcc"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// sortedStore — упорядоченный бэкенд на паре отсортированных слайсов:
+// вторая реализация mvcc.Store для проверки взаимозаменяемости.
+type sortedStore[K cmp.Ordered, V any] struct {
+	keys    []K
+	entries []mvcc.Entry[V]
+}
+
+func newSortedStore[K cmp.Ordered, V any]() mvcc.Store[K, V] {
+	return &sortedStore[K, V]{}
+}
+
+func (s *sortedStore[K, V]) Get(key K) (mvcc.Entry[V], bool) {
+	if i, ok := slices.BinarySearch(s.keys, key); ok {
+		return s.entries[i], true
+	}
+	var zero mvcc.Entry[V]
+	return zero, false
+}
+
+func (s *sortedStore[K, V]) Set(key K, e mvcc.Entry[V]) {
+	i, ok := slices.BinarySearch(s.keys, key)
+	if ok {
+		s.entries[i] = e
+		return
+	}
+	s.keys = slices.Insert(s.keys, i, key)
+	s.entries = slices.Insert(s.entries, i, e)
+}
+
+func (s *sortedStore[K, V]) Delete(key K) {
+	if i, ok := slices.BinarySearch(s.keys, key); ok {
+		s.keys = slices.Delete(s.keys, i, i+1)
+		s.entries = slices.Delete(s.entries, i, i+1)
+	}
+}
+
+func (s *sortedStore[K, V]) Clone() mvcc.Store[K, V] {
+	return &sortedStore[K, V]{keys: slices.Clone(s.keys), entries: slices.Clone(s.entries)}
+}
+
+func (s *sortedStore[K, V]) Len() int { return len(s.keys) }
+
+func (s *sortedStore[K, V]) Iterate(fn func(K, mvcc.Entry[V]) bool) {
+	for i, k := range s.keys {
+		if !fn(k, s.entries[i]) {
+			return
+		}
+	}
+}
+
+// storeBackends — бэкенды хранения версий, на которых прогоняются тесты map.
+var storeBackends = []string{"map", "sorted"}
+
+// forEachStore прогоняет test подтестом на каждом бэкенде из storeBackends.
+func forEachStore(t *testing.T, test func(t *testing.T, backend string)) {
+	for _, backend := range storeBackends {
+		t.Run(backend, func(t *testing.T) { test(t, backend) })
+	}
+}
+
+// withStore дополняет opts бэкендом backend: "map" — встроенная map,
+// "sorted" — WithStore(sortedStore).
+func withStore[K cmp.Ordered, V any](backend string, opts ...mvcc.Option) []mvcc.Option {
+	switch backend {
+	case "map":
+		return opts
+	case "sorted":
+		return append([]mvcc.Option{mvcc.WithStore(newSortedStore[K, V])}, opts...)
+	}
+	panic("unknown store backend " + backend)
+}
+
+// TestStore_RandomAgainstModel проверяет на каждом бэкенде, что
+// случайная последовательность коммитов, откатов и проходов GC даёт
+// то же состояние, что и простая модель на map.
+func TestStore_RandomAgainstModel(t *testing.T) {
+	forEachStore(t, func(t *testing.T, backend string) {
+		ctx := context.Background()
+		m := mvcc.NewMVCCMap[string, int](ctx, withStore[string, int](backend, mvcc.WithManualGC())...)
+		defer m.Close()
+		rng := rand.New(rand.NewPCG(1, 2))
+		model := make(map[string]int)
+
+		for range 300 {
+			tx := m.BeginTx(ctx)
+			staged := make(map[string]int)
+			var deleted []string
+			for range 1 + rng.IntN(4) {
+				k := strconv.Itoa(rng.IntN(20))
+				if rng.IntN(3) == 0 {
+					_ = tx.Delete(k)
+					delete(staged, k)
+					deleted = append(deleted, k)
+				} else {
+					v := rng.IntN(1000)
+					_ = tx.Put(k, v)
+					staged[k] = v
+					deleted = slices.DeleteFunc(deleted, func(d string) bool { return d == k })
+				}
+			}
+			if rng.IntN(4) == 0 {
+				tx.Rollback()
+				continue
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range deleted {
+				delete(model, k)
+			}
+			for k, v := range staged {
+				model[k] = v
+			}
+			if rng.IntN(10) == 0 {
+				m.RunGCNow()
+			}
+		}
+
+		snap := m.CurrentSnapshot()
+		defer snap.Release()
+		got := make(map[string]int)
+		for k, meta := range snap.AllWithMeta() {
+			got[k] = meta.Value
+		}
+		if len(got) != len(model) {
+			t.Fatalf("map has %d keys, model %d", len(got), len(model))
+		}
+		for k, v := range model {
+			if got[k] != v {
+				t.Errorf("%s = %d, model %d", k, got[k], v)
+			}
+		}
+	})
+}
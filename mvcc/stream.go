@@ -0,0 +1,73 @@
+package mvcc
+
+import (
+	"fmt"
+	"io"
+)
+
+// PutStream откладывает запись key до Commit: значение вычитывается из r
+// целиком только при коммите (или при первом чтении key этой
+// транзакцией), а не при вызове. Для больших blob'ов транзакция не держит
+// их в write buffer всё время своей жизни — пиковая память ограничена
+// моментом коммита. r принадлежит транзакции до Commit/Rollback.
+//
+// Ошибка чтения r проваливает Commit, а Get ключа после неудачного
+// чтения видит значение снапшота. Put/Delete key после PutStream
+// отменяют поток.
+func PutStream[K comparable](tx *Tx[K, []byte], key K, r io.Reader) error {
+	return tx.stageStream(key, func() ([]byte, error) { return io.ReadAll(r) })
+}
+
+// stageStream — общая часть PutStream: проверки как у stage и запись
+// отложенного значения в tx.streams.
+func (tx *Tx[K, V]) stageStream(key K, read func() (V, error)) error {
+	if err := tx.checkActive(); err != nil {
+		return err
+	}
+	if err := tx.ctxErr(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	key = tx.db.normalizeKey(key)
+
+	delete(tx.writes, key)
+	delete(tx.merges, key)
+	delete(tx.decoded, key)
+	if tx.streams == nil {
+		tx.streams = make(map[K]func() (V, error))
+	}
+	tx.streams[key] = read
+	return nil
+}
+
+// materializeStream вычитывает отложенное значение key, если оно есть,
+// и переносит его в write buffer. Первая ошибка чтения запоминается
+// и возвращается из Commit.
+func (tx *Tx[K, V]) materializeStream(key K) {
+	read, ok := tx.streams[key]
+	if !ok {
+		return
+	}
+	delete(tx.streams, key)
+	v, err := read()
+	if err != nil {
+		if tx.streamErr == nil {
+			tx.streamErr = fmt.Errorf("mvcc: stream for key %v: %w", key, err)
+		}
+		return
+	}
+	if err := tx.Put(key, v); err != nil && tx.streamErr == nil {
+		tx.streamErr = err
+	}
+}
+
+// flushStreams материализует все отложенные значения перед Commit.
+func (tx *Tx[K, V]) flushStreams() error {
+	for key := range tx.streams {
+		tx.materializeStream(key)
+	}
+	return tx.streamErr
+}
@@ -0,0 +1,65 @@
+package mvcc
+
+import "slices"
+
+// tombstoneRef — tombstone, ожидающий удаления из будущих версий:
+// ключ, его writer и версия, в которой он появился.
+type tombstoneRef[K comparable] struct {
+	key    K
+	writer uint64
+	vid    uint64
+}
+
+// trackTombstone ставит tombstone версии vid в очередь на удаление.
+// Вызывается под m.mu в порядке версий, поэтому очередь отсортирована по vid.
+func (m *MVCCMap[K, V]) trackTombstone(key K, writer, vid uint64) {
+	m.tombstones = append(m.tombstones, tombstoneRef[K]{key: key, writer: writer, vid: vid})
+}
+
+// purgeTombstones удаляет из данных будущей версии tombstone'ы, которые
+// больше никому не нужны. Вызывается под m.mu перед installVersion.
+//
+// Tombstone версии N нужен, пока есть снапшот старше N: его транзакция
+// видит ключ живым, и только tombstone выдаёт конфликт её записи с
+// удалением. Когда все закреплённые версии не старше N (а новые снапшоты
+// берутся не раньше текущей), каждый снапшот уже содержит этот tombstone,
+// и его отсутствие в новых версиях неотличимо от "ключ удалён и с тех пор
+// не менялся" — см. version.changedSince. Старые версии не меняются:
+// читатели на них продолжают видеть прежнее состояние.
+func (m *MVCCMap[K, V]) purgeTombstones(data Store[K, V]) {
+	if len(m.tombstones) == 0 {
+		return
+	}
+	watermark := m.minPinnedVersion()
+
+	n := 0
+	for _, t := range m.tombstones {
+		if t.vid > watermark {
+			break
+		}
+		n++
+		// Ключ могли перезаписать после удаления — тогда tombstone'а уже нет.
+		if vv, ok := data.Get(t.key); ok && vv.deleted && vv.writerTxID == t.writer {
+			data.Delete(t.key)
+			m.stats.tombstonesPurged.Add(1)
+		}
+	}
+	m.tombstones = slices.Delete(m.tombstones, 0, n)
+}
+
+// minPinnedVersion возвращает ID самой старой закреплённой версии
+// (снапшоты транзакций, Snapshot, теги) или текущей, если закреплённых нет.
+//
+// Гонки с acquireCurrent нет: вызывается под m.mu, а транзакция, закрепившая
+// версию после нашей проверки refCount, перепроверяет current и, увидев
+// более новую, закрепляет её.
+func (m *MVCCMap[K, V]) minPinnedVersion() uint64 {
+	m.versionsMu.Lock()
+	defer m.versionsMu.Unlock()
+	for _, v := range m.versions { // versions упорядочены по ID
+		if v.refCount.Load() > 0 {
+			return v.id
+		}
+	}
+	return m.currentVersionID()
+}
@@ -0,0 +1,86 @@
+package mvcc
+
+import "slices"
+
+// TraceEventKind — тип события трассы транзакции (TxOptions.Trace).
+type TraceEventKind uint8
+
+const (
+	// TraceRead — чтение ключа через Get, Has и производные от них.
+	TraceRead TraceEventKind = iota
+	// TraceWriteCheck — проверка записанного ключа на write-write
+	// конфликт при коммите.
+	TraceWriteCheck
+	// TraceReadCheck — проверка прочитанного ключа при коммите
+	// под Serializable.
+	TraceReadCheck
+)
+
+// ReadSource — откуда чтение получило значение.
+type ReadSource uint8
+
+const (
+	// ReadFromSnapshot — из снапшота транзакции.
+	ReadFromSnapshot ReadSource = iota
+	// ReadFromBuffer — из write buffer (read-your-own-writes).
+	ReadFromBuffer
+	// ReadFromMerge — значение снапшота с применёнными отложенными Merge.
+	ReadFromMerge
+)
+
+// TraceEvent — одно событие трассы транзакции. Для TraceRead заполнены
+// Value, Found, Source и InReadSet; для проверок при коммите — Conflict
+// и Reason.
+type TraceEvent[K comparable, V any] struct {
+	Kind TraceEventKind
+	Key  K
+
+	Value V
+	Found bool
+	// Source — откуда прочитано значение.
+	Source ReadSource
+	// InReadSet — ключ записан в read set и будет проверен при коммите
+	// под Serializable.
+	InReadSet bool
+
+	// Conflict — решение проверки: ключ конфликтует.
+	Conflict bool
+	// Reason — почему принято это решение.
+	Reason string
+}
+
+// Trace возвращает события, записанные с TxOptions.Trace: каждое чтение
+// и каждое решение проверки конфликтов при коммите, в порядке записи.
+// Доступна и после Commit/Rollback; без TxOptions.Trace пуста.
+func (tx *Tx[K, V]) Trace() []TraceEvent[K, V] {
+	return slices.Clone(tx.trace)
+}
+
+// traceRead записывает чтение ключа, если трасса включена.
+func (tx *Tx[K, V]) traceRead(key K, value V, found bool, src ReadSource) {
+	if !tx.opts.Trace {
+		return
+	}
+	_, inReadSet := tx.readSet[key]
+	tx.trace = append(tx.trace, TraceEvent[K, V]{
+		Kind:      TraceRead,
+		Key:       key,
+		Value:     value,
+		Found:     found,
+		Source:    src,
+		InReadSet: inReadSet,
+	})
+}
+
+// traceCheck записывает решение проверки конфликтов, если трасса включена.
+func (tx *Tx[K, V]) traceCheck(kind TraceEventKind, key K, conflict bool, reason string) {
+	if !tx.opts.Trace {
+		return
+	}
+	tx.trace = append(tx.trace, TraceEvent[K, V]{
+		Kind:     kind,
+		Key:      key,
+		Conflict: conflict,
+		Reason:   reason,
+	})
+}
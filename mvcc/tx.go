@@ -4,16 +4,55 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Sentinel errors для типизированной обработки на стороне вызывающего.
 var (
-	ErrConflict   = errors.New("mvcc: write-write conflict")
-	ErrTxDone     = errors.New("mvcc: transaction already completed")
-	ErrDeadlock   = errors.New("mvcc: deadlock detected")
-	ErrTxCanceled = errors.New("mvcc: transaction canceled by context")
+	ErrConflict         = errors.New("mvcc: write-write conflict")
+	ErrTxDone           = errors.New("mvcc: transaction already completed")
+	ErrDeadlock         = errors.New("mvcc: deadlock detected")
+	ErrTxCanceled       = errors.New("mvcc: transaction canceled by context")
+	ErrVersionCollected = errors.New("mvcc: version already collected")
+	ErrClosed           = errors.New("mvcc: map is closed")
+	ErrCommitHookFailed = errors.New("mvcc: commit hook failed")
+	ErrKeyNotFound      = errors.New("mvcc: key not found")
+	ErrTxActive         = errors.New("mvcc: transaction is still active")
+	ErrDuplicateKey     = errors.New("mvcc: duplicate key in batch")
+	ErrTxHasWrites      = errors.New("mvcc: transaction has staged writes")
+	ErrReadOnlyTx       = errors.New("mvcc: write in a read-only transaction")
+	ErrOutOfOrderApply  = errors.New("mvcc: replicated commit applied out of order")
+	ErrTagNotFound      = errors.New("mvcc: tag not found")
+	ErrUnhealthy        = errors.New("mvcc: map is unhealthy")
+	ErrTxTooOld         = errors.New("mvcc: transaction aborted as too old")
+	ErrKeyExists        = errors.New("mvcc: key already exists")
+	ErrCommitRejected   = errors.New("mvcc: commit rejected by validator")
+
+	// ErrReadValidation — конфликт сериализуемой валидации read set'а.
+	// Оборачивает ErrConflict, поэтому errors.Is(err, ErrConflict) тоже true.
+	ErrReadValidation = fmt.Errorf("%w: read validation failed", ErrConflict)
+
+	// ErrHistoryGap — DeltaSince не может построить дельту: базовая версия
+	// уже собрана GC. Оборачивает ErrVersionCollected.
+	ErrHistoryGap = fmt.Errorf("%w: history gap", ErrVersionCollected)
+)
+
+// IsolationLevel задаёт уровень изоляции транзакций.
+type IsolationLevel int
+
+const (
+	// SnapshotIsolation — уровень по умолчанию: проверяются только
+	// write-write конфликты, write skew возможен.
+	SnapshotIsolation IsolationLevel = iota
+
+	// Serializable дополнительно валидирует read set при Commit:
+	// если ключ, прочитанный транзакцией, изменён после её снапшота,
+	// коммит отклоняется. Это устраняет write skew.
+	Serializable
 )
 
 // txState описывает жизненный цикл транзакции конечным автоматом:
@@ -26,6 +65,49 @@ const (
 	txRolledBack txState = 2
 )
 
+// TxOptions — параметры отдельной транзакции для BeginTxWith.
+type TxOptions struct {
+	// EagerConflictCheck заставляет Put/Delete сразу сверяться с текущей
+	// версией и возвращать ErrConflict, если ключ уже изменён после снапшота.
+	// Полезно интерактивным приложениям, которым нужен ранний сигнал.
+	EagerConflictCheck bool
+
+	// ChunkedCommit включает коммит огромных write set'ов с ограниченным
+	// удержанием мьютекса: новая версия собирается вне m.mu порциями
+	// по ChunkSize ключей (0 — defaultCommitChunkSize). См. commitChunked.
+	ChunkedCommit bool
+	ChunkSize     int
+
+	// Label — человекочитаемое имя логической операции ("transfer-funds").
+	// Попадает в логи дедлоков и конфликтов и в тексты ошибок ErrConflict,
+	// чтобы по ним было видно, какая операция пострадала.
+	Label string
+
+	// Priority учитывается при выборе жертвы дедлока стратегией
+	// LowestPriority: прерывается транзакция с наименьшим значением.
+	Priority int
+
+	// FullConflictReport — WithFullConflictReport для одной транзакции:
+	// валидация собирает все конфликтующие ключи, а не только первый.
+	FullConflictReport bool
+
+	// Group — группа справедливого планирования коммитов (например,
+	// арендатор) для WithFairCommitScheduling. Не связана с WithGroupCommit.
+	Group string
+
+	// Linearizable гарантирует, что снапшот транзакции включает все
+	// коммиты, подтверждённые до вызова BeginTx: если текущая версия ещё
+	// не дошла до последней подтверждённой, BeginTx ждёт её. На одном узле
+	// это совпадает с обычным поведением (Commit возвращается после
+	// установки версии), но делает гарантию явной — для будущих
+	// распределённых вариантов, где подтверждение и видимость разойдутся.
+	Linearizable bool
+
+	// Trace включает запись чтений и решений проверки конфликтов
+	// (см. Tx.Trace). Дорого: только для отладки аномалий.
+	Trace bool
+}
+
 // Tx — транзакция с snapshot isolation.
 // Читает из снапшота момента BeginTx, накапливает изменения локально,
 // при Commit атомарно применяет их к глобальному состоянию.
@@ -37,9 +119,28 @@ type Tx[K comparable, V any] struct {
 	id       uint64
 	snapshot *version[K, V]          // снапшот на момент BeginTx (read-only)
 	writes   map[K]versionedValue[V] // локальный write buffer
+	merges   map[K]MergeFunc[V]      // отложенные Merge, разрешаются при коммите
+	streams  map[K]func() (V, error) // отложенные PutStream, читаются при коммите
+	decoded  map[K]V                 // значения снапшота после decode (WithValueCodec)
 	readSet  map[K]struct{}          // ключи, которые мы читали (для будущего SI extension)
+	beginTS  uint64                  // логическое время начала (только с WithCommitTimestamps)
+	begunAt  time.Time               // wall-clock BeginTx (AbortOlderThan, WithLatencyTracking)
+	opts     TxOptions
+
+	strictReads bool // WithStrictReads на момент BeginTx
+	readOnly    bool // SetReadOnly: записи запрещены
+	bump        bool // CommitBump: новая версия даже без записей
+
+	state   atomic.Uint32 // txState, атомик для безопасного чтения из detectDeadlocks
+	meta    *txMeta       // метаданные текущего воплощения (Reset создаёт новые)
+	doneErr error         // причина завершения: doneTx или принудительный откат
+
+	streamErr error // первая ошибка чтения PutStream, проваливает Commit
 
-	state atomic.Uint32 // txState, атомик для безопасного чтения из detectDeadlocks
+	commitVID uint64             // версия, установленная коммитом (CommitDetailed)
+	conflicts []KeyConflict[K]   // конфликты последнего Commit (CommitDetailed)
+	merged    []K                // ключи Merge, изменённые после снапшота (CommitDetailed)
+	trace     []TraceEvent[K, V] // события TxOptions.Trace
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -47,71 +148,674 @@ type Tx[K comparable, V any] struct {
 	db *MVCCMap[K, V] // ссылка для Commit/Rollback
 }
 
+// ID возвращает идентификатор транзакции (тот же, что в логах и колбэках).
+func (tx *Tx[K, V]) ID() uint64 {
+	return tx.id
+}
+
+// Label возвращает TxOptions.Label, с которым начата транзакция.
+func (tx *Tx[K, V]) Label() string {
+	return tx.opts.Label
+}
+
+// SnapshotLag возвращает, на сколько версий снапшот транзакции отстал
+// от текущей версии map: 0 — транзакция видит последние данные. Долгий
+// читатель может по нему решить, пора ли обновиться через NewStatement.
+func (tx *Tx[K, V]) SnapshotLag() uint64 {
+	return tx.db.currentVersionID() - tx.snapshot.id
+}
+
+// labelSuffix дополняет текст ошибки меткой транзакции, если она задана.
+func (tx *Tx[K, V]) labelSuffix() string {
+	if tx.opts.Label == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (tx %d %q)", tx.id, tx.opts.Label)
+}
+
 // Get возвращает значение ключа, видимое в рамках снапшота транзакции.
 // Write buffer имеет приоритет (read-your-own-writes семантика).
 func (tx *Tx[K, V]) Get(key K) (V, bool) {
+	v, ok := tx.get(key)
+	if !ok && tx.strictReads && txState(tx.state.Load()) == txActive {
+		// Сам ключ может быть чувствительным, поэтому в лог идёт только тип.
+		tx.db.logger.Warn("Get of an absent key in strict mode, use GetStrict",
+			"txID", tx.id,
+			"keyType", fmt.Sprintf("%T", key),
+		)
+	}
+	return v, ok
+}
+
+// get — общая часть Get для методов, которые сами обрабатывают
+// отсутствие ключа (GetOr, GetAllInto, GetCtx): строгий режим их не касается.
+func (tx *Tx[K, V]) get(key K) (V, bool) {
 	if err := tx.checkActive(); err != nil {
 		var zero V
 		return zero, false
 	}
+	v, ok := tx.lookup(key)
+	if ok && tx.db.access != nil {
+		tx.db.access.touch(tx.db.normalizeKey(key))
+	}
+	return v, ok
+}
 
-	// Сначала смотрим в локальный write buffer — транзакция видит
-	// собственные изменения ещё до коммита.
+// GetCtx — как Get, но с контекстом отдельного вызова: возвращает
+// ErrTxCanceled, если отменён контекст транзакции (транзакция при этом
+// откатывается, как при Put) или ctx (транзакция остаётся активной).
+// Позволяет прервать конкретное долгое сканирование, не теряя транзакцию.
+func (tx *Tx[K, V]) GetCtx(ctx context.Context, key K) (V, bool, error) {
+	var zero V
+	if err := tx.checkActive(); err != nil {
+		return zero, false, err
+	}
+	if err := tx.ctxErr(); err != nil {
+		tx.Rollback()
+		return zero, false, err
+	}
+	if err := ctx.Err(); err != nil {
+		return zero, false, fmt.Errorf("%w: %w", ErrTxCanceled, context.Cause(ctx))
+	}
+	v, ok := tx.get(key)
+	return v, ok, nil
+}
+
+// GetStrict — как Get, но отсутствие ключа — явная ошибка ErrKeyNotFound,
+// а не (zero, false), который легко случайно проигнорировать.
+// Возвращает также ошибку завершённой транзакции, в том числе если она
+// откатилась при материализации отложенного PutStream.
+func (tx *Tx[K, V]) GetStrict(key K) (V, error) {
+	var zero V
+	if err := tx.checkActive(); err != nil {
+		return zero, err
+	}
+	v, ok := tx.get(key)
+	if !ok {
+		if err := tx.checkActive(); err != nil {
+			return zero, err
+		}
+		return zero, fmt.Errorf("%w: %v", ErrKeyNotFound, key)
+	}
+	return v, nil
+}
+
+// Has сообщает, виден ли ключ в транзакции (с учётом write buffer и
+// tombstone'ов), не материализуя значение: decode из WithValueCodec не
+// вызывается. Ключ записывается в readSet так же, как при Get.
+// Отложенный PutStream считается записью ключа: поток не читается.
+func (tx *Tx[K, V]) Has(key K) bool {
+	if err := tx.checkActive(); err != nil {
+		return false
+	}
+	key = tx.db.normalizeKey(key)
+	tx.readSet[key] = struct{}{}
+
+	// Has не материализует значение — в трассе оно всегда нулевое.
+	var zero V
+	if _, ok := tx.streams[key]; ok {
+		tx.traceRead(key, zero, true, ReadFromBuffer)
+		return true
+	}
 	if vv, ok := tx.writes[key]; ok {
-		tx.readSet[key] = struct{}{}
-		return vv.value, true
+		tx.traceRead(key, zero, !vv.deleted, ReadFromBuffer)
+		return !vv.deleted
+	}
+	if _, ok := tx.merges[key]; ok {
+		tx.traceRead(key, zero, true, ReadFromMerge)
+		return true
+	}
+	vv, ok := tx.snapshot.data.Get(key)
+	tx.traceRead(key, zero, ok && !vv.deleted, ReadFromSnapshot)
+	return ok && !vv.deleted
+}
+
+// GetOr возвращает значение ключа или def, если ключа нет
+// (в том числе если он удалён в снапшоте или в write buffer).
+// Ключ записывается в readSet так же, как при Get.
+func (tx *Tx[K, V]) GetOr(key K, def V) V {
+	if v, ok := tx.get(key); ok {
+		return v
+	}
+	return def
+}
+
+// GetAllInto заполняет dst значениями присутствующих ключей из keys.
+// Позволяет переиспользовать заранее выделенную map между опросами.
+//
+// Если deleteAbsent == true, отсутствующие ключи удаляются из dst
+// (убирая значения, оставшиеся от предыдущего опроса); иначе dst
+// для них не трогается.
+func (tx *Tx[K, V]) GetAllInto(keys []K, dst map[K]V, deleteAbsent bool) {
+	for _, key := range keys {
+		if v, ok := tx.get(key); ok {
+			dst[key] = v
+		} else if deleteAbsent {
+			delete(dst, key)
+		}
 	}
+}
 
-	// Затем — снапшот момента BeginTx.
-	if vv, ok := tx.snapshot.data[key]; ok {
-		tx.readSet[key] = struct{}{}
+// lookup читает ключ из объединённого представления и записывает его в readSet.
+//
+// Сначала смотрим в локальный write buffer — транзакция видит
+// собственные изменения ещё до коммита. Затем — снапшот момента BeginTx.
+// Tombstone в любом из слоёв означает, что ключа нет.
+//
+// Материализация PutStream идёт через Put и может откатить транзакцию
+// (например, при отменённом контексте) — тогда ключ считается отсутствующим.
+func (tx *Tx[K, V]) lookup(key K) (V, bool) {
+	key = tx.db.normalizeKey(key)
+	tx.materializeStream(key)
+	if tx.checkActive() != nil {
+		var zero V
+		return zero, false
+	}
+	tx.readSet[key] = struct{}{}
+
+	if vv, ok := tx.writes[key]; ok {
+		if vv.deleted {
+			var zero V
+			tx.traceRead(key, zero, false, ReadFromBuffer)
+			return zero, false
+		}
+		tx.traceRead(key, vv.value, true, ReadFromBuffer)
 		return vv.value, true
 	}
 
-	var zero V
-	return zero, false
+	var v V
+	vv, ok := tx.snapshot.data.Get(key)
+	ok = ok && !vv.deleted
+	if ok {
+		v = tx.snapshotValue(key, vv.value)
+	}
+	if fn, pending := tx.merges[key]; pending {
+		merged := fn(v, ok)
+		tx.traceRead(key, merged, true, ReadFromMerge)
+		return merged, true
+	}
+	tx.traceRead(key, v, ok, ReadFromSnapshot)
+	return v, ok
+}
+
+// snapshotValue декодирует значение ключа из снапшота. Снапшот неизменяем,
+// поэтому с WithValueCodec результат кэшируется на время транзакции:
+// повторные Get одного ключа не платят за decode (например, распаковку
+// или копирование) снова. Кэш сбрасывается записью ключа и сменой
+// снапшота (NewStatement, Reset).
+func (tx *Tx[K, V]) snapshotValue(key K, raw V) V {
+	if tx.db.decode == nil {
+		return raw
+	}
+	if v, ok := tx.decoded[key]; ok {
+		return v
+	}
+	v := tx.db.decode(raw)
+	if tx.decoded == nil {
+		tx.decoded = make(map[K]V)
+	}
+	tx.decoded[key] = v
+	return v
 }
 
 // Put добавляет или обновляет значение в локальном write buffer.
 // Изменение не видно другим транзакциям до Commit.
 func (tx *Tx[K, V]) Put(key K, value V) error {
+	return tx.stage(key, versionedValue[V]{
+		value:      value,
+		writerTxID: tx.id,
+	})
+}
+
+// Swap записывает value и возвращает предыдущее видимое значение ключа
+// (с учётом собственных изменений транзакции), экономя отдельный Get.
+// Ключ записывается в readSet.
+func (tx *Tx[K, V]) Swap(key K, value V) (old V, existed bool, err error) {
+	if err := tx.checkActive(); err != nil {
+		return old, false, err
+	}
+	old, existed = tx.lookup(key)
+	if err := tx.Put(key, value); err != nil {
+		var zero V
+		return zero, false, err
+	}
+	return old, existed, nil
+}
+
+// DeleteIf удаляет ключ, только если он виден транзакции (с учётом
+// собственных изменений) и pred(v) == true. Возвращает, был ли ключ
+// удалён. Ключ записывается в readSet, поэтому под Serializable Commit
+// отклонится, если значение, на котором проверялся pred, успели изменить.
+func (tx *Tx[K, V]) DeleteIf(key K, pred func(V) bool) (bool, error) {
+	if err := tx.checkActive(); err != nil {
+		return false, err
+	}
+	v, ok := tx.lookup(key)
+	if !ok || !pred(v) {
+		return false, nil
+	}
+	if err := tx.Delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Rename переносит значение с ключа from на ключ to: from удаляется,
+// to получает его значение. Возвращает ErrKeyNotFound, если from не виден
+// транзакции, и ErrKeyExists, если to существует, а overwrite == false.
+// Оба ключа попадают в write buffer, поэтому конкурентное изменение любого
+// из них приводит к ErrConflict при Commit. from == to (после
+// WithKeyNormalizer) — no-op.
+func (tx *Tx[K, V]) Rename(from, to K, overwrite bool) error {
 	if err := tx.checkActive(); err != nil {
 		return err
 	}
-	if err := tx.ctx.Err(); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("%w: %w", ErrTxCanceled, err)
+	from, to = tx.db.normalizeKey(from), tx.db.normalizeKey(to)
+	v, ok := tx.lookup(from)
+	if !ok {
+		return fmt.Errorf("%w: rename source", ErrKeyNotFound)
+	}
+	if from == to {
+		return nil
 	}
+	if _, exists := tx.lookup(to); exists && !overwrite {
+		return fmt.Errorf("%w: rename target", ErrKeyExists)
+	}
+	if err := tx.Delete(from); err != nil {
+		return err
+	}
+	return tx.Put(to, v)
+}
 
-	tx.writes[key] = versionedValue[V]{
-		value:      value,
+// TransformAll применяет fn ко всем ключам, видимым транзакции (снапшот
+// с учётом собственных изменений): keep == true записывает возвращённое
+// значение, keep == false удаляет ключ. Порядок обхода не определён.
+// Все ключи попадают в write buffer и readSet, поэтому конкурентная
+// запись любого из них приводит к ErrConflict при Commit.
+func (tx *Tx[K, V]) TransformAll(fn func(K, V) (V, bool)) error {
+	if err := tx.checkActive(); err != nil {
+		return err
+	}
+
+	// Ключи собираем заранее: запись меняет tx.writes во время обхода.
+	if err := tx.flushStreams(); err != nil {
+		return err
+	}
+	keys := make([]K, 0, tx.snapshot.size+len(tx.writes)+len(tx.merges))
+	for k, vv := range tx.snapshot.data.Iterate {
+		if _, own := tx.writes[k]; !own && !vv.deleted {
+			keys = append(keys, k)
+		}
+	}
+	for k, vv := range tx.writes {
+		if !vv.deleted {
+			keys = append(keys, k)
+		}
+	}
+	for k := range tx.merges {
+		if vv, inSnap := tx.snapshot.data.Get(k); !inSnap || vv.deleted {
+			keys = append(keys, k)
+		}
+	}
+
+	for _, k := range keys {
+		v, _ := tx.lookup(k)
+		var err error
+		if nv, keep := fn(k, v); keep {
+			err = tx.Put(k, nv)
+		} else {
+			err = tx.Delete(k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pair — пара ключ-значение для пакетных операций.
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// PutPairs записывает пары по порядку. Повторяющиеся ключи разрешаются
+// по принципу last-write-wins. С WithStrictBatch(true) дубликаты — ошибка
+// ErrDuplicateKey, и тогда ни одна пара не записывается. Дубликатами
+// считаются и ключи, равные после WithKeyNormalizer.
+func (tx *Tx[K, V]) PutPairs(pairs []Pair[K, V]) error {
+	if tx.db.cfg.strictBatch {
+		seen := make(map[K]struct{}, len(pairs))
+		for _, p := range pairs {
+			key := tx.db.normalizeKey(p.Key)
+			if _, dup := seen[key]; dup {
+				return fmt.Errorf("%w: %v", ErrDuplicateKey, p.Key)
+			}
+			seen[key] = struct{}{}
+		}
+	}
+	for _, p := range pairs {
+		if err := tx.Put(p.Key, p.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete помечает ключ удалённым в локальном write buffer (tombstone).
+// Как и Put, участвует в write-write conflict detection при Commit.
+func (tx *Tx[K, V]) Delete(key K) error {
+	return tx.stage(key, versionedValue[V]{
 		writerTxID: tx.id,
+		deleted:    true,
+	})
+}
+
+// stage — общий путь Put и Delete: проверка состояния и контекста,
+// опциональная eager-проверка конфликта и запись в write buffer.
+func (tx *Tx[K, V]) stage(key K, vv versionedValue[V]) error {
+	if err := tx.checkActive(); err != nil {
+		return err
+	}
+	if err := tx.ctxErr(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	key = tx.db.normalizeKey(key)
+
+	// Повторная запись того же значения ничего не меняет.
+	if eq := tx.db.equals; eq != nil && !vv.deleted {
+		if prev, ok := tx.writes[key]; ok && !prev.deleted && eq(prev.value, vv.value) {
+			return nil
+		}
 	}
+
+	// Eager-проверка носит рекомендательный характер: она лишь даёт ранний
+	// сигнал. Транзакция остаётся активной, а авторитетная проверка всё
+	// равно выполняется в Commit под мьютексом.
+	if tx.opts.EagerConflictCheck {
+		current := tx.db.acquireCurrent()
+		changed := current.id > tx.snapshot.id && current.changedSince(tx.snapshot, key)
+		current.refCount.Add(-1)
+		if changed {
+			return fmt.Errorf("%w: key changed since snapshot (eager check)%s", ErrConflict, tx.labelSuffix())
+		}
+	}
+
+	delete(tx.merges, key) // Put/Delete отменяют отложенный Merge
+	delete(tx.streams, key)
+	delete(tx.decoded, key)
+	tx.writes[key] = vv
+	return nil
+}
+
+// SetReadOnly понижает транзакцию до read-only, если она ещё ничего
+// не записала: write buffer освобождается, последующие Put/Delete
+// возвращают ErrReadOnlyTx, а Commit идёт по дешёвому пути без новой
+// версии. Если записи уже есть, возвращает ErrTxHasWrites.
+func (tx *Tx[K, V]) SetReadOnly() error {
+	if err := tx.checkActive(); err != nil {
+		return err
+	}
+	if len(tx.writes) > 0 || len(tx.merges) > 0 || len(tx.streams) > 0 {
+		return ErrTxHasWrites
+	}
+	tx.writes = nil
+	tx.readOnly = true
 	return nil
 }
 
+// WriteSetKeys возвращает ключи из локального write buffer.
+// Срез — копия: внешние инструменты могут пересекать write set'ы
+// двух транзакций, чтобы предсказать конфликт, не влияя на сами транзакции.
+func (tx *Tx[K, V]) WriteSetKeys() []K {
+	keys := slices.Collect(maps.Keys(tx.writes))
+	for k := range tx.merges {
+		if _, ok := tx.writes[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for k := range tx.streams {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// changeSet раскладывает write buffer на записанные значения и удалённые ключи.
+func (tx *Tx[K, V]) changeSet() (map[K]V, []K) {
+	return changeSetOf(tx.writes)
+}
+
+// changeSetOf раскладывает write buffer на записанные значения и удаления.
+func changeSetOf[K comparable, V any](writes map[K]versionedValue[V]) (map[K]V, []K) {
+	changes := make(map[K]V, len(writes))
+	var deletes []K
+	for k, vv := range writes {
+		if vv.deleted {
+			deletes = append(deletes, k)
+		} else {
+			changes[k] = vv.value
+		}
+	}
+	return changes, deletes
+}
+
 // Commit пытается применить изменения транзакции к глобальному состоянию.
 // Возвращает ErrConflict, если другая транзакция изменила те же ключи
 // после нашего снапшота.
 func (tx *Tx[K, V]) Commit() error {
+	// Потоки PutStream вычитываются, пока транзакция ещё активна:
+	// материализация идёт обычным Put.
+	if len(tx.streams) > 0 || tx.streamErr != nil {
+		if err := tx.flushStreams(); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	tx.reapForced()
 	if !tx.state.CompareAndSwap(uint32(txActive), uint32(txCommitted)) {
-		return ErrTxDone
+		return tx.checkActive()
+	}
+	if err := tx.enterCommit(); err != nil {
+		return err
 	}
 
-	defer func() {
-		tx.cancel()
-		tx.db.unregisterTx(tx.id)
-		tx.snapshot.refCount.Add(-1)
-	}()
+	defer tx.finalize()
 
-	if err := tx.ctx.Err(); err != nil {
+	if err := tx.ctxErr(); err != nil {
 		tx.state.Store(uint32(txRolledBack))
-		return fmt.Errorf("%w: %w", ErrTxCanceled, err)
+		return err
+	}
+
+	if tx.db.closed.Load() {
+		tx.state.Store(uint32(txRolledBack))
+		return ErrClosed
+	}
+
+	tx.dropNoopWrites()
+	tx.auditReadSet()
+
+	// Read-only транзакция: конфликтовать нечем, новая версия не нужна.
+	// Такой Commit так же дёшев, как Rollback — только освобождение снапшота.
+	if len(tx.writes) == 0 && len(tx.merges) == 0 {
+		if tx.bump {
+			return tx.db.bumpVersion(tx)
+		}
+		return nil
 	}
 
 	// Делегируем конфликт-проверку и применение изменений в MVCCMap,
 	// т.к. только он владеет мьютексом над текущей версией.
-	return tx.db.commit(tx)
+	if err := tx.db.commit(tx); err != nil {
+		return err
+	}
+	tx.db.maybeInlineGC()
+	return nil
+}
+
+// dropNoopWrites убирает из write buffer записи значения, равного
+// значению в снапшоте (WithValueEquals): они ничего не меняют и не должны
+// участвовать в conflict detection. Если ключ тем временем изменил другой
+// коммит, транзакция сериализуется перед ним — как будто её запись
+// предшествовала чужой.
+func (tx *Tx[K, V]) dropNoopWrites() {
+	eq := tx.db.equals
+	if eq == nil {
+		return
+	}
+	for k, vv := range tx.writes {
+		if vv.deleted {
+			continue
+		}
+		if cur, ok := tx.snapshot.data.Get(k); ok && !cur.deleted && eq(tx.db.decodeValue(cur.value), vv.value) {
+			delete(tx.writes, k)
+		}
+	}
+}
+
+// auditReadSet сообщает о "слепых" записях (WithReadSetAudit): ключах
+// из write buffer, чьё прежнее значение транзакция не читала. Под
+// Serializable такие ключи не проверяются read-валидацией — часто это
+// зависимость, которую забыли прочитать. Только диагностика.
+func (tx *Tx[K, V]) auditReadSet() {
+	if !tx.db.cfg.readSetAudit || tx.db.cfg.isolation != Serializable {
+		return
+	}
+	var blind []K
+	for k := range tx.writes {
+		if _, read := tx.readSet[k]; !read {
+			blind = append(blind, k)
+		}
+	}
+	if len(blind) == 0 {
+		return
+	}
+	tx.db.logger.Warn("read-set audit: keys written without reading their prior value",
+		"txID", tx.id,
+		"label", tx.opts.Label,
+		"keys", blind,
+	)
+}
+
+// CommitBump коммитит транзакцию и возвращает ID установленной версии.
+// В отличие от Commit, read-only транзакция тоже создаёт новую версию —
+// дешёвый монотонный токен: данные новой версии разделяются с текущей
+// без clone. С записями работает как обычный Commit.
+func (tx *Tx[K, V]) CommitBump() (uint64, error) {
+	tx.bump = true
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return tx.commitVID, nil
+}
+
+// NewStatement начинает новый "оператор" транзакции: снапшот заменяется
+// текущей версией map, write buffer сохраняется. Чтения внутри оператора
+// согласованы, а между операторами видят свежие коммиты — семантика
+// между read committed и snapshot isolation, которую ожидают некоторые ORM.
+//
+// Ослабление изоляции касается только чтений: если ключ из write buffer
+// изменён чужим коммитом после прежнего снапшота, NewStatement возвращает
+// ErrConflict (иначе такая запись молча перетёрла бы чужую). Проверка
+// read set под Serializable после NewStatement ведётся от нового снапшота.
+func (tx *Tx[K, V]) NewStatement() error {
+	if err := tx.checkActive(); err != nil {
+		return err
+	}
+	if err := tx.ctxErr(); err != nil {
+		return err
+	}
+
+	next := tx.db.acquireCurrent()
+	if next == tx.snapshot {
+		next.refCount.Add(-1)
+		return nil
+	}
+	for key := range tx.writes {
+		if next.changedSince(tx.snapshot, key) {
+			next.refCount.Add(-1)
+			tx.db.reportConflict(tx, key, next)
+			return fmt.Errorf("%w: written key changed before new statement%s", ErrConflict, tx.labelSuffix())
+		}
+	}
+
+	// Замена снапшота под meta.mu: детектор, принудительно откатывающий
+	// жертву, снимает закрепление через meta.unpin под тем же мьютексом.
+	meta := tx.meta
+	meta.mu.Lock()
+	if meta.finalized.Load() {
+		meta.mu.Unlock()
+		next.refCount.Add(-1)
+		return tx.checkActive()
+	}
+	prev := tx.snapshot
+	tx.snapshot = next
+	meta.unpin = next.unpinOrphaned
+	meta.snapshotID.Store(next.id)
+	meta.mu.Unlock()
+	clear(tx.decoded)
+	prev.refCount.Add(-1)
+	return nil
+}
+
+// KeyConflict описывает конфликт по одному ключу: версию снапшота
+// транзакции (MineVersion) и версию, с которой она столкнулась (TheirsVersion).
+type KeyConflict[K comparable] struct {
+	Key           K
+	MineVersion   uint64
+	TheirsVersion uint64
+}
+
+// CommitOutcome — подробный результат CommitDetailed. Параметр V
+// повторяет параметр map, чтобы тип однозначно соответствовал Tx[K, V].
+type CommitOutcome[K comparable, V any] struct {
+	Succeeded bool
+	// VersionID — версия, установленная коммитом; 0 для read-only
+	// транзакции и при ошибке.
+	VersionID uint64
+	// Conflicts — конфликтующие ключи при ErrConflict: первый найденный
+	// или все, если включён WithFullConflictReport.
+	Conflicts []KeyConflict[K]
+	// Merged — ключи Merge, которые после снапшота транзакции изменил
+	// чужой коммит: обычная запись дала бы по ним ErrConflict, а Merge
+	// применил fn к новому значению. Заполняется только при успехе.
+	Merged []K
+}
+
+// CommitDetailed выполняет Commit и возвращает вместе с ошибкой подробный
+// результат: установленную версию или список конфликтующих ключей.
+// Outcome возвращается всегда, в том числе вместе с ошибкой.
+func (tx *Tx[K, V]) CommitDetailed() (*CommitOutcome[K, V], error) {
+	err := tx.Commit()
+	out := &CommitOutcome[K, V]{
+		Succeeded: err == nil,
+		Conflicts: slices.Clone(tx.conflicts),
+	}
+	if err == nil {
+		out.VersionID = tx.commitVID
+		out.Merged = slices.Clone(tx.merged)
+	}
+	return out, err
+}
+
+// Committable сообщает, имеет ли смысл продолжать транзакцию: false
+// и причина, если она уже завершена, её контекст отменён (в том числе
+// deadlock detector'ом) или map закрыта. Полную проверку конфликтов
+// не выполняет — её делает только Commit.
+func (tx *Tx[K, V]) Committable() (bool, error) {
+	if err := tx.checkActive(); err != nil {
+		return false, err
+	}
+	if err := tx.ctxErr(); err != nil {
+		return false, err
+	}
+	if tx.db.closed.Load() {
+		return false, ErrClosed
+	}
+	return true, nil
 }
 
 // Rollback отменяет транзакцию. Безопасно вызывать несколько раз
@@ -120,22 +824,147 @@ func (tx *Tx[K, V]) Rollback() {
 	if !tx.state.CompareAndSwap(uint32(txActive), uint32(txRolledBack)) {
 		return // уже завершена
 	}
+	tx.finalize()
+}
+
+// Reset переинициализирует завершённую транзакцию для повторного
+// использования: новый ID, свежий снапшот, пустые буферы (их память
+// переиспользуется) и прежние TxOptions. Удобно в горячих циклах
+// вместе с sync.Pool.
+//
+// Опасность: Reset допустим только после Commit/Rollback. Для активной
+// транзакции возвращается ErrTxActive — иначе её снапшот и запись в
+// activeTxs утекли бы. Все ссылки на транзакцию до Reset после него
+// указывают на новую транзакцию.
+func (tx *Tx[K, V]) Reset(ctx context.Context) error {
+	tx.reapForced()
+	if txState(tx.state.Load()) == txActive {
+		return ErrTxActive
+	}
+	if tx.writes == nil { // освобождён SetReadOnly
+		tx.writes = make(map[K]versionedValue[V])
+	}
+	clear(tx.writes)
+	clear(tx.readSet)
+	clear(tx.merges)
+	clear(tx.streams)
+	clear(tx.decoded)
+	tx.streamErr = nil
+	tx.readOnly = false
+	return tx.db.initTx(tx, ctx, tx.opts)
+}
+
+// finalize освобождает ресурсы транзакции: контекст, запись в activeTxs,
+// ссылку на снапшот и слот WithMaxConcurrentTx. Вызывается тем, кто
+// выиграл CAS из txActive (Commit или Rollback), — этого уже достаточно
+// для однократности. Флаг meta.finalized — вторая линия защиты: двойной
+// декремент refCount позволил бы GC удалить версию, которую ещё читают.
+// Тот же флаг разыгрывает детектор при принудительном откате жертвы.
+func (tx *Tx[K, V]) finalize() {
+	if !tx.meta.finalized.CompareAndSwap(false, true) {
+		return
+	}
 	tx.cancel()
 	tx.db.unregisterTx(tx.id)
 	tx.snapshot.refCount.Add(-1)
+	tx.db.releaseTxSlot()
+	if h := tx.db.latency; h != nil {
+		h.record(time.Since(tx.begunAt))
+	}
+}
+
+// ctxErr возвращает ошибку отмены контекста транзакции или nil.
+// Если транзакцию прервал deadlock detector или AbortOlderThan либо истёк
+// дедлайн WithDefaultTxContext, причина (ErrDeadlock, ErrTxTooOld,
+// DeadlineExceeded) сохраняется в цепочке рядом с ErrTxCanceled.
+func (tx *Tx[K, V]) ctxErr() error {
+	err := tx.ctx.Err()
+	if err == nil {
+		return nil
+	}
+	if cause := context.Cause(tx.ctx); errors.Is(cause, ErrDeadlock) || errors.Is(cause, ErrTxTooOld) || errors.Is(cause, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTxCanceled, cause)
+	}
+	return fmt.Errorf("%w: %w", ErrTxCanceled, err)
 }
 
 func (tx *Tx[K, V]) checkActive() error {
+	tx.reapForced()
 	if txState(tx.state.Load()) != txActive {
+		if tx.doneErr != nil {
+			return tx.doneErr
+		}
 		return ErrTxDone
 	}
 	return nil
 }
 
+// enterCommit отмечает в meta, что транзакция вошла в путь коммита:
+// с этого момента детектор не откатывает её принудительно, даже если она
+// зависнет (например, в хуке коммита), — ресурсы освободит её finalize.
+// Если детектор успел раньше, коммит отклоняется с его ошибкой.
+func (tx *Tx[K, V]) enterCommit() error {
+	meta := tx.meta
+	meta.mu.Lock()
+	defer meta.mu.Unlock()
+	if meta.forced.Load() {
+		tx.state.Store(uint32(txRolledBack))
+		tx.doneErr = meta.forcedErr
+		return meta.forcedErr
+	}
+	meta.committing = true
+	return nil
+}
+
+// reapForced завершает транзакцию, принудительно откаченную детектором
+// дедлоков (forceFinalize): ресурсы уже освобождены, остаётся перевести
+// состояние, чтобы операции возвращали ErrDeadlock. Вызывается только
+// горутиной-владельцем, поэтому doneErr пишется без синхронизации.
+func (tx *Tx[K, V]) reapForced() {
+	if tx.meta == nil || !tx.meta.forced.Load() {
+		return
+	}
+	if tx.state.CompareAndSwap(uint32(txActive), uint32(txRolledBack)) {
+		tx.doneErr = tx.meta.forcedErr
+	}
+}
+
 // txMeta — минимальные метаданные для deadlock detector,
 // без хранения полного Tx (избегаем циклических зависимостей в GC).
 type txMeta struct {
-	id      uint64
-	waitFor uint64 // ID транзакции, которую мы ждём (0 = никого)
-	mu      sync.Mutex
+	id       uint64
+	label    string    // TxOptions.Label
+	priority int       // TxOptions.Priority
+	begunAt  time.Time // для AbortOlderThan
+	waitFor  uint64    // ID транзакции, которую мы ждём (0 = никого)
+	mu       sync.Mutex
+
+	// snapshotID — ID версии текущего снапшота; меняется NewStatement,
+	// поэтому атомарный. Читается MinActiveSnapshot.
+	snapshotID atomic.Uint64
+
+	// abort отменяет контекст транзакции с указанной причиной.
+	// Deadlock detector прерывает жертву через abort(ErrDeadlock).
+	abort context.CancelCauseFunc
+
+	// abortedAt — когда транзакция выбрана жертвой дедлока (под mu).
+	abortedAt time.Time
+
+	// finalized — ресурсы этого воплощения транзакции уже освобождены:
+	// владельцем (Commit/Rollback) или детектором (WithDeadlockVictimGrace).
+	// Reset создаёт новый txMeta, поэтому устаревший meta не может
+	// завершить следующую транзакцию того же Tx.
+	finalized atomic.Bool
+	// release отменяет контекст транзакции; unpin снимает закрепление
+	// текущего снапшота, запрещая вернуть его карту в пул. unpin меняет
+	// NewStatement, поэтому он под mu.
+	release func()
+	unpin   func()
+	// forced — транзакцию откатил детектор; владелец узнаёт об этом
+	// при следующей операции (reapForced) и получает forcedErr.
+	forced    atomic.Bool
+	forcedErr error
+	// committing — владелец вошёл в Commit (enterCommit); такую
+	// транзакцию детектор не откатывает. Под mu.
+	committing bool
 }
@@ -1,33 +1,56 @@
 package mvcc
 
-import (
-	"maps"
-	"sync/atomic"
-)
+import "sync/atomic"
 
 // version представляет неизменяемый снимок данных.
 // Используем copy-on-write: коммит создаёт новую version,
 // не мутируя предыдущую — это обеспечивает lock-free чтение.
 type version[K comparable, V any] struct {
 	id   uint64
-	data map[K]versionedValue[V]
+	data Store[K, V] // WithStore; по умолчанию mapStore
+	size int         // число живых (не удалённых) ключей
 
 	// refCount позволяет GC-горутине понять, когда версию
 	// можно удалить. Атомик — чтобы не держать мьютекс при
 	// инкременте/декременте в BeginTx/Commit/Rollback.
 	refCount atomic.Int64
+
+	// base — для рабочей копии группового коммита: установленная версия,
+	// поверх которой она собрана (см. reportConflict). nil у обычных версий.
+	base *version[K, V]
+
+	// noRecycle — data нельзя вернуть в WithDataMapPool при сборке: она
+	// разделяется с другой версией (CommitBump) или её ещё может читать
+	// горутина принудительно откаченной жертвы дедлока.
+	noRecycle atomic.Bool
+}
+
+// unpinOrphaned снимает закрепление, оставляя data читателю, который
+// о снятии не знает (принудительно откаченная жертва дедлока): карта
+// не уйдёт в WithDataMapPool и будет освобождена сборщиком Go.
+func (v *version[K, V]) unpinOrphaned() {
+	v.noRecycle.Store(true)
+	v.refCount.Add(-1)
 }
 
 // versionedValue хранит значение и txID, который его записал.
 // txID нужен для write-write conflict detection:
 // если при коммите мы видим, что ключ изменён чужой транзакцией
 // после нашего снапшота — это конфликт.
+//
+// Удаление хранится как tombstone (deleted == true), а не как отсутствие
+// ключа: иначе конкурентный Delete был бы невидим для conflict detection.
+// Когда tombstone перестаёт быть нужен снапшотам, он удаляется из новых
+// версий (purgeTombstones).
 type versionedValue[V any] struct {
 	value      V
 	writerTxID uint64 // ID транзакции, совершившей запись
+	deleted    bool   // tombstone
+	commitTS   uint64 // логическое время коммита (только с WithCommitTimestamps)
+	hash       uint64 // хеш исходного значения (только с WithValueHasher)
 }
 
-func newVersion[K comparable, V any](id uint64, data map[K]versionedValue[V]) *version[K, V] {
+func newVersion[K comparable, V any](id uint64, data Store[K, V]) *version[K, V] {
 	v := &version[K, V]{
 		id:   id,
 		data: data,
@@ -36,8 +59,27 @@ func newVersion[K comparable, V any](id uint64, data map[K]versionedValue[V]) *v
 }
 
 // clone создаёт копию данных для нового коммита.
-// maps.Clone из Go 1.21 — shallow copy, что достаточно,
+// Для mapStore это maps.Clone — shallow copy, что достаточно,
 // т.к. V трактуется как value type (или неизменяемый указатель).
-func (v *version[K, V]) clone() map[K]versionedValue[V] {
-	return maps.Clone(v.data)
+//
+// Копируется только versionedValue: для слайсов, map и указателей это
+// заголовок фиксированного размера, а не содержимое, поэтому стоимость
+// клона пропорциональна числу ключей, а не размеру значений. Отдельное
+// хранилище значений с хэндлами в версиях ничего бы здесь не сэкономило.
+// Крупные value-типы (массивы, большие структуры) стоит хранить по указателю.
+func (v *version[K, V]) clone() Store[K, V] {
+	return v.data.Clone()
+}
+
+// changedSince сообщает, изменился ли ключ в v по сравнению со снапшотом
+// snap. Отсутствие ключа в v означает "не изменился": tombstone удаляется
+// из новых версий (purgeTombstones) только тогда, когда его содержит
+// каждый закреплённый снапшот.
+func (v *version[K, V]) changedSince(snap *version[K, V], key K) bool {
+	cur, ok := v.data.Get(key)
+	if !ok {
+		return false
+	}
+	old, inSnap := snap.data.Get(key)
+	return !inSnap || old.writerTxID != cur.writerTxID
 }
@@ -0,0 +1,90 @@
+package mvcc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultWatchBuffer — ёмкость канала подписчика WatchKeys по умолчанию.
+const defaultWatchBuffer = 64
+
+// KeyChange — событие WatchKeys: закоммиченное изменение ключа в версии
+// VersionID. Для удаления Deleted == true, а Value — нулевое значение.
+type KeyChange[K comparable, V any] struct {
+	Key       K
+	Value     V
+	Deleted   bool
+	VersionID uint64
+}
+
+// watcher — подписка WatchKeys на набор ключей.
+type watcher[K comparable, V any] struct {
+	keys map[K]struct{}
+	ch   chan KeyChange[K, V]
+}
+
+// watchRegistry хранит подписки. Отправка идёт под RLock, закрытие канала —
+// под Lock, поэтому в закрытый канал никто не пишет.
+type watchRegistry[K comparable, V any] struct {
+	mu    sync.RWMutex
+	set   map[*watcher[K, V]]struct{}
+	count atomic.Int32 // быстрый путь коммита без подписчиков
+}
+
+// notifyWatchers рассылает событие подписчикам ключа без блокировки: при
+// полном буфере событие отбрасывается и учитывается в Stats.WatchDropped.
+func (m *MVCCMap[K, V]) notifyWatchers(ev KeyChange[K, V]) {
+	r := &m.watchers
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for w := range r.set {
+		if _, ok := w.keys[ev.Key]; !ok {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+			m.stats.watchDropped.Add(1)
+		}
+	}
+}
+
+// WatchKeys возвращает один канал событий для всех keys: на каждый коммит,
+// изменивший (записавший или удаливший) один из ключей, приходит KeyChange
+// с закоммиченным значением. События приходят в порядке версий.
+//
+// Канал закрывается после отмены ctx или Close map. Медленный подписчик
+// теряет события сверх WithWatchBuffer (коммиты его не ждут) — для
+// восстановления состояния перечитайте ключи в новой транзакции.
+func (m *MVCCMap[K, V]) WatchKeys(ctx context.Context, keys ...K) <-chan KeyChange[K, V] {
+	w := &watcher[K, V]{
+		keys: make(map[K]struct{}, len(keys)),
+		ch:   make(chan KeyChange[K, V], m.cfg.watchBuffer),
+	}
+	for _, k := range keys {
+		w.keys[m.normalizeKey(k)] = struct{}{}
+	}
+
+	r := &m.watchers
+	r.mu.Lock()
+	if r.set == nil {
+		r.set = make(map[*watcher[K, V]]struct{})
+	}
+	r.set[w] = struct{}{}
+	r.count.Add(1)
+	r.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-m.gcDone: // Close
+		}
+		r.mu.Lock()
+		delete(r.set, w)
+		r.count.Add(-1)
+		close(w.ch)
+		r.mu.Unlock()
+	}()
+	return w.ch
+}